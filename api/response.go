@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/jwt"
 )
 
 func WriteTokenResponse(
@@ -32,6 +34,9 @@ func WriteTokenResponse(
 		Scopes       string           `json:"scope"`
 		OwnerId      interface{}      `json:"owner_id"`
 		Meta         oauth2.TokenMeta `json:"meta,omitempty"`
+
+		// Token exchange
+		IssuedTokenType oauth2.TokenTypeIdentifier `json:"issued_token_type,omitempty"`
 	}{
 		AccessToken: accessToken.Token,
 		TokenType:   oauth2.TokenTypeBearer,
@@ -40,6 +45,11 @@ func WriteTokenResponse(
 		Meta:        meta,
 	}
 
+	if exchangeMeta, ok := meta.(oauth2.TokenExchangeMeta); ok {
+		payload.IssuedTokenType = exchangeMeta.IssuedTokenType
+		payload.Meta = nil
+	}
+
 	if accessToken.OwnerId != "" {
 		payload.OwnerId = accessToken.OwnerId
 	}
@@ -90,3 +100,205 @@ func WriteErrorResponse(w http.ResponseWriter, err error) {
 
 	w.Write(body)
 }
+
+func WriteAuthorizationCodeResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	redirectUri string,
+	code *oauth2.AuthorizationCode,
+	state string,
+) {
+	params := url.Values{}
+	params.Set("code", code.Token)
+
+	if state != "" {
+		params.Set("state", state)
+	}
+
+	writeRedirect(w, r, redirectUri, params, false)
+}
+
+// The implicit grant returns the access token in the fragment of the redirect uri
+func WriteImplicitTokenResponse(
+	w http.ResponseWriter,
+	r *http.Request,
+	redirectUri string,
+	accessToken *oauth2.AccessToken,
+	state string,
+) {
+	params := url.Values{}
+	params.Set("access_token", accessToken.Token)
+	params.Set("token_type", string(oauth2.TokenTypeBearer))
+	params.Set("expires_in", fmt.Sprintf("%.0f", math.Floor(accessToken.GetExpiresIn())))
+	params.Set("scope", strings.Join(accessToken.Scopes, " "))
+
+	if state != "" {
+		params.Set("state", state)
+	}
+
+	writeRedirect(w, r, redirectUri, params, true)
+}
+
+func WriteDeviceAuthorizationResponse(w http.ResponseWriter, code *oauth2.DeviceCode, verificationUri string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	payload := struct {
+		DeviceCode              string  `json:"device_code"`
+		UserCode                string  `json:"user_code"`
+		VerificationUri         string  `json:"verification_uri"`
+		VerificationUriComplete string  `json:"verification_uri_complete,omitempty"`
+		ExpiresIn               float64 `json:"expires_in"`
+		Interval                float64 `json:"interval"`
+	}{
+		DeviceCode:      code.Token,
+		UserCode:        code.FormattedUserCode(),
+		VerificationUri: verificationUri,
+		ExpiresIn:       math.Floor(code.GetExpiresIn()),
+		Interval:        code.Interval.Seconds(),
+	}
+
+	if location, err := url.Parse(verificationUri); err == nil && verificationUri != "" {
+		query := location.Query()
+		query.Set("user_code", payload.UserCode)
+		location.RawQuery = query.Encode()
+
+		payload.VerificationUriComplete = location.String()
+	}
+
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		WriteErrorResponse(w, oauth2.NewError(oauth2.ServerErrorErr, "Failed to create device authorization response"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+type introspectionPayload struct {
+	Active    bool                     `json:"active"`
+	Scope     string                   `json:"scope,omitempty"`
+	ClientId  string                   `json:"client_id,omitempty"`
+	Subject   oauth2.OauthTokenOwnerId `json:"sub,omitempty"`
+	ExpiresAt int64                    `json:"exp,omitempty"`
+	TokenType string                   `json:"token_type,omitempty"`
+	Audience  jwt.Audience             `json:"aud,omitempty"`
+	Actor     *jwt.ActorClaims         `json:"act,omitempty"`
+}
+
+// WriteIntrospectionResponse writes {"active":false} if the token is nil, the token type is omitted if empty
+func WriteIntrospectionResponse(w http.ResponseWriter, token *oauth2.OauthToken, tokenType string) {
+	payload := &introspectionPayload{}
+
+	if token != nil {
+		payload.Active = true
+		payload.Scope = strings.Join(token.Scopes, " ")
+		payload.ClientId = token.ClientId
+		payload.Subject = token.OwnerId
+		payload.ExpiresAt = token.ExpiresAt.Unix()
+		payload.TokenType = tokenType
+	}
+
+	writeIntrospectionPayload(w, payload)
+}
+
+// WriteAccessTokenIntrospectionResponse also includes the audience and actor of exchanged tokens
+func WriteAccessTokenIntrospectionResponse(w http.ResponseWriter, token *oauth2.AccessToken) {
+	if token == nil {
+		WriteIntrospectionResponse(w, nil, "")
+		return
+	}
+
+	payload := &introspectionPayload{
+		Active:    true,
+		Scope:     strings.Join(token.Scopes, " "),
+		ClientId:  token.ClientId,
+		Subject:   token.OwnerId,
+		ExpiresAt: token.ExpiresAt.Unix(),
+		TokenType: string(oauth2.TokenTypeBearer),
+		Audience:  jwt.Audience(token.Audience),
+	}
+
+	if token.Actor != "" {
+		payload.Actor = &jwt.ActorClaims{Subject: token.Actor}
+	}
+
+	writeIntrospectionPayload(w, payload)
+}
+
+func writeIntrospectionPayload(w http.ResponseWriter, payload *introspectionPayload) {
+	w.Header().Set("Content-Type", "application/json")
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		WriteErrorResponse(w, oauth2.NewError(oauth2.ServerErrorErr, "Failed to create introspection response"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// WriteJwksResponse writes the public keys, symmetric keys are never published
+func WriteJwksResponse(w http.ResponseWriter, keys jwt.KeySet) {
+	body, err := json.Marshal(keys)
+	if err != nil {
+		WriteErrorResponse(w, oauth2.NewError(oauth2.ServerErrorErr, "Failed to create key set response"))
+		return
+	}
+
+	// Allow resource servers to cache the keys, the next key is published well before it's used
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// Errors from the authorization endpoint are returned to the client through the redirect uri,
+// the redirect uri MUST have been validated before calling this
+func WriteErrorRedirect(w http.ResponseWriter, r *http.Request, redirectUri, state string, useFragment bool, err error) {
+	oauthError, ok := err.(oauth2.OauthError)
+	if !ok {
+		oauthError = oauth2.OauthError{
+			Err:         oauth2.ServerErrorErr,
+			Description: err.Error(),
+		}
+	}
+
+	params := url.Values{}
+	params.Set("error", string(oauthError.Err))
+	params.Set("error_description", oauthError.Description)
+
+	if state != "" {
+		params.Set("state", state)
+	}
+
+	writeRedirect(w, r, redirectUri, params, useFragment)
+}
+
+func writeRedirect(w http.ResponseWriter, r *http.Request, redirectUri string, params url.Values, useFragment bool) {
+	location, err := url.Parse(redirectUri)
+	if err != nil {
+		WriteErrorResponse(w, oauth2.NewError(oauth2.InvalidRequestErr, "Invalid redirect uri"))
+		return
+	}
+
+	if useFragment {
+		location.Fragment = ""
+		location.RawFragment = ""
+
+		// Encoded parameters are a valid fragment, use the raw form to avoid escaping it again
+		http.Redirect(w, r, location.String()+"#"+params.Encode(), http.StatusFound)
+		return
+	}
+
+	// Keep any query the client registered as part of the redirect uri
+	query := location.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+
+	location.RawQuery = query.Encode()
+
+	http.Redirect(w, r, location.String(), http.StatusFound)
+}
@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"encoding/json"
+	"sync"
+
+	goredis "github.com/go-redis/redis"
+)
+
+type InvalidationKind string
+
+const (
+	InvalidationKindAccessToken  InvalidationKind = "access_token"
+	InvalidationKindRefreshToken InvalidationKind = "refresh_token"
+	InvalidationKindFamily       InvalidationKind = "family"
+)
+
+// Invalidation of a cached token, or all tokens of a family
+type Invalidation struct {
+	Kind  InvalidationKind `json:"kind"`
+	Value string           `json:"value"`
+}
+
+// Invalidator broadcasts invalidations so the caches of other nodes drop revoked tokens
+type Invalidator interface {
+	// Publish the invalidations to all nodes, it is fine if they are also delivered to this node
+	Publish(invalidations ...Invalidation) error
+
+	// Subscribe registers the handler called with invalidations published by any node
+	Subscribe(handler func(invalidations ...Invalidation))
+}
+
+type localInvalidator struct{}
+
+// NewLocalInvalidator does not broadcast anything, for a single node
+func NewLocalInvalidator() Invalidator {
+	return &localInvalidator{}
+}
+
+func (invalidator *localInvalidator) Publish(invalidations ...Invalidation) error {
+	return nil
+}
+
+func (invalidator *localInvalidator) Subscribe(handler func(invalidations ...Invalidation)) {
+}
+
+// RedisInvalidator broadcasts invalidations with redis pub/sub, all handlers share one subscription
+type RedisInvalidator struct {
+	client  goredis.UniversalClient
+	channel string
+
+	mutex    sync.RWMutex
+	pubSub   *goredis.PubSub
+	handlers []func(invalidations ...Invalidation)
+}
+
+func NewRedisInvalidator(client goredis.UniversalClient, channel string) *RedisInvalidator {
+	if client == nil {
+		panic("No redis client given to redis invalidator")
+	}
+
+	return &RedisInvalidator{
+		client:  client,
+		channel: channel,
+	}
+}
+
+func (invalidator *RedisInvalidator) Publish(invalidations ...Invalidation) error {
+	message, err := json.Marshal(invalidations)
+	if err != nil {
+		return err
+	}
+
+	return invalidator.client.Publish(invalidator.channel, message).Err()
+}
+
+// Subscribe adds the handler, the subscription is started by the first handler and receives
+// invalidations in the background until Close is called
+func (invalidator *RedisInvalidator) Subscribe(handler func(invalidations ...Invalidation)) {
+	invalidator.mutex.Lock()
+	defer invalidator.mutex.Unlock()
+
+	invalidator.handlers = append(invalidator.handlers, handler)
+
+	if invalidator.pubSub != nil {
+		return
+	}
+
+	invalidator.pubSub = invalidator.client.Subscribe(invalidator.channel)
+
+	go invalidator.receive(invalidator.pubSub.Channel())
+}
+
+func (invalidator *RedisInvalidator) receive(messages <-chan *goredis.Message) {
+	for message := range messages {
+		invalidations := make([]Invalidation, 0)
+		if err := json.Unmarshal([]byte(message.Payload), &invalidations); err != nil {
+			continue
+		}
+
+		invalidator.mutex.RLock()
+		handlers := invalidator.handlers
+		invalidator.mutex.RUnlock()
+
+		for _, handler := range handlers {
+			handler(invalidations...)
+		}
+	}
+}
+
+// Close stops the subscription, handlers are no longer called
+func (invalidator *RedisInvalidator) Close() error {
+	invalidator.mutex.Lock()
+	defer invalidator.mutex.Unlock()
+
+	if invalidator.pubSub == nil {
+		return nil
+	}
+
+	err := invalidator.pubSub.Close()
+	invalidator.pubSub = nil
+	invalidator.handlers = nil
+
+	return err
+}
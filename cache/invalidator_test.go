@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis"
+)
+
+func TestRedisInvalidator(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+
+	invalidator := NewRedisInvalidator(client, "invalidations")
+	defer invalidator.Close()
+
+	received := make(chan Invalidation, 2)
+
+	// Both handlers must be called from the single subscription
+	for i := 0; i < 2; i++ {
+		invalidator.Subscribe(func(invalidations ...Invalidation) {
+			for _, invalidation := range invalidations {
+				received <- invalidation
+			}
+		})
+	}
+
+	// The subscription is established in the background
+	deadline := time.Now().Add(time.Second)
+	for len(server.PubSubChannels("")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	expected := Invalidation{Kind: InvalidationKindAccessToken, Value: "token"}
+	if err := invalidator.Publish(expected); err != nil {
+		t.Fatalf("Publish failed: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case invalidation := <-received:
+			if invalidation != expected {
+				t.Errorf("Received %v, expected %v", invalidation, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Handler %d was not called", i+1)
+		}
+	}
+
+	if err := invalidator.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	deadline = time.Now().Add(time.Second)
+	for len(server.PubSubChannels("")) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if channels := server.PubSubChannels(""); len(channels) > 0 {
+		t.Errorf("Subscription is still open after Close: %v", channels)
+	}
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+)
+
+type entry struct {
+	key       string
+	familyId  string
+	expiresAt time.Time
+
+	// Nil for cached misses
+	value interface{}
+}
+
+// Bounded LRU with per entry expiry, NOT safe for concurrent use
+type lru struct {
+	size     int
+	entries  map[string]*list.Element
+	families map[string]map[string]struct{}
+	order    *list.List
+}
+
+func newLru(size int) *lru {
+	return &lru{
+		size:     size,
+		entries:  map[string]*list.Element{},
+		families: map[string]map[string]struct{}{},
+		order:    list.New(),
+	}
+}
+
+// Get the unexpired entry, expired entries are removed
+func (cache *lru) get(key string, now time.Time) (*entry, bool) {
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := element.Value.(*entry)
+	if !now.Before(e.expiresAt) {
+		cache.remove(key)
+		return nil, false
+	}
+
+	cache.order.MoveToFront(element)
+
+	return e, true
+}
+
+// Add the entry, returns the number of evicted entries
+func (cache *lru) add(e *entry) int {
+	cache.remove(e.key)
+
+	cache.entries[e.key] = cache.order.PushFront(e)
+
+	if e.familyId != "" {
+		if cache.families[e.familyId] == nil {
+			cache.families[e.familyId] = map[string]struct{}{}
+		}
+
+		cache.families[e.familyId][e.key] = struct{}{}
+	}
+
+	evicted := 0
+	for cache.size > 0 && cache.order.Len() > cache.size {
+		cache.remove(cache.order.Back().Value.(*entry).key)
+		evicted++
+	}
+
+	return evicted
+}
+
+func (cache *lru) remove(key string) {
+	element, ok := cache.entries[key]
+	if !ok {
+		return
+	}
+
+	e := element.Value.(*entry)
+
+	delete(cache.entries, key)
+	cache.order.Remove(element)
+
+	if family := cache.families[e.familyId]; family != nil {
+		delete(family, key)
+
+		if len(family) == 0 {
+			delete(cache.families, e.familyId)
+		}
+	}
+}
+
+func (cache *lru) removeFamily(familyId string) {
+	for key := range cache.families[familyId] {
+		cache.remove(key)
+	}
+}
@@ -0,0 +1,428 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+var RepositoryDefaultConfig = RepositoryConfig{
+	Size:               10000,
+	Ttl:                time.Minute,
+	NegativeTtl:        5 * time.Second,
+	CacheRefreshTokens: false,
+	Invalidator:        nil,
+}
+
+type RepositoryConfig struct {
+	// Maximum number of cached tokens and misses, zero means no limit
+	Size int
+
+	// How long tokens are cached, never past their expiry
+	Ttl time.Duration
+
+	// How long unknown tokens are cached, zero disables caching misses
+	NegativeTtl time.Duration
+
+	// Refresh tokens change when rotated, other nodes may use a stale token until the
+	// invalidation arrives so they are not cached by default
+	CacheRefreshTokens bool
+
+	// Broadcasts invalidations to other nodes, nil for a single node
+	Invalidator Invalidator
+}
+
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// TokenRepository caches token lookups of another repository. Deleting or changing a token
+// invalidates it locally and through the invalidator on all other nodes.
+type TokenRepository struct {
+	repository oauth2.ContextTokenRepository
+	config     RepositoryConfig
+
+	mutex sync.Mutex
+	cache *lru
+
+	// Incremented by every invalidation, lookups that raced an invalidation are not stored
+	generation uint64
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+func NewTokenRepository(repository oauth2.TokenRepository, config RepositoryConfig) *TokenRepository {
+	if repository == nil {
+		panic("No token repository given to caching token repository")
+	}
+
+	if config.Invalidator == nil {
+		config.Invalidator = NewLocalInvalidator()
+	}
+
+	cached := &TokenRepository{
+		repository: oauth2.NewContextTokenRepository(repository),
+		config:     config,
+		cache:      newLru(config.Size),
+	}
+
+	config.Invalidator.Subscribe(cached.invalidateLocal)
+
+	return cached
+}
+
+// Stats returns the hits, misses and evictions since the repository was created
+func (repository *TokenRepository) Stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&repository.hits),
+		Misses:    atomic.LoadUint64(&repository.misses),
+		Evictions: atomic.LoadUint64(&repository.evictions),
+	}
+}
+
+func cacheKey(kind InvalidationKind, token string) string {
+	return string(kind) + ":" + token
+}
+
+// Look up a cached token, found reports whether the token or a miss was cached. The generation
+// is passed to store when the token is loaded from the repository
+func (repository *TokenRepository) lookup(key string) (value interface{}, found bool, generation uint64) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	e, ok := repository.cache.get(key, time.Now())
+	if !ok {
+		atomic.AddUint64(&repository.misses, 1)
+		return nil, false, repository.generation
+	}
+
+	atomic.AddUint64(&repository.hits, 1)
+
+	return e.value, true, repository.generation
+}
+
+func (repository *TokenRepository) store(generation uint64, key string, token *oauth2.OauthToken, value interface{}) {
+	now := time.Now()
+
+	e := &entry{key: key}
+	if token == nil {
+		if repository.config.NegativeTtl <= 0 {
+			return
+		}
+
+		e.expiresAt = now.Add(repository.config.NegativeTtl)
+	} else {
+		e.familyId = token.FamilyId
+		if e.familyId == "" {
+			e.familyId = noFamilyId(token.ClientId, token.OwnerId)
+		}
+		e.value = value
+		e.expiresAt = now.Add(repository.config.Ttl)
+
+		if token.ExpiresAt.Before(e.expiresAt) {
+			e.expiresAt = token.ExpiresAt
+		}
+	}
+
+	if !now.Before(e.expiresAt) {
+		return
+	}
+
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	// The token may have been deleted while it was loaded
+	if generation != repository.generation {
+		return
+	}
+
+	atomic.AddUint64(&repository.evictions, uint64(repository.cache.add(e)))
+}
+
+func (repository *TokenRepository) invalidateLocal(invalidations ...Invalidation) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	repository.generation++
+
+	for _, invalidation := range invalidations {
+		if invalidation.Kind == InvalidationKindFamily {
+			repository.cache.removeFamily(invalidation.Value)
+		} else {
+			repository.cache.remove(cacheKey(invalidation.Kind, invalidation.Value))
+		}
+	}
+}
+
+// Invalidate locally before publishing so this node never serves the old token. The change has
+// already been made in the repository, a failed publish is logged since other nodes drop the token
+// when their cache ttl expires
+func (repository *TokenRepository) invalidate(invalidations ...Invalidation) {
+	repository.invalidateLocal(invalidations...)
+
+	if err := repository.config.Invalidator.Publish(invalidations...); err != nil {
+		log.Printf("cache: failed to publish token invalidations: %v", err)
+	}
+}
+
+func (repository *TokenRepository) CreateAccessToken(token *oauth2.AccessToken) error {
+	return repository.CreateAccessTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) CreateAccessTokenContext(ctx context.Context, token *oauth2.AccessToken) error {
+	if err := repository.repository.CreateAccessTokenContext(ctx, token); err != nil {
+		return err
+	}
+
+	// Drop a cached miss of the token
+	repository.invalidateLocal(Invalidation{Kind: InvalidationKindAccessToken, Value: token.Token})
+
+	return nil
+}
+
+func (repository *TokenRepository) CreateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.CreateRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) CreateRefreshTokenContext(ctx context.Context, token *oauth2.RefreshToken) error {
+	if err := repository.repository.CreateRefreshTokenContext(ctx, token); err != nil {
+		return err
+	}
+
+	repository.invalidateLocal(Invalidation{Kind: InvalidationKindRefreshToken, Value: token.Token})
+
+	return nil
+}
+
+func (repository *TokenRepository) CreateAuthorizationCode(code *oauth2.AuthorizationCode) error {
+	return repository.CreateAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *TokenRepository) CreateAuthorizationCodeContext(ctx context.Context, code *oauth2.AuthorizationCode) error {
+	return repository.repository.CreateAuthorizationCodeContext(ctx, code)
+}
+
+func (repository *TokenRepository) GetAccessToken(token string) (*oauth2.AccessToken, error) {
+	return repository.GetAccessTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) GetAccessTokenContext(ctx context.Context, token string) (*oauth2.AccessToken, error) {
+	key := cacheKey(InvalidationKindAccessToken, token)
+
+	value, found, generation := repository.lookup(key)
+	if found {
+		if value == nil {
+			return nil, oauth2.AccessTokenNotFoundErr
+		}
+
+		return copyAccessToken(value.(*oauth2.AccessToken)), nil
+	}
+
+	accessToken, err := repository.repository.GetAccessTokenContext(ctx, token)
+	if err == oauth2.AccessTokenNotFoundErr {
+		repository.store(generation, key, nil, nil)
+	}
+
+	if err != nil || accessToken == nil {
+		return accessToken, err
+	}
+
+	repository.store(generation, key, accessToken.OauthToken, copyAccessToken(accessToken))
+
+	return accessToken, nil
+}
+
+func (repository *TokenRepository) GetRefreshToken(token string) (*oauth2.RefreshToken, error) {
+	return repository.GetRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) GetRefreshTokenContext(ctx context.Context, token string) (*oauth2.RefreshToken, error) {
+	if !repository.config.CacheRefreshTokens {
+		return repository.repository.GetRefreshTokenContext(ctx, token)
+	}
+
+	key := cacheKey(InvalidationKindRefreshToken, token)
+
+	value, found, generation := repository.lookup(key)
+	if found {
+		if value == nil {
+			return nil, oauth2.RefreshTokenNotFoundErr
+		}
+
+		return copyRefreshToken(value.(*oauth2.RefreshToken)), nil
+	}
+
+	refreshToken, err := repository.repository.GetRefreshTokenContext(ctx, token)
+	if err == oauth2.RefreshTokenNotFoundErr {
+		repository.store(generation, key, nil, nil)
+	}
+
+	if err != nil || refreshToken == nil {
+		return refreshToken, err
+	}
+
+	repository.store(generation, key, refreshToken.OauthToken, copyRefreshToken(refreshToken))
+
+	return refreshToken, nil
+}
+
+func (repository *TokenRepository) GetAuthorizationCode(code string) (*oauth2.AuthorizationCode, error) {
+	return repository.GetAuthorizationCodeContext(context.Background(), code)
+}
+
+// Authorization codes are used once, there is nothing to gain from caching them
+func (repository *TokenRepository) GetAuthorizationCodeContext(ctx context.Context, code string) (*oauth2.AuthorizationCode, error) {
+	return repository.repository.GetAuthorizationCodeContext(ctx, code)
+}
+
+func (repository *TokenRepository) DeleteAccessToken(token string) error {
+	return repository.DeleteAccessTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) DeleteAccessTokenContext(ctx context.Context, token string) error {
+	if err := repository.repository.DeleteAccessTokenContext(ctx, token); err != nil {
+		return err
+	}
+
+	repository.invalidate(Invalidation{Kind: InvalidationKindAccessToken, Value: token})
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteRefreshToken(token string) error {
+	return repository.DeleteRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) DeleteRefreshTokenContext(ctx context.Context, token string) error {
+	if err := repository.repository.DeleteRefreshTokenContext(ctx, token); err != nil {
+		return err
+	}
+
+	repository.invalidate(Invalidation{Kind: InvalidationKindRefreshToken, Value: token})
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteAuthorizationCode(code string) error {
+	return repository.DeleteAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *TokenRepository) DeleteAuthorizationCodeContext(ctx context.Context, code string) error {
+	return repository.repository.DeleteAuthorizationCodeContext(ctx, code)
+}
+
+// Expired tokens are never served from the cache, there is nothing to invalidate
+func (repository *TokenRepository) DeleteExpiredAccessTokens() error {
+	return repository.DeleteExpiredAccessTokensContext(context.Background())
+}
+
+func (repository *TokenRepository) DeleteExpiredAccessTokensContext(ctx context.Context) error {
+	return repository.repository.DeleteExpiredAccessTokensContext(ctx)
+}
+
+func (repository *TokenRepository) DeleteExpiredRefreshTokens() error {
+	return repository.DeleteExpiredRefreshTokensContext(context.Background())
+}
+
+func (repository *TokenRepository) DeleteExpiredRefreshTokensContext(ctx context.Context) error {
+	return repository.repository.DeleteExpiredRefreshTokensContext(ctx)
+}
+
+func (repository *TokenRepository) DeleteExpiredAuthorizationCodes() error {
+	return repository.DeleteExpiredAuthorizationCodesContext(context.Background())
+}
+
+func (repository *TokenRepository) DeleteExpiredAuthorizationCodesContext(ctx context.Context) error {
+	return repository.repository.DeleteExpiredAuthorizationCodesContext(ctx)
+}
+
+func (repository *TokenRepository) RotateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.RotateRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) RotateRefreshTokenContext(ctx context.Context, token *oauth2.RefreshToken) error {
+	err := repository.repository.RotateRefreshTokenContext(ctx, token)
+
+	// A lost rotation means another node changed the token, drop it either way
+	repository.invalidate(Invalidation{Kind: InvalidationKindRefreshToken, Value: token.Token})
+
+	return err
+}
+
+func (repository *TokenRepository) UpdateRefreshTokenExpiresAt(token string, expiresAt time.Time) error {
+	return repository.UpdateRefreshTokenExpiresAtContext(context.Background(), token, expiresAt)
+}
+
+func (repository *TokenRepository) UpdateRefreshTokenExpiresAtContext(ctx context.Context, token string, expiresAt time.Time) error {
+	if err := repository.repository.UpdateRefreshTokenExpiresAtContext(ctx, token, expiresAt); err != nil {
+		return err
+	}
+
+	repository.invalidate(Invalidation{Kind: InvalidationKindRefreshToken, Value: token})
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteTokenFamily(familyId string) error {
+	return repository.DeleteTokenFamilyContext(context.Background(), familyId)
+}
+
+func (repository *TokenRepository) DeleteTokenFamilyContext(ctx context.Context, familyId string) error {
+	if err := repository.repository.DeleteTokenFamilyContext(ctx, familyId); err != nil {
+		return err
+	}
+
+	repository.invalidate(Invalidation{Kind: InvalidationKindFamily, Value: familyId})
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteAccessTokensWithoutFamily(clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	return repository.DeleteAccessTokensWithoutFamilyContext(context.Background(), clientId, ownerId)
+}
+
+func (repository *TokenRepository) DeleteAccessTokensWithoutFamilyContext(ctx context.Context, clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	if err := repository.repository.DeleteAccessTokensWithoutFamilyContext(ctx, clientId, ownerId); err != nil {
+		return err
+	}
+
+	repository.invalidate(Invalidation{Kind: InvalidationKindFamily, Value: noFamilyId(clientId, ownerId)})
+
+	return nil
+}
+
+// Tokens without a family are cached as a family of their client and owner, so they can be
+// invalidated together. Family ids are uuids and never collide with it
+func noFamilyId(clientId string, ownerId oauth2.OauthTokenOwnerId) string {
+	return "none:" + clientId + ":" + string(ownerId)
+}
+
+func copyOauthToken(token *oauth2.OauthToken) *oauth2.OauthToken {
+	tokenCopy := *token
+	tokenCopy.Scopes = append([]string{}, token.Scopes...)
+
+	return &tokenCopy
+}
+
+func copyAccessToken(token *oauth2.AccessToken) *oauth2.AccessToken {
+	tokenCopy := *token
+	tokenCopy.OauthToken = copyOauthToken(token.OauthToken)
+	tokenCopy.Audience = append([]string(nil), token.Audience...)
+
+	return &tokenCopy
+}
+
+func copyRefreshToken(token *oauth2.RefreshToken) *oauth2.RefreshToken {
+	tokenCopy := *token
+	tokenCopy.OauthToken = copyOauthToken(token.OauthToken)
+
+	return &tokenCopy
+}
@@ -0,0 +1,15 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/token/memory"
+	"github.com/interactive-solutions/go-oauth2/token/tokentest"
+)
+
+func TestTokenRepository(t *testing.T) {
+	tokentest.TestTokenRepository(t, func(t *testing.T) oauth2.TokenRepository {
+		return NewTokenRepository(memory.NewTokenRepository(memory.RepositoryDefaultConfig), RepositoryDefaultConfig)
+	})
+}
@@ -1,5 +1,7 @@
 package oauth2
 
+import "github.com/interactive-solutions/go-oauth2/jwt"
+
 var (
 	ServerDefaultConfig = ServerConfig{
 		Grants: map[GrantType]OauthGrant{},
@@ -8,6 +10,18 @@ var (
 				Err:         InvalidGrantErr,
 				Description: "Refresh has expired or been deleted",
 			},
+			AuthorizationCodeNotFoundErr: {
+				Err:         InvalidGrantErr,
+				Description: "Authorization code has expired or already been used",
+			},
+			DeviceCodeNotFoundErr: {
+				Err:         InvalidGrantErr,
+				Description: "Device code has expired or already been used",
+			},
+			JwtIdAlreadyUsedErr: {
+				Err:         InvalidGrantErr,
+				Description: "Assertion has already been used",
+			},
 		},
 		ClientAuthorizedHandler: func(clientId, clientSecret string) (bool, error) {
 			return true, nil
@@ -32,6 +46,16 @@ var (
 			return nil
 		},
 
+		CallbackRefreshTokenReuse: func(refreshToken *RefreshToken, ipAddr string) {
+
+		},
+
+		TokenGenerator:         DefaultTokenGenerator,
+		TokenGenerationRetries: 3,
+
+		PkceRequirement:    PkceOptional,
+		AllowImplicitGrant: false,
+
 		IsBehindProxy: false,
 		ProxyIpHeader: "X-Forwarded-For",
 	}
@@ -47,6 +71,35 @@ type ServerConfig struct {
 	// Can client access scope
 	ClientScopeHandler func(clientId string, scopes []string) (bool, error)
 
+	// Is the redirect uri registered for the client, also used to verify that the client exists
+	// when handling authorization requests since no client secret is provided to that endpoint.
+	// Authorization requests are rejected if no handler is set, see NewRegisteredRedirectUriHandler
+	ClientRedirectUriHandler func(clientId, redirectUri string) (bool, error)
+
+	// Is the client a public client, if no handler is set all clients are treated as public
+	ClientPublicHandler func(clientId string) (bool, error)
+
+	// Generates access tokens, refresh tokens and codes, DefaultTokenGenerator is used if nil
+	TokenGenerator TokenGenerator
+
+	// How many times a new token is generated when the repository reports it already exists
+	TokenGenerationRetries int
+
+	// Should authorization requests be required to use PKCE
+	PkceRequirement PkceRequirement
+
+	// Allow response_type=token, the implicit grant is deprecated by OAuth 2.1 and disabled by default
+	AllowImplicitGrant bool
+
+	// Issue self-contained JWT access tokens (RFC 9068) signed by the signer instead of opaque tokens,
+	// the tokens are still persisted so introspection and revocation keep working
+	AccessTokenSigner   jwt.Signer
+	AccessTokenIssuer   string
+	AccessTokenAudience []string
+
+	// Keys published by HandleJwksRequest, e.g. a keys.Manager that is also used as signer
+	AccessTokenKeys jwt.KeyProvider
+
 	// Error map
 	ErrorMap map[error]OauthError
 
@@ -54,6 +107,14 @@ type ServerConfig struct {
 	CallbackPreGrant               CallbackPreGrant
 	CallbackPrePersistAccessToken  CallbackPrePersistAccessToken
 	CallbackPrePersistRefreshToken CallbackPrePersistRefreshToken
+	CallbackRefreshTokenReuse      CallbackRefreshTokenReuse
+
+	// Context aware callbacks, when set they are called instead of the callbacks above
+	CallbackPreGrantContext               CallbackPreGrantContext
+	CallbackPostGrantContext              CallbackPostGrantContext
+	CallbackPrePersistAccessTokenContext  CallbackPrePersistAccessTokenContext
+	CallbackPrePersistRefreshTokenContext CallbackPrePersistRefreshTokenContext
+	CallbackRefreshTokenReuseContext      CallbackRefreshTokenReuseContext
 
 	// If the server is hiding behind a reverse proxy thus check the headers first
 	IsBehindProxy bool
@@ -0,0 +1,192 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// ContextTokenRepository is TokenRepository with a context passed to every method, so cancellation,
+// deadlines and tracing reach the storage. A repository may implement both interfaces.
+type ContextTokenRepository interface {
+	CreateAccessTokenContext(ctx context.Context, token *AccessToken) error
+	CreateRefreshTokenContext(ctx context.Context, token *RefreshToken) error
+
+	CreateAuthorizationCodeContext(ctx context.Context, code *AuthorizationCode) error
+
+	GetAccessTokenContext(ctx context.Context, token string) (*AccessToken, error)
+	GetRefreshTokenContext(ctx context.Context, token string) (*RefreshToken, error)
+	GetAuthorizationCodeContext(ctx context.Context, code string) (*AuthorizationCode, error)
+
+	DeleteAccessTokenContext(ctx context.Context, token string) error
+	DeleteRefreshTokenContext(ctx context.Context, token string) error
+	DeleteAuthorizationCodeContext(ctx context.Context, code string) error
+	DeleteExpiredAccessTokensContext(ctx context.Context) error
+	DeleteExpiredRefreshTokensContext(ctx context.Context) error
+	DeleteExpiredAuthorizationCodesContext(ctx context.Context) error
+
+	RotateRefreshTokenContext(ctx context.Context, token *RefreshToken) error
+	UpdateRefreshTokenExpiresAtContext(ctx context.Context, token string, expiresAt time.Time) error
+	DeleteTokenFamilyContext(ctx context.Context, familyId string) error
+	DeleteAccessTokensWithoutFamilyContext(ctx context.Context, clientId string, ownerId OauthTokenOwnerId) error
+}
+
+// NewContextTokenRepository returns the repository itself if it is context aware, otherwise the
+// context is dropped before calling the repository
+func NewContextTokenRepository(repository TokenRepository) ContextTokenRepository {
+	if contextRepository, ok := repository.(ContextTokenRepository); ok {
+		return contextRepository
+	}
+
+	return &contextTokenRepository{repository: repository}
+}
+
+// NewBackgroundTokenRepository allows a repository that is only context aware to be used as a
+// TokenRepository, the background context is used for all calls
+func NewBackgroundTokenRepository(repository ContextTokenRepository) TokenRepository {
+	return &backgroundTokenRepository{repository: repository}
+}
+
+// ContextOauthGrant is implemented by grants that pass the context on to storage and handlers,
+// the server calls CreateTokensContext with the context of the request
+type ContextOauthGrant interface {
+	OauthGrant
+
+	CreateTokensContext(ctx context.Context, r *http.Request, clientId string) (*AccessToken, *RefreshToken, TokenMeta, error)
+}
+
+type contextTokenRepository struct {
+	repository TokenRepository
+}
+
+func (adapter *contextTokenRepository) CreateAccessTokenContext(ctx context.Context, token *AccessToken) error {
+	return adapter.repository.CreateAccessToken(token)
+}
+
+func (adapter *contextTokenRepository) CreateRefreshTokenContext(ctx context.Context, token *RefreshToken) error {
+	return adapter.repository.CreateRefreshToken(token)
+}
+
+func (adapter *contextTokenRepository) CreateAuthorizationCodeContext(ctx context.Context, code *AuthorizationCode) error {
+	return adapter.repository.CreateAuthorizationCode(code)
+}
+
+func (adapter *contextTokenRepository) GetAccessTokenContext(ctx context.Context, token string) (*AccessToken, error) {
+	return adapter.repository.GetAccessToken(token)
+}
+
+func (adapter *contextTokenRepository) GetRefreshTokenContext(ctx context.Context, token string) (*RefreshToken, error) {
+	return adapter.repository.GetRefreshToken(token)
+}
+
+func (adapter *contextTokenRepository) GetAuthorizationCodeContext(ctx context.Context, code string) (*AuthorizationCode, error) {
+	return adapter.repository.GetAuthorizationCode(code)
+}
+
+func (adapter *contextTokenRepository) DeleteAccessTokenContext(ctx context.Context, token string) error {
+	return adapter.repository.DeleteAccessToken(token)
+}
+
+func (adapter *contextTokenRepository) DeleteRefreshTokenContext(ctx context.Context, token string) error {
+	return adapter.repository.DeleteRefreshToken(token)
+}
+
+func (adapter *contextTokenRepository) DeleteAuthorizationCodeContext(ctx context.Context, code string) error {
+	return adapter.repository.DeleteAuthorizationCode(code)
+}
+
+func (adapter *contextTokenRepository) DeleteExpiredAccessTokensContext(ctx context.Context) error {
+	return adapter.repository.DeleteExpiredAccessTokens()
+}
+
+func (adapter *contextTokenRepository) DeleteExpiredRefreshTokensContext(ctx context.Context) error {
+	return adapter.repository.DeleteExpiredRefreshTokens()
+}
+
+func (adapter *contextTokenRepository) DeleteExpiredAuthorizationCodesContext(ctx context.Context) error {
+	return adapter.repository.DeleteExpiredAuthorizationCodes()
+}
+
+func (adapter *contextTokenRepository) RotateRefreshTokenContext(ctx context.Context, token *RefreshToken) error {
+	return adapter.repository.RotateRefreshToken(token)
+}
+
+func (adapter *contextTokenRepository) UpdateRefreshTokenExpiresAtContext(ctx context.Context, token string, expiresAt time.Time) error {
+	return adapter.repository.UpdateRefreshTokenExpiresAt(token, expiresAt)
+}
+
+func (adapter *contextTokenRepository) DeleteTokenFamilyContext(ctx context.Context, familyId string) error {
+	return adapter.repository.DeleteTokenFamily(familyId)
+}
+
+func (adapter *contextTokenRepository) DeleteAccessTokensWithoutFamilyContext(ctx context.Context, clientId string, ownerId OauthTokenOwnerId) error {
+	return adapter.repository.DeleteAccessTokensWithoutFamily(clientId, ownerId)
+}
+
+type backgroundTokenRepository struct {
+	repository ContextTokenRepository
+}
+
+func (adapter *backgroundTokenRepository) CreateAccessToken(token *AccessToken) error {
+	return adapter.repository.CreateAccessTokenContext(context.Background(), token)
+}
+
+func (adapter *backgroundTokenRepository) CreateRefreshToken(token *RefreshToken) error {
+	return adapter.repository.CreateRefreshTokenContext(context.Background(), token)
+}
+
+func (adapter *backgroundTokenRepository) CreateAuthorizationCode(code *AuthorizationCode) error {
+	return adapter.repository.CreateAuthorizationCodeContext(context.Background(), code)
+}
+
+func (adapter *backgroundTokenRepository) GetAccessToken(token string) (*AccessToken, error) {
+	return adapter.repository.GetAccessTokenContext(context.Background(), token)
+}
+
+func (adapter *backgroundTokenRepository) GetRefreshToken(token string) (*RefreshToken, error) {
+	return adapter.repository.GetRefreshTokenContext(context.Background(), token)
+}
+
+func (adapter *backgroundTokenRepository) GetAuthorizationCode(code string) (*AuthorizationCode, error) {
+	return adapter.repository.GetAuthorizationCodeContext(context.Background(), code)
+}
+
+func (adapter *backgroundTokenRepository) DeleteAccessToken(token string) error {
+	return adapter.repository.DeleteAccessTokenContext(context.Background(), token)
+}
+
+func (adapter *backgroundTokenRepository) DeleteRefreshToken(token string) error {
+	return adapter.repository.DeleteRefreshTokenContext(context.Background(), token)
+}
+
+func (adapter *backgroundTokenRepository) DeleteAuthorizationCode(code string) error {
+	return adapter.repository.DeleteAuthorizationCodeContext(context.Background(), code)
+}
+
+func (adapter *backgroundTokenRepository) DeleteExpiredAccessTokens() error {
+	return adapter.repository.DeleteExpiredAccessTokensContext(context.Background())
+}
+
+func (adapter *backgroundTokenRepository) DeleteExpiredRefreshTokens() error {
+	return adapter.repository.DeleteExpiredRefreshTokensContext(context.Background())
+}
+
+func (adapter *backgroundTokenRepository) DeleteExpiredAuthorizationCodes() error {
+	return adapter.repository.DeleteExpiredAuthorizationCodesContext(context.Background())
+}
+
+func (adapter *backgroundTokenRepository) RotateRefreshToken(token *RefreshToken) error {
+	return adapter.repository.RotateRefreshTokenContext(context.Background(), token)
+}
+
+func (adapter *backgroundTokenRepository) UpdateRefreshTokenExpiresAt(token string, expiresAt time.Time) error {
+	return adapter.repository.UpdateRefreshTokenExpiresAtContext(context.Background(), token, expiresAt)
+}
+
+func (adapter *backgroundTokenRepository) DeleteTokenFamily(familyId string) error {
+	return adapter.repository.DeleteTokenFamilyContext(context.Background(), familyId)
+}
+
+func (adapter *backgroundTokenRepository) DeleteAccessTokensWithoutFamily(clientId string, ownerId OauthTokenOwnerId) error {
+	return adapter.repository.DeleteAccessTokensWithoutFamilyContext(context.Background(), clientId, ownerId)
+}
@@ -0,0 +1,99 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+)
+
+type DeviceCodeStatus string
+
+const (
+	DeviceCodeStatusPending  DeviceCodeStatus = "pending"
+	DeviceCodeStatusApproved DeviceCodeStatus = "approved"
+	DeviceCodeStatusDenied   DeviceCodeStatus = "denied"
+)
+
+// Consonants only to avoid spelling words, and no vowel lookalikes
+const userCodeLetters = "BCDFGHJKLMNPQRSTVWXZ"
+const userCodeLength = 8
+
+type DeviceCode struct {
+	// Token is the device code, the owner is set once a user approves the request
+	*OauthToken
+
+	// Postgres
+	TableName struct{} `sql:"oauth_device_codes"`
+
+	UserCode     string
+	Status       DeviceCodeStatus
+	Interval     time.Duration
+	LastPolledAt time.Time
+}
+
+func NewDeviceCode(
+	clientId string,
+	duration time.Duration,
+	scopes []string,
+	interval time.Duration,
+) *DeviceCode {
+	return &DeviceCode{
+		OauthToken: newOauthToken(TokenKindDeviceCode, clientId, "", duration, scopes),
+		UserCode:   generateUserCode(),
+		Status:     DeviceCodeStatusPending,
+		Interval:   interval,
+	}
+}
+
+// FormattedUserCode returns the user code as it should be displayed to the user, e.g. WDJB-MJHT
+func (code *DeviceCode) FormattedUserCode() string {
+	return code.UserCode[:userCodeLength/2] + "-" + code.UserCode[userCodeLength/2:]
+}
+
+// NormalizeUserCode removes any formatting the user might have entered so it can be compared
+func NormalizeUserCode(userCode string) string {
+	userCode = strings.ToUpper(userCode)
+	userCode = strings.Replace(userCode, "-", "", -1)
+	userCode = strings.Replace(userCode, " ", "", -1)
+
+	return userCode
+}
+
+func generateUserCode() string {
+	bytes := make([]byte, userCodeLength)
+	max := big.NewInt(int64(len(userCodeLetters)))
+
+	for i := range bytes {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+
+		bytes[i] = userCodeLetters[n.Int64()]
+	}
+
+	return string(bytes)
+}
+
+type DeviceCodeRepository interface {
+	// CreateDeviceCode MUST return TokenAlreadyExistsErr if the device code or the user code
+	// already exists, the grant generates new codes on this error
+	CreateDeviceCode(code *DeviceCode) error
+
+	GetDeviceCode(deviceCode string) (*DeviceCode, error)
+	GetDeviceCodeByUserCode(userCode string) (*DeviceCode, error)
+
+	// UpdateDeviceCode stores the decision of the user, it MUST only update a code that is still
+	// pending and return DeviceCodeNotPendingErr otherwise, so concurrent decisions can't overwrite
+	// each other
+	UpdateDeviceCode(code *DeviceCode) error
+	// UpdateDeviceCodePolling MUST only update LastPolledAt and Interval of a code that is still
+	// pending, so a poll never overwrites an approval that happened concurrently
+	UpdateDeviceCodePolling(deviceCode string, lastPolledAt time.Time, interval time.Duration) error
+
+	// DeleteDeviceCode MUST return DeviceCodeNotFoundErr if no code was deleted,
+	// this is what guarantees that a device code can only be exchanged once
+	DeleteDeviceCode(deviceCode string) error
+	DeleteExpiredDeviceCodes() error
+}
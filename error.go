@@ -32,9 +32,35 @@ const (
 	UnsupportedTokenTypeErr                   = "unsupported_token_type"
 	ServerErrorErr                            = "server_error"
 	TemporarilyUnavailableErr                 = "temporarily_unavailable"
+
+	// Bearer token usage, RFC 6750
+	InvalidTokenErr      = "invalid_token"
+	InsufficientScopeErr = "insufficient_scope"
+
+	// Device authorization grant
+	AuthorizationPendingErr = "authorization_pending"
+	SlowDownErr             = "slow_down"
+	ExpiredTokenErr         = "expired_token"
+
+	// Token exchange, RFC 8693
+	InvalidTargetErr = "invalid_target"
 )
 
 var (
 	AccessTokenNotFoundErr  = errors.New("Access token not found")
 	RefreshTokenNotFoundErr = errors.New("Refresh token not found")
+
+	RefreshTokenAlreadyRotatedErr = errors.New("Refresh token has already been rotated")
+
+	TokenAlreadyExistsErr = errors.New("Token already exists")
+
+	AuthorizationCodeNotFoundErr = errors.New("Authorization code not found")
+
+	DeviceCodeNotFoundErr   = errors.New("Device code not found")
+	DeviceCodeNotPendingErr = errors.New("Device code has already been approved or denied")
+
+	JwtIdAlreadyUsedErr = errors.New("JWT id has already been used")
+
+	InvalidTokenFormatErr   = errors.New("Token does not have a known prefix")
+	InvalidTokenChecksumErr = errors.New("Token checksum does not match")
 )
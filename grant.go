@@ -18,6 +18,10 @@ const (
 	GrantTypeRefreshToken      = "refresh_token"
 	GrantTypeAuthorizationCode = "authorization_code"
 	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeImplicit          = "implicit"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTypeJwtBearer         = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
 )
 
 type OauthGrant interface {
@@ -28,3 +32,14 @@ type OauthGrant interface {
 	// Allow public clients ?
 	AllowPublicClients() bool
 }
+
+type DeviceAuthorizationGrant interface {
+	OauthGrant
+
+	// Create and persist a device code to storage
+	CreateDeviceCode(r *http.Request, clientId string) (*DeviceCode, error)
+	// Approve or deny the device code matching the user code in the request
+	VerifyUserCode(r *http.Request) error
+	// The uri where the user enters the user code
+	VerificationUri() string
+}
@@ -1,24 +1,162 @@
 package grant
 
 import (
+	"context"
 	"net/http"
+	"strings"
 
 	"github.com/interactive-solutions/go-oauth2"
 )
 
+// AuthorizationCodeHandler resolves the resource owner approving the authorization request,
+// usually from the session of the signed in user. Return an oauth2.AccessDeniedErr if the user
+// denied the request.
+type AuthorizationCodeHandler func(r *http.Request, clientId string, scopes []string) (oauth2.OauthTokenOwnerId, error)
+
 type authorizationCodeGrant struct {
+	server     oauth2.Server
+	repository oauth2.ContextTokenRepository
+	handler    AuthorizationCodeHandler
+	config     AuthorizationCodeGrantConfig
 }
 
-func NewAuthorizationCodeGrant() oauth2.OauthGrant {
-	return &authorizationCodeGrant{}
+func NewAuthorizationCodeGrant(
+	server oauth2.Server,
+	repository oauth2.TokenRepository,
+	handler AuthorizationCodeHandler,
+	config AuthorizationCodeGrantConfig,
+) oauth2.OauthGrant {
+	return &authorizationCodeGrant{
+		server:     server,
+		repository: oauth2.NewContextTokenRepository(repository),
+		handler:    handler,
+		config:     config,
+	}
 }
 
 func (grant *authorizationCodeGrant) CreateAuthorizationCode(r *http.Request, clientId string) (*oauth2.AuthorizationCode, error) {
-	panic("not implemented")
+	redirectUri := r.FormValue("redirect_uri")
+	codeChallenge := r.FormValue("code_challenge")
+	codeChallengeMethod := oauth2.CodeChallengeMethod(r.FormValue("code_challenge_method"))
+
+	scopes := make([]string, 0)
+	if providedScopes := r.FormValue("scope"); providedScopes != "" {
+		scopes = strings.Split(providedScopes, " ")
+	}
+
+	if codeChallenge != "" {
+		// Defaults to plain if not present in the request
+		if codeChallengeMethod == "" {
+			codeChallengeMethod = oauth2.CodeChallengeMethodPlain
+		}
+
+		if !oauth2.IsValidCodeChallengeMethod(codeChallengeMethod) {
+			return nil, oauth2.NewError(oauth2.InvalidRequestErr, "Transform algorithm not supported")
+		}
+
+		if !oauth2.IsValidCodeChallenge(codeChallenge) {
+			return nil, oauth2.NewError(oauth2.InvalidRequestErr, "Code challenge is invalid")
+		}
+	} else if codeChallengeMethod != "" {
+		return nil, oauth2.NewError(oauth2.InvalidRequestErr, "Code challenge method provided without a code challenge")
+	}
+
+	if grant.handler == nil {
+		return nil, oauth2.NewError(oauth2.ServerErrorErr, "Authorization code grant not configured correctly")
+	}
+
+	tokenOwnerId, err := grant.handler(r, clientId, scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := grant.server.CreateAuthorizationCodeContext(
+		r.Context(),
+		clientId,
+		tokenOwnerId,
+		grant.config.AuthorizationCodeDuration,
+		scopes,
+		redirectUri,
+		codeChallenge,
+		codeChallengeMethod,
+	)
+	if err != nil {
+		return nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	return code, nil
 }
 
 func (grant *authorizationCodeGrant) CreateTokens(r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
-	panic("not implemented")
+	return grant.CreateTokensContext(r.Context(), r, clientId)
+}
+
+func (grant *authorizationCodeGrant) CreateTokensContext(ctx context.Context, r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	providedCode := r.FormValue("code")
+	redirectUri := r.FormValue("redirect_uri")
+	codeVerifier := r.FormValue("code_verifier")
+
+	if providedCode == "" {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidRequestErr, "Missing authorization code")
+	}
+
+	// Retrieve authorization code from repository
+	code, err := grant.repository.GetAuthorizationCodeContext(ctx, providedCode)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Consume the code before anything else, whoever fails to delete it lost the race
+	if err = grant.repository.DeleteAuthorizationCodeContext(ctx, code.Token); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Validate authorization code
+	if code.IsExpired() {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Authorization code has expired")
+	}
+
+	if code.ClientId != clientId {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Authorization code was issued to another client")
+	}
+
+	if code.RedirectUri != redirectUri {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Redirect uri does not match the authorization request")
+	}
+
+	// Verify the proof key, a verifier without a challenge could be a downgrade attack
+	if code.CodeChallenge != "" {
+		if codeVerifier == "" {
+			return nil, nil, nil, oauth2.NewError(oauth2.InvalidRequestErr, "Missing code verifier")
+		}
+
+		if !oauth2.VerifyCodeVerifier(code.CodeChallenge, code.CodeChallengeMethod, codeVerifier) {
+			return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Code verifier does not match the code challenge")
+		}
+	} else if codeVerifier != "" {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Authorization request did not include a code challenge")
+	}
+
+	var accessToken *oauth2.AccessToken
+	var refreshToken *oauth2.RefreshToken
+
+	// Tokens issued together belong to the same family, which is revoked as a whole on refresh token reuse
+	familyId := oauth2.NewTokenFamilyId()
+
+	accessToken, err = grant.server.CreateAccessTokenContext(ctx, clientId, code.OwnerId, grant.config.AccessTokenDuration, code.Scopes, oauth2.TokenOptions{FamilyId: familyId, AuthenticatedAt: code.AuthenticatedAt})
+	if err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	// Should we also generate a refresh token
+	if grant.config.GenerateRefreshToken {
+		refreshToken, err = grant.server.CreateRefreshTokenContext(ctx, clientId, code.OwnerId, grant.config.RefreshTokenDuration, code.Scopes, oauth2.TokenOptions{FamilyId: familyId, AuthenticatedAt: code.AuthenticatedAt})
+		if err != nil {
+			return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+		}
+	}
+
+	return accessToken, refreshToken, nil, nil
 }
 
 func (grant *authorizationCodeGrant) AllowPublicClients() bool {
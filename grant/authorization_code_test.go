@@ -0,0 +1,194 @@
+package grant
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/server"
+	"github.com/interactive-solutions/go-oauth2/token/memory"
+)
+
+const (
+	testClientId    = "client"
+	testOwnerId     = "owner"
+	testRedirectUri = "https://example.com/callback"
+)
+
+func newTestServer() (*server.OauthServer, *memory.TokenRepository) {
+	repository := memory.NewTokenRepository(memory.RepositoryDefaultConfig)
+
+	return server.NewDefaultOauthServer(repository), repository
+}
+
+func newFormRequest(values url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return r
+}
+
+// The oauth error type of err, empty if there is no error
+func errorType(err error) oauth2.OauthErrorType {
+	if err == nil {
+		return ""
+	}
+
+	if oauthErr, ok := err.(oauth2.OauthError); ok {
+		return oauthErr.Err
+	}
+
+	return oauth2.OauthErrorType(err.Error())
+}
+
+func TestAuthorizationCodePkce(t *testing.T) {
+	verifier := strings.Repeat("verifier-", 5)
+	hash := sha256.Sum256([]byte(verifier))
+	s256Challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+
+	tests := []struct {
+		name      string
+		challenge string
+		method    oauth2.CodeChallengeMethod
+		verifier  string
+		err       oauth2.OauthErrorType
+	}{
+		{name: "no challenge"},
+		{name: "S256", challenge: s256Challenge, method: oauth2.CodeChallengeMethodS256, verifier: verifier},
+		{name: "plain", challenge: verifier, method: oauth2.CodeChallengeMethodPlain, verifier: verifier},
+		{
+			name:      "S256 without verifier",
+			challenge: s256Challenge,
+			method:    oauth2.CodeChallengeMethodS256,
+			err:       oauth2.InvalidRequestErr,
+		},
+		{
+			name:      "S256 with wrong verifier",
+			challenge: s256Challenge,
+			method:    oauth2.CodeChallengeMethodS256,
+			verifier:  strings.Repeat("wrong-", 8),
+			err:       oauth2.InvalidGrantErr,
+		},
+		{
+			name:      "S256 with challenge as verifier",
+			challenge: s256Challenge,
+			method:    oauth2.CodeChallengeMethodS256,
+			verifier:  s256Challenge,
+			err:       oauth2.InvalidGrantErr,
+		},
+		{
+			name:      "plain with S256 challenge as verifier",
+			challenge: verifier,
+			method:    oauth2.CodeChallengeMethodPlain,
+			verifier:  s256Challenge,
+			err:       oauth2.InvalidGrantErr,
+		},
+		{
+			name:      "verifier too short",
+			challenge: "short",
+			method:    oauth2.CodeChallengeMethodPlain,
+			verifier:  "short",
+			err:       oauth2.InvalidGrantErr,
+		},
+		{
+			name:     "verifier without challenge",
+			verifier: verifier,
+			err:      oauth2.InvalidGrantErr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauthServer, repository := newTestServer()
+			grant := NewAuthorizationCodeGrant(oauthServer, repository, nil, AuthorizationCodeGrantDefaultConfig)
+
+			code, err := oauthServer.CreateAuthorizationCode(
+				testClientId,
+				testOwnerId,
+				time.Minute,
+				nil,
+				testRedirectUri,
+				test.challenge,
+				test.method,
+			)
+			if err != nil {
+				t.Fatalf("CreateAuthorizationCode failed: %v", err)
+			}
+
+			values := url.Values{"code": {code.Token}, "redirect_uri": {testRedirectUri}}
+			if test.verifier != "" {
+				values.Set("code_verifier", test.verifier)
+			}
+
+			accessToken, _, _, err := grant.CreateTokens(newFormRequest(values), testClientId)
+			if errorType(err) != test.err {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+
+			if test.err == "" && accessToken == nil {
+				t.Fatalf("No access token was issued")
+			}
+
+			// The code is consumed whether or not the verifier matched
+			if _, err = repository.GetAuthorizationCode(code.Token); err != oauth2.AuthorizationCodeNotFoundErr {
+				t.Errorf("Authorization code was not consumed: %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthorizationCodeChallengeValidation(t *testing.T) {
+	challenge := strings.Repeat("challenge", 5)
+
+	tests := []struct {
+		name      string
+		challenge string
+		method    string
+		stored    oauth2.CodeChallengeMethod
+		err       oauth2.OauthErrorType
+	}{
+		{name: "no challenge"},
+		{name: "default method", challenge: challenge, stored: oauth2.CodeChallengeMethodPlain},
+		{name: "S256", challenge: challenge, method: "S256", stored: oauth2.CodeChallengeMethodS256},
+		{name: "unsupported method", challenge: challenge, method: "S512", err: oauth2.InvalidRequestErr},
+		{name: "invalid challenge", challenge: "short", method: "S256", err: oauth2.InvalidRequestErr},
+		{name: "method without challenge", method: "S256", err: oauth2.InvalidRequestErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauthServer, repository := newTestServer()
+			handler := func(r *http.Request, clientId string, scopes []string) (oauth2.OauthTokenOwnerId, error) {
+				return testOwnerId, nil
+			}
+			grant := NewAuthorizationCodeGrant(oauthServer, repository, handler, AuthorizationCodeGrantDefaultConfig)
+
+			values := url.Values{"redirect_uri": {testRedirectUri}}
+			if test.challenge != "" {
+				values.Set("code_challenge", test.challenge)
+			}
+			if test.method != "" {
+				values.Set("code_challenge_method", test.method)
+			}
+
+			code, err := grant.CreateAuthorizationCode(newFormRequest(values), testClientId)
+			if errorType(err) != test.err {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+
+			if test.err != "" {
+				return
+			}
+
+			if code.CodeChallenge != test.challenge || code.CodeChallengeMethod != test.stored {
+				t.Errorf("Stored challenge %q %q, expected %q %q", code.CodeChallenge, code.CodeChallengeMethod, test.challenge, test.stored)
+			}
+		})
+	}
+}
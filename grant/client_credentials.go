@@ -1,22 +1,29 @@
 package grant
 
 import (
+	"context"
 	"net/http"
-
 	"strings"
 
 	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/server"
 )
 
 type clientCredentialsGrant struct {
-	TokenRepository oauth2.TokenRepository
-	Config          ClientCredentialsGrantConfig
+	server oauth2.Server
+	Config ClientCredentialsGrantConfig
 }
 
+// NewClientCredentialsGrant issues tokens through a default server for the repository, use
+// NewClientCredentialsGrantWithServer to issue them through your server, e.g. to sign them
 func NewClientCredentialsGrant(tokenRepository oauth2.TokenRepository, config ClientCredentialsGrantConfig) oauth2.OauthGrant {
+	return NewClientCredentialsGrantWithServer(server.NewDefaultOauthServer(tokenRepository), config)
+}
+
+func NewClientCredentialsGrantWithServer(server oauth2.Server, config ClientCredentialsGrantConfig) oauth2.OauthGrant {
 	return &clientCredentialsGrant{
-		TokenRepository: tokenRepository,
-		Config:          config,
+		server: server,
+		Config: config,
 	}
 }
 
@@ -25,23 +32,18 @@ func (grant *clientCredentialsGrant) CreateAuthorizationCode(r *http.Request, cl
 }
 
 func (grant *clientCredentialsGrant) CreateTokens(r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	return grant.CreateTokensContext(r.Context(), r, clientId)
+}
+
+func (grant *clientCredentialsGrant) CreateTokensContext(ctx context.Context, r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
 	scopes := make([]string, 0)
 	if providedScopes := r.FormValue("scope"); providedScopes != "" {
 		scopes = strings.Split(providedScopes, " ")
 	}
 
-	var accessToken *oauth2.AccessToken
-
-	// Generate access token until it is unique
-	for {
-		accessToken = oauth2.NewAccessToken(clientId, "", grant.Config.AccessTokenDuration, scopes)
-
-		if t, _ := grant.TokenRepository.GetAccessToken(accessToken.Token); t == nil {
-			break
-		}
-	}
-
-	if err := grant.TokenRepository.CreateAccessToken(accessToken); err != nil {
+	// Tokens issued to a client on its own behalf have no owner
+	accessToken, err := grant.server.CreateAccessTokenContext(ctx, clientId, "", grant.Config.AccessTokenDuration, scopes, oauth2.TokenOptions{})
+	if err != nil {
 		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
 	}
 
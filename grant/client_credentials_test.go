@@ -0,0 +1,39 @@
+package grant
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+func TestClientCredentialsGrant(t *testing.T) {
+	oauthServer, repository := newTestServer()
+
+	grants := map[string]oauth2.OauthGrant{
+		"repository": NewClientCredentialsGrant(repository, ClientCredentialsGrantDefaultConfig),
+		"server":     NewClientCredentialsGrantWithServer(oauthServer, ClientCredentialsGrantDefaultConfig),
+	}
+
+	for name, grant := range grants {
+		t.Run(name, func(t *testing.T) {
+			accessToken, refreshToken, _, err := grant.CreateTokens(newFormRequest(url.Values{"scope": {"read write"}}), testClientId)
+			if err != nil {
+				t.Fatalf("CreateTokens failed: %v", err)
+			}
+
+			if refreshToken != nil {
+				t.Errorf("A refresh token was issued")
+			}
+
+			stored, err := repository.GetAccessToken(accessToken.Token)
+			if err != nil {
+				t.Fatalf("GetAccessToken failed: %v", err)
+			}
+
+			if stored.ClientId != testClientId || stored.OwnerId != "" || len(stored.Scopes) != 2 {
+				t.Errorf("Access token was issued to %q %q with scopes %v", stored.ClientId, stored.OwnerId, stored.Scopes)
+			}
+		})
+	}
+}
@@ -22,6 +22,10 @@ var RefreshTokenGrantDefaultConfig = RefreshTokenGrantConfig{
 	RefreshTokenDuration:       time.Hour * 24,
 	RotateRefreshTokens:        false,
 	RevokeRotatedRefreshTokens: false,
+	DetectRefreshTokenReuse:    false,
+	RotationGracePeriod:        0,
+	RefreshTokenIdleTimeout:    0,
+	RefreshTokenMaxLifetime:    0,
 }
 
 type RefreshTokenGrantConfig struct {
@@ -34,6 +38,24 @@ type RefreshTokenGrantConfig struct {
 
 	// Should we revoke a rotated refresh token ?
 	RevokeRotatedRefreshTokens bool
+
+	// Should the reuse of a rotated refresh token revoke all tokens of the family ?
+	// Rotated tokens are kept, marked as rotated, until they expire to be able to detect reuse
+	DetectRefreshTokenReuse bool
+
+	// A rotated refresh token used again within the grace period returns the same successor tokens
+	// instead of being treated as reuse, e.g. when a client refreshes in parallel requests.
+	// Requires DetectRefreshTokenReuse
+	RotationGracePeriod time.Duration
+
+	// Idle timeout of the session, when set every refresh moves the expiry of the refresh token
+	// to now plus the timeout, also when refresh tokens are not rotated
+	RefreshTokenIdleTimeout time.Duration
+
+	// Absolute maximum age of the session counted from the original authentication,
+	// no token is issued past it regardless of rotation and the idle timeout. Tokens
+	// without an authentication time count it from their first refresh
+	RefreshTokenMaxLifetime time.Duration
 }
 
 var ClientCredentialsGrantDefaultConfig = ClientCredentialsGrantConfig{
@@ -44,3 +66,92 @@ type ClientCredentialsGrantConfig struct {
 	// Duration for tokens
 	AccessTokenDuration time.Duration
 }
+
+var AuthorizationCodeGrantDefaultConfig = AuthorizationCodeGrantConfig{
+	AuthorizationCodeDuration: time.Minute * 10,
+	AccessTokenDuration:       time.Hour,
+	RefreshTokenDuration:      time.Hour * 24,
+	GenerateRefreshToken:      true,
+}
+
+type AuthorizationCodeGrantConfig struct {
+	// Durations for codes and tokens, the specification recommends a maximum
+	// lifetime of 10 minutes for authorization codes
+	AuthorizationCodeDuration time.Duration
+	AccessTokenDuration       time.Duration
+	RefreshTokenDuration      time.Duration
+
+	// Should we generate a refresh token for each access token ?
+	GenerateRefreshToken bool
+}
+
+var ImplicitGrantDefaultConfig = ImplicitGrantConfig{
+	AccessTokenDuration: time.Hour,
+}
+
+type ImplicitGrantConfig struct {
+	// Duration for tokens, keep it short since the token is exposed in the browser
+	AccessTokenDuration time.Duration
+}
+
+var DeviceCodeGrantDefaultConfig = DeviceCodeGrantConfig{
+	DeviceCodeDuration:    time.Minute * 10,
+	PollingInterval:       time.Second * 5,
+	AccessTokenDuration:   time.Hour,
+	RefreshTokenDuration:  time.Hour * 24,
+	GenerateRefreshToken:  true,
+	CodeGenerationRetries: 5,
+}
+
+type DeviceCodeGrantConfig struct {
+	// Durations for codes and tokens
+	DeviceCodeDuration   time.Duration
+	AccessTokenDuration  time.Duration
+	RefreshTokenDuration time.Duration
+
+	// Minimum time the client should wait between polling requests
+	PollingInterval time.Duration
+
+	// The uri where the user enters the user code, e.g. https://example.com/device
+	VerificationUri string
+
+	// How many times new codes are generated when the repository reports they already exist,
+	// user codes are short so collisions are far more likely than for tokens
+	CodeGenerationRetries int
+
+	// Should we generate a refresh token for each access token ?
+	GenerateRefreshToken bool
+}
+
+var JwtBearerGrantDefaultConfig = JwtBearerGrantConfig{
+	AccessTokenDuration:  time.Hour,
+	MaxAssertionLifetime: time.Hour,
+	ClockSkew:            time.Minute,
+	RequireJwtId:         true,
+}
+
+type JwtBearerGrantConfig struct {
+	// Duration for tokens
+	AccessTokenDuration time.Duration
+
+	// Accepted values of the aud claim, usually the uri of the token endpoint
+	Audience []string
+
+	// Reject assertions that are valid for longer than this, keeps the jti replay window bounded
+	MaxAssertionLifetime time.Duration
+
+	// Allowed clock difference between us and the issuer
+	ClockSkew time.Duration
+
+	// Should assertions without a jti be rejected ?
+	RequireJwtId bool
+}
+
+var TokenExchangeGrantDefaultConfig = TokenExchangeGrantConfig{
+	AccessTokenDuration: time.Hour,
+}
+
+type TokenExchangeGrantConfig struct {
+	// Duration for tokens, never longer than the remaining lifetime of the subject token
+	AccessTokenDuration time.Duration
+}
@@ -0,0 +1,208 @@
+package grant
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+// The interval is increased by 5 seconds each time a client polls too fast
+const slowDownIncrement = time.Second * 5
+
+// DeviceVerificationHandler resolves the signed in user approving the device code.
+// Return an oauth2.AccessDeniedErr if the user denied the request.
+type DeviceVerificationHandler func(r *http.Request, code *oauth2.DeviceCode) (oauth2.OauthTokenOwnerId, error)
+
+type deviceCodeGrant struct {
+	server     oauth2.Server
+	repository oauth2.DeviceCodeRepository
+	handler    DeviceVerificationHandler
+	config     DeviceCodeGrantConfig
+}
+
+func NewDeviceCodeGrant(
+	server oauth2.Server,
+	repository oauth2.DeviceCodeRepository,
+	handler DeviceVerificationHandler,
+	config DeviceCodeGrantConfig,
+) oauth2.DeviceAuthorizationGrant {
+	return &deviceCodeGrant{
+		server:     server,
+		repository: repository,
+		handler:    handler,
+		config:     config,
+	}
+}
+
+func (grant *deviceCodeGrant) CreateAuthorizationCode(r *http.Request, clientId string) (*oauth2.AuthorizationCode, error) {
+	return nil, oauth2.NewError(oauth2.InvalidRequestErr, "Device code grant does not support authorization")
+}
+
+func (grant *deviceCodeGrant) CreateDeviceCode(r *http.Request, clientId string) (*oauth2.DeviceCode, error) {
+	scopes := make([]string, 0)
+	if providedScopes := r.FormValue("scope"); providedScopes != "" {
+		scopes = strings.Split(providedScopes, " ")
+	}
+
+	if clientId == "" {
+		return nil, oauth2.NewError(oauth2.InvalidClientErr, "Client id is missing")
+	}
+
+	var code *oauth2.DeviceCode
+
+	// Generate new codes until both the device code and user code are unique
+	err := oauth2.InsertUniqueToken(grant.config.CodeGenerationRetries, func() error {
+		code = oauth2.NewDeviceCode(clientId, grant.config.DeviceCodeDuration, scopes, grant.config.PollingInterval)
+
+		token, err := grant.server.GenerateToken(oauth2.TokenKindDeviceCode)
+		if err != nil {
+			return err
+		}
+
+		code.Token = token
+
+		return grant.repository.CreateDeviceCode(code)
+	})
+	if err != nil {
+		return nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	return code, nil
+}
+
+func (grant *deviceCodeGrant) VerifyUserCode(r *http.Request) error {
+	userCode := oauth2.NormalizeUserCode(r.FormValue("user_code"))
+
+	if userCode == "" {
+		return oauth2.NewError(oauth2.InvalidRequestErr, "Missing user code")
+	}
+
+	if grant.handler == nil {
+		return oauth2.NewError(oauth2.ServerErrorErr, "Device code grant not configured correctly")
+	}
+
+	code, err := grant.repository.GetDeviceCodeByUserCode(userCode)
+	if err != nil {
+		return err
+	}
+
+	if code.IsExpired() {
+		return oauth2.NewError(oauth2.ExpiredTokenErr, "User code has expired")
+	}
+
+	if code.Status != oauth2.DeviceCodeStatusPending {
+		return oauth2.NewError(oauth2.InvalidRequestErr, "User code has already been used")
+	}
+
+	tokenOwnerId, err := grant.handler(r, code)
+	if oauthErr, ok := err.(oauth2.OauthError); ok && oauthErr.Err == oauth2.AccessDeniedErr {
+		code.Status = oauth2.DeviceCodeStatusDenied
+	} else if err != nil {
+		return err
+	} else {
+		code.Status = oauth2.DeviceCodeStatusApproved
+		code.OwnerId = tokenOwnerId
+		code.AuthenticatedAt = time.Now()
+	}
+
+	// The update only succeeds if no other decision was stored since the code was read
+	err = grant.repository.UpdateDeviceCode(code)
+	if err == oauth2.DeviceCodeNotPendingErr {
+		return oauth2.NewError(oauth2.InvalidRequestErr, "User code has already been used")
+	} else if err != nil {
+		return oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	return nil
+}
+
+func (grant *deviceCodeGrant) VerificationUri() string {
+	return grant.config.VerificationUri
+}
+
+func (grant *deviceCodeGrant) CreateTokens(r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	return grant.CreateTokensContext(r.Context(), r, clientId)
+}
+
+func (grant *deviceCodeGrant) CreateTokensContext(ctx context.Context, r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	providedCode := r.FormValue("device_code")
+
+	if providedCode == "" {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidRequestErr, "Missing device code")
+	}
+
+	// Retrieve device code from repository
+	code, err := grant.repository.GetDeviceCode(providedCode)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if code.ClientId != clientId {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Device code was issued to another client")
+	}
+
+	if code.IsExpired() {
+		return nil, nil, nil, oauth2.NewError(oauth2.ExpiredTokenErr, "Device code has expired")
+	}
+
+	switch code.Status {
+	case oauth2.DeviceCodeStatusDenied:
+		if err = grant.repository.DeleteDeviceCode(code.Token); err != nil {
+			return nil, nil, nil, err
+		}
+
+		return nil, nil, nil, oauth2.NewError(oauth2.AccessDeniedErr, "The user denied the authorization request")
+
+	case oauth2.DeviceCodeStatusPending:
+		now := time.Now()
+		polledTooFast := now.Sub(code.LastPolledAt) < code.Interval
+
+		if polledTooFast {
+			code.Interval += slowDownIncrement
+		}
+
+		// Only the polling state is written, the user may have approved the code since it was read
+		if err = grant.repository.UpdateDeviceCodePolling(code.Token, now, code.Interval); err != nil {
+			return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+		}
+
+		if polledTooFast {
+			return nil, nil, nil, oauth2.NewError(oauth2.SlowDownErr, "Polling too fast, increase the interval")
+		}
+
+		return nil, nil, nil, oauth2.NewError(oauth2.AuthorizationPendingErr, "The user has not yet approved the request")
+	}
+
+	// Consume the code, whoever fails to delete it lost the race
+	if err = grant.repository.DeleteDeviceCode(code.Token); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var accessToken *oauth2.AccessToken
+	var refreshToken *oauth2.RefreshToken
+
+	// Tokens issued together belong to the same family, which is revoked as a whole on refresh token reuse
+	familyId := oauth2.NewTokenFamilyId()
+
+	accessToken, err = grant.server.CreateAccessTokenContext(ctx, clientId, code.OwnerId, grant.config.AccessTokenDuration, code.Scopes, oauth2.TokenOptions{FamilyId: familyId, AuthenticatedAt: code.AuthenticatedAt})
+	if err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	// Should we also generate a refresh token
+	if grant.config.GenerateRefreshToken {
+		refreshToken, err = grant.server.CreateRefreshTokenContext(ctx, clientId, code.OwnerId, grant.config.RefreshTokenDuration, code.Scopes, oauth2.TokenOptions{FamilyId: familyId, AuthenticatedAt: code.AuthenticatedAt})
+		if err != nil {
+			return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+		}
+	}
+
+	return accessToken, refreshToken, nil, nil
+}
+
+func (grant *deviceCodeGrant) AllowPublicClients() bool {
+	return true
+}
@@ -0,0 +1,388 @@
+package grant
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+// Keeps device codes in a map, copies are returned so the grant can't modify stored codes
+type deviceCodeRepository struct {
+	mutex sync.Mutex
+	codes map[string]oauth2.DeviceCode
+}
+
+func newDeviceCodeRepository() *deviceCodeRepository {
+	return &deviceCodeRepository{codes: make(map[string]oauth2.DeviceCode)}
+}
+
+func (repository *deviceCodeRepository) CreateDeviceCode(code *oauth2.DeviceCode) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	for _, stored := range repository.codes {
+		if stored.Token == code.Token || stored.UserCode == code.UserCode {
+			return oauth2.TokenAlreadyExistsErr
+		}
+	}
+
+	repository.codes[code.Token] = *code
+
+	return nil
+}
+
+func (repository *deviceCodeRepository) GetDeviceCode(deviceCode string) (*oauth2.DeviceCode, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	code, ok := repository.codes[deviceCode]
+	if !ok {
+		return nil, oauth2.DeviceCodeNotFoundErr
+	}
+
+	return &code, nil
+}
+
+func (repository *deviceCodeRepository) GetDeviceCodeByUserCode(userCode string) (*oauth2.DeviceCode, error) {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	for _, code := range repository.codes {
+		if code.UserCode == userCode {
+			return &code, nil
+		}
+	}
+
+	return nil, oauth2.DeviceCodeNotFoundErr
+}
+
+func (repository *deviceCodeRepository) UpdateDeviceCode(code *oauth2.DeviceCode) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	stored, ok := repository.codes[code.Token]
+	if !ok || stored.Status != oauth2.DeviceCodeStatusPending {
+		return oauth2.DeviceCodeNotPendingErr
+	}
+
+	repository.codes[code.Token] = *code
+
+	return nil
+}
+
+func (repository *deviceCodeRepository) UpdateDeviceCodePolling(deviceCode string, lastPolledAt time.Time, interval time.Duration) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	code, ok := repository.codes[deviceCode]
+	if !ok || code.Status != oauth2.DeviceCodeStatusPending {
+		return nil
+	}
+
+	code.LastPolledAt = lastPolledAt
+	code.Interval = interval
+	repository.codes[deviceCode] = code
+
+	return nil
+}
+
+func (repository *deviceCodeRepository) DeleteDeviceCode(deviceCode string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	if _, ok := repository.codes[deviceCode]; !ok {
+		return oauth2.DeviceCodeNotFoundErr
+	}
+
+	delete(repository.codes, deviceCode)
+
+	return nil
+}
+
+func (repository *deviceCodeRepository) DeleteExpiredDeviceCodes() error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	for token, code := range repository.codes {
+		if code.IsExpired() {
+			delete(repository.codes, token)
+		}
+	}
+
+	return nil
+}
+
+func newTestDeviceCode(t *testing.T, repository *deviceCodeRepository, modify func(code *oauth2.DeviceCode)) *oauth2.DeviceCode {
+	code := oauth2.NewDeviceCode(testClientId, time.Minute, []string{"read"}, 5*time.Second)
+	code.Token = oauth2.GenerateRandomString(32)
+
+	if modify != nil {
+		modify(code)
+	}
+
+	if err := repository.CreateDeviceCode(code); err != nil {
+		t.Fatalf("CreateDeviceCode failed: %v", err)
+	}
+
+	return code
+}
+
+func TestDeviceCodePolling(t *testing.T) {
+	approve := func(code *oauth2.DeviceCode) {
+		code.Status = oauth2.DeviceCodeStatusApproved
+		code.OwnerId = testOwnerId
+	}
+
+	tests := []struct {
+		name     string
+		modify   func(code *oauth2.DeviceCode)
+		clientId string
+		err      oauth2.OauthErrorType
+		interval time.Duration
+		consumed bool
+	}{
+		{
+			name:     "first poll",
+			err:      oauth2.AuthorizationPendingErr,
+			interval: 5 * time.Second,
+		},
+		{
+			name:     "polling too fast",
+			modify:   func(code *oauth2.DeviceCode) { code.LastPolledAt = time.Now() },
+			err:      oauth2.SlowDownErr,
+			interval: 10 * time.Second,
+		},
+		{
+			name:     "polling at the interval",
+			modify:   func(code *oauth2.DeviceCode) { code.LastPolledAt = time.Now().Add(-5 * time.Second) },
+			err:      oauth2.AuthorizationPendingErr,
+			interval: 5 * time.Second,
+		},
+		{
+			name:     "approved",
+			modify:   approve,
+			consumed: true,
+		},
+		{
+			name:     "denied",
+			modify:   func(code *oauth2.DeviceCode) { code.Status = oauth2.DeviceCodeStatusDenied },
+			err:      oauth2.AccessDeniedErr,
+			consumed: true,
+		},
+		{
+			name: "expired",
+			modify: func(code *oauth2.DeviceCode) {
+				approve(code)
+				code.ExpiresAt = time.Now().Add(-time.Second)
+			},
+			err: oauth2.ExpiredTokenErr,
+		},
+		{
+			name:     "another client",
+			modify:   approve,
+			clientId: "other",
+			err:      oauth2.InvalidGrantErr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauthServer, _ := newTestServer()
+			repository := newDeviceCodeRepository()
+			grant := NewDeviceCodeGrant(oauthServer, repository, nil, DeviceCodeGrantDefaultConfig)
+
+			code := newTestDeviceCode(t, repository, test.modify)
+
+			clientId := test.clientId
+			if clientId == "" {
+				clientId = testClientId
+			}
+
+			accessToken, _, _, err := grant.CreateTokens(newFormRequest(url.Values{"device_code": {code.Token}}), clientId)
+			if errorType(err) != test.err {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+
+			if test.err == "" && accessToken.OwnerId != testOwnerId {
+				t.Errorf("Access token was issued to %q, expected %q", accessToken.OwnerId, testOwnerId)
+			}
+
+			stored, err := repository.GetDeviceCode(code.Token)
+			if test.consumed {
+				if err != oauth2.DeviceCodeNotFoundErr {
+					t.Errorf("Device code was not consumed: %v", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("GetDeviceCode failed: %v", err)
+			}
+
+			if test.interval > 0 && stored.Interval != test.interval {
+				t.Errorf("Interval is %v, expected %v", stored.Interval, test.interval)
+			}
+
+			if test.interval > 0 && stored.LastPolledAt.IsZero() {
+				t.Errorf("Poll was not recorded")
+			}
+		})
+	}
+}
+
+func TestDeviceCodeVerification(t *testing.T) {
+	approve := func(r *http.Request, code *oauth2.DeviceCode) (oauth2.OauthTokenOwnerId, error) {
+		return testOwnerId, nil
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(code *oauth2.DeviceCode)
+		handler DeviceVerificationHandler
+		err     oauth2.OauthErrorType
+		status  oauth2.DeviceCodeStatus
+	}{
+		{
+			name:    "approved",
+			handler: approve,
+			status:  oauth2.DeviceCodeStatusApproved,
+		},
+		{
+			name: "denied",
+			handler: func(r *http.Request, code *oauth2.DeviceCode) (oauth2.OauthTokenOwnerId, error) {
+				return "", oauth2.NewError(oauth2.AccessDeniedErr, "Denied")
+			},
+			status: oauth2.DeviceCodeStatusDenied,
+		},
+		{
+			name: "handler failure",
+			handler: func(r *http.Request, code *oauth2.DeviceCode) (oauth2.OauthTokenOwnerId, error) {
+				return "", oauth2.NewError(oauth2.ServerErrorErr, "Failure")
+			},
+			err:    oauth2.ServerErrorErr,
+			status: oauth2.DeviceCodeStatusPending,
+		},
+		{
+			name:    "already used",
+			modify:  func(code *oauth2.DeviceCode) { code.Status = oauth2.DeviceCodeStatusDenied },
+			handler: approve,
+			err:     oauth2.InvalidRequestErr,
+			status:  oauth2.DeviceCodeStatusDenied,
+		},
+		{
+			name:    "expired",
+			modify:  func(code *oauth2.DeviceCode) { code.ExpiresAt = time.Now().Add(-time.Second) },
+			handler: approve,
+			err:     oauth2.ExpiredTokenErr,
+			status:  oauth2.DeviceCodeStatusPending,
+		},
+		{
+			name:   "not configured",
+			err:    oauth2.ServerErrorErr,
+			status: oauth2.DeviceCodeStatusPending,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauthServer, _ := newTestServer()
+			repository := newDeviceCodeRepository()
+			grant := NewDeviceCodeGrant(oauthServer, repository, test.handler, DeviceCodeGrantDefaultConfig)
+
+			code := newTestDeviceCode(t, repository, test.modify)
+
+			// The user may enter the code formatted and in lower case
+			values := url.Values{"user_code": {strings.ToLower(code.FormattedUserCode())}}
+
+			err := grant.VerifyUserCode(newFormRequest(values))
+			if errorType(err) != test.err {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+
+			stored, err := repository.GetDeviceCode(code.Token)
+			if err != nil {
+				t.Fatalf("GetDeviceCode failed: %v", err)
+			}
+
+			if stored.Status != test.status {
+				t.Errorf("Status is %q, expected %q", stored.Status, test.status)
+			}
+
+			if test.status == oauth2.DeviceCodeStatusApproved && stored.OwnerId != testOwnerId {
+				t.Errorf("Owner is %q, expected %q", stored.OwnerId, testOwnerId)
+			}
+		})
+	}
+}
+
+func TestConcurrentDeviceCodeDecisions(t *testing.T) {
+	oauthServer, _ := newTestServer()
+	repository := newDeviceCodeRepository()
+
+	// Both requests read the pending code before either stores its decision
+	var read sync.WaitGroup
+	read.Add(2)
+
+	handler := func(r *http.Request, code *oauth2.DeviceCode) (oauth2.OauthTokenOwnerId, error) {
+		read.Done()
+		read.Wait()
+
+		if r.FormValue("decision") == "deny" {
+			return "", oauth2.NewError(oauth2.AccessDeniedErr, "Denied")
+		}
+
+		return testOwnerId, nil
+	}
+
+	grant := NewDeviceCodeGrant(oauthServer, repository, handler, DeviceCodeGrantDefaultConfig)
+	code := newTestDeviceCode(t, repository, nil)
+
+	decisions := map[string]oauth2.DeviceCodeStatus{
+		"approve": oauth2.DeviceCodeStatusApproved,
+		"deny":    oauth2.DeviceCodeStatusDenied,
+	}
+
+	var mutex sync.Mutex
+	winners := make([]string, 0)
+
+	var wg sync.WaitGroup
+	for decision := range decisions {
+		wg.Add(1)
+
+		go func(decision string) {
+			defer wg.Done()
+
+			values := url.Values{"user_code": {code.UserCode}, "decision": {decision}}
+
+			err := grant.VerifyUserCode(newFormRequest(values))
+			if err == nil {
+				mutex.Lock()
+				winners = append(winners, decision)
+				mutex.Unlock()
+			} else if errorType(err) != oauth2.InvalidRequestErr {
+				t.Errorf("The %s request failed with %v, expected %q", decision, err, oauth2.InvalidRequestErr)
+			}
+		}(decision)
+	}
+
+	wg.Wait()
+
+	if len(winners) != 1 {
+		t.Fatalf("%d decisions were stored, expected 1", len(winners))
+	}
+
+	stored, err := repository.GetDeviceCode(code.Token)
+	if err != nil {
+		t.Fatalf("GetDeviceCode failed: %v", err)
+	}
+
+	if stored.Status != decisions[winners[0]] {
+		t.Errorf("Status is %q, expected the decision of the winning request %q", stored.Status, decisions[winners[0]])
+	}
+}
@@ -0,0 +1,61 @@
+package grant
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+type implicitGrant struct {
+	server  oauth2.Server
+	handler AuthorizationCodeHandler
+	config  ImplicitGrantConfig
+}
+
+// NewImplicitGrant creates a grant for response_type=token, the handler resolves the resource owner
+// the same way as for the authorization code grant. Requires ServerConfig.AllowImplicitGrant.
+func NewImplicitGrant(server oauth2.Server, handler AuthorizationCodeHandler, config ImplicitGrantConfig) oauth2.OauthGrant {
+	return &implicitGrant{
+		server:  server,
+		handler: handler,
+		config:  config,
+	}
+}
+
+func (grant *implicitGrant) CreateAuthorizationCode(r *http.Request, clientId string) (*oauth2.AuthorizationCode, error) {
+	return nil, oauth2.NewError(oauth2.InvalidRequestErr, "Implicit grant does not support authorization codes")
+}
+
+func (grant *implicitGrant) CreateTokens(r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	return grant.CreateTokensContext(r.Context(), r, clientId)
+}
+
+func (grant *implicitGrant) CreateTokensContext(ctx context.Context, r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	scopes := make([]string, 0)
+	if providedScopes := r.FormValue("scope"); providedScopes != "" {
+		scopes = strings.Split(providedScopes, " ")
+	}
+
+	if grant.handler == nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, "Implicit grant not configured correctly")
+	}
+
+	tokenOwnerId, err := grant.handler(r, clientId, scopes)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	accessToken, err := grant.server.CreateAccessTokenContext(ctx, clientId, tokenOwnerId, grant.config.AccessTokenDuration, scopes, oauth2.TokenOptions{})
+	if err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	// The implicit grant MUST NOT issue refresh tokens
+	return accessToken, nil, nil, nil
+}
+
+func (grant *implicitGrant) AllowPublicClients() bool {
+	return true
+}
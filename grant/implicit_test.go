@@ -0,0 +1,119 @@
+package grant
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+func TestImplicitGrant(t *testing.T) {
+	approve := func(r *http.Request, clientId string, scopes []string) (oauth2.OauthTokenOwnerId, error) {
+		return testOwnerId, nil
+	}
+
+	tests := []struct {
+		name    string
+		allow   bool
+		handler AuthorizationCodeHandler
+		// Parameters expected in the fragment, or in the query for errors of disabled implicit grants
+		fragment bool
+		err      oauth2.OauthErrorType
+	}{
+		{name: "enabled", allow: true, handler: approve, fragment: true},
+		{name: "disabled", handler: approve, err: oauth2.UnsupportedResponseTypeErr},
+		{
+			name:  "denied",
+			allow: true,
+			handler: func(r *http.Request, clientId string, scopes []string) (oauth2.OauthTokenOwnerId, error) {
+				return "", oauth2.NewError(oauth2.AccessDeniedErr, "Denied")
+			},
+			fragment: true,
+			err:      oauth2.AccessDeniedErr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauthServer, repository := newTestServer()
+			oauthServer.Config.AllowImplicitGrant = test.allow
+			oauthServer.Config.ClientRedirectUriHandler = func(clientId, redirectUri string) (bool, error) {
+				return redirectUri == testRedirectUri, nil
+			}
+			oauthServer.Config.Grants = map[oauth2.GrantType]oauth2.OauthGrant{
+				oauth2.GrantTypeImplicit: NewImplicitGrant(oauthServer, test.handler, ImplicitGrantDefaultConfig),
+			}
+
+			values := url.Values{
+				"client_id":     {testClientId},
+				"redirect_uri":  {testRedirectUri},
+				"response_type": {"token"},
+				"scope":         {"read"},
+				"state":         {"xyz"},
+			}
+
+			w := httptest.NewRecorder()
+			oauthServer.HandleAuthorizationRequest(w, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+values.Encode(), nil))
+
+			location, err := url.Parse(w.Header().Get("Location"))
+			if err != nil || location.Scheme+"://"+location.Host+location.Path != testRedirectUri {
+				t.Fatalf("Redirected to %q, expected %q", w.Header().Get("Location"), testRedirectUri)
+			}
+
+			encoded := location.RawQuery
+			if test.fragment {
+				encoded = location.Fragment
+
+				// Tokens must never end up in the query, it is sent to the server of the client
+				if location.RawQuery != "" {
+					t.Errorf("Redirect has the query %q", location.RawQuery)
+				}
+			}
+
+			params, err := url.ParseQuery(encoded)
+			if err != nil {
+				t.Fatalf("ParseQuery of %q failed: %v", encoded, err)
+			}
+
+			if params.Get("state") != "xyz" {
+				t.Errorf("State is %q, expected %q", params.Get("state"), "xyz")
+			}
+
+			if oauth2.OauthErrorType(params.Get("error")) != test.err {
+				t.Fatalf("Expected error %q, got %q", test.err, params.Get("error"))
+			}
+
+			if test.err != "" {
+				if params.Get("access_token") != "" {
+					t.Errorf("An access token was issued")
+				}
+
+				return
+			}
+
+			if params.Get("token_type") != string(oauth2.TokenTypeBearer) || params.Get("scope") != "read" {
+				t.Errorf("Token type is %q and scope %q", params.Get("token_type"), params.Get("scope"))
+			}
+
+			if expiresIn, err := strconv.Atoi(params.Get("expires_in")); err != nil || expiresIn < 3590 || expiresIn > 3600 {
+				t.Errorf("Token expires in %q, expected an hour", params.Get("expires_in"))
+			}
+
+			if params.Get("refresh_token") != "" {
+				t.Errorf("A refresh token was issued")
+			}
+
+			accessToken, err := repository.GetAccessToken(params.Get("access_token"))
+			if err != nil {
+				t.Fatalf("GetAccessToken failed: %v", err)
+			}
+
+			if accessToken.ClientId != testClientId || accessToken.OwnerId != testOwnerId {
+				t.Errorf("Access token was issued to %q %q", accessToken.ClientId, accessToken.OwnerId)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package grant
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/jwt"
+)
+
+// JwtBearerKeySetHandler returns the keys trusted to sign assertions for the issuer
+type JwtBearerKeySetHandler func(issuer string) (jwt.KeySet, error)
+
+// JwtBearerAuthorizationHandler maps the subject of a verified assertion to a token owner
+type JwtBearerAuthorizationHandler func(issuer, subject string) (oauth2.OauthTokenOwnerId, error)
+
+type jwtBearerGrant struct {
+	server          oauth2.Server
+	keySetHandler   JwtBearerKeySetHandler
+	handler         JwtBearerAuthorizationHandler
+	jwtIdRepository oauth2.JwtIdRepository
+	config          JwtBearerGrantConfig
+}
+
+func NewJwtBearerGrant(
+	server oauth2.Server,
+	keySetHandler JwtBearerKeySetHandler,
+	handler JwtBearerAuthorizationHandler,
+	jwtIdRepository oauth2.JwtIdRepository,
+	config JwtBearerGrantConfig,
+) oauth2.OauthGrant {
+	return &jwtBearerGrant{
+		server:          server,
+		keySetHandler:   keySetHandler,
+		handler:         handler,
+		jwtIdRepository: jwtIdRepository,
+		config:          config,
+	}
+}
+
+func (grant *jwtBearerGrant) CreateAuthorizationCode(r *http.Request, clientId string) (*oauth2.AuthorizationCode, error) {
+	return nil, oauth2.NewError(oauth2.InvalidRequestErr, "JWT bearer grant does not support authorization")
+}
+
+func (grant *jwtBearerGrant) CreateTokens(r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	return grant.CreateTokensContext(r.Context(), r, clientId)
+}
+
+func (grant *jwtBearerGrant) CreateTokensContext(ctx context.Context, r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	assertion := r.FormValue("assertion")
+
+	scopes := make([]string, 0)
+	if providedScopes := r.FormValue("scope"); providedScopes != "" {
+		scopes = strings.Split(providedScopes, " ")
+	}
+
+	if assertion == "" {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidRequestErr, "Missing assertion")
+	}
+
+	if grant.keySetHandler == nil || grant.handler == nil || len(grant.config.Audience) == 0 {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, "JWT bearer grant not configured correctly")
+	}
+
+	token, err := jwt.Parse(assertion)
+	if err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Assertion is malformed")
+	}
+
+	claims := &jwt.Claims{}
+	if err = token.Claims(claims); err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Assertion is malformed")
+	}
+
+	if claims.Issuer == "" || claims.Subject == "" {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Assertion is missing the iss or sub claim")
+	}
+
+	keys, err := grant.keySetHandler(claims.Issuer)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err = token.Verify(keys); err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Assertion signature is invalid")
+	}
+
+	if err = grant.validateClaims(claims); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Remember the jti until the assertion expires to prevent replays
+	if claims.Id != "" && grant.jwtIdRepository != nil {
+		jwtId := &oauth2.JwtId{
+			Issuer:    claims.Issuer,
+			Id:        claims.Id,
+			ExpiresAt: claims.ExpiresAtTime().Add(grant.config.ClockSkew),
+		}
+
+		if err = grant.jwtIdRepository.CreateJwtId(jwtId); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	tokenOwnerId, err := grant.handler(claims.Issuer, claims.Subject)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	accessToken, err := grant.server.CreateAccessTokenContext(ctx, clientId, tokenOwnerId, grant.config.AccessTokenDuration, scopes, oauth2.TokenOptions{})
+	if err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	return accessToken, nil, nil, nil
+}
+
+func (grant *jwtBearerGrant) validateClaims(claims *jwt.Claims) error {
+	now := time.Now()
+
+	switch claims.Validate(now, grant.config.ClockSkew) {
+	case nil:
+	case jwt.ExpiredErr:
+		return oauth2.NewError(oauth2.InvalidGrantErr, "Assertion has expired")
+	case jwt.NotYetValidErr:
+		return oauth2.NewError(oauth2.InvalidGrantErr, "Assertion is not valid yet")
+	default:
+		return oauth2.NewError(oauth2.InvalidGrantErr, "Assertion is missing the exp claim")
+	}
+
+	if grant.config.MaxAssertionLifetime > 0 && claims.ExpiresAtTime().Sub(now) > grant.config.MaxAssertionLifetime {
+		return oauth2.NewError(oauth2.InvalidGrantErr, "Assertion is valid for too long")
+	}
+
+	audienceMatches := false
+	for _, audience := range grant.config.Audience {
+		if claims.Audience.Contains(audience) {
+			audienceMatches = true
+			break
+		}
+	}
+
+	if !audienceMatches {
+		return oauth2.NewError(oauth2.InvalidGrantErr, "Assertion audience does not match this server")
+	}
+
+	if claims.Id == "" && grant.config.RequireJwtId {
+		return oauth2.NewError(oauth2.InvalidGrantErr, "Assertion is missing the jti claim")
+	}
+
+	if claims.Id != "" && grant.jwtIdRepository == nil && grant.config.RequireJwtId {
+		return oauth2.NewError(oauth2.ServerErrorErr, "JWT bearer grant not configured correctly")
+	}
+
+	return nil
+}
+
+func (grant *jwtBearerGrant) AllowPublicClients() bool {
+	return true
+}
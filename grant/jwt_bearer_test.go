@@ -0,0 +1,175 @@
+package grant
+
+import (
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/jwt"
+)
+
+const (
+	testIssuer        = "https://idp.example.com"
+	testTokenEndpoint = "https://example.com/oauth/token"
+)
+
+// Keeps the used jwt ids in a map
+type jwtIdRepository struct {
+	mutex sync.Mutex
+	ids   map[string]oauth2.JwtId
+}
+
+func newJwtIdRepository() *jwtIdRepository {
+	return &jwtIdRepository{ids: make(map[string]oauth2.JwtId)}
+}
+
+func (repository *jwtIdRepository) CreateJwtId(jwtId *oauth2.JwtId) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	key := jwtId.Issuer + " " + jwtId.Id
+	if _, ok := repository.ids[key]; ok {
+		return oauth2.JwtIdAlreadyUsedErr
+	}
+
+	repository.ids[key] = *jwtId
+
+	return nil
+}
+
+func (repository *jwtIdRepository) DeleteExpiredJwtIds() error {
+	return nil
+}
+
+func TestJwtBearerGrant(t *testing.T) {
+	secret := []byte("a secret of at least thirty-two bytes")
+	signer := jwt.NewHS256Signer("key", secret)
+
+	keySetHandler := func(issuer string) (jwt.KeySet, error) {
+		if issuer != testIssuer {
+			return jwt.KeySet{}, nil
+		}
+
+		return jwt.KeySet{{Id: "key", Algorithm: jwt.HS256, Key: secret}}, nil
+	}
+
+	handler := func(issuer, subject string) (oauth2.OauthTokenOwnerId, error) {
+		return oauth2.OauthTokenOwnerId(subject), nil
+	}
+
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		signer jwt.Signer
+		modify func(claims *jwt.Claims)
+		err    oauth2.OauthErrorType
+	}{
+		{name: "valid"},
+		{name: "bad signature", signer: jwt.NewHS256Signer("key", []byte("another secret of thirty-two bytes")), err: oauth2.InvalidGrantErr},
+		{name: "unknown key", signer: jwt.NewHS256Signer("other", secret), err: oauth2.InvalidGrantErr},
+		{name: "unknown issuer", modify: func(claims *jwt.Claims) { claims.Issuer = "https://attacker.example.com" }, err: oauth2.InvalidGrantErr},
+		{name: "missing issuer", modify: func(claims *jwt.Claims) { claims.Issuer = "" }, err: oauth2.InvalidGrantErr},
+		{name: "missing subject", modify: func(claims *jwt.Claims) { claims.Subject = "" }, err: oauth2.InvalidGrantErr},
+		{name: "expired", modify: func(claims *jwt.Claims) { claims.ExpiresAt = now.Add(-2 * time.Minute).Unix() }, err: oauth2.InvalidGrantErr},
+		{name: "expired within the clock skew", modify: func(claims *jwt.Claims) { claims.ExpiresAt = now.Add(-30 * time.Second).Unix() }},
+		{name: "missing expiration", modify: func(claims *jwt.Claims) { claims.ExpiresAt = 0 }, err: oauth2.InvalidGrantErr},
+		{name: "valid for too long", modify: func(claims *jwt.Claims) { claims.ExpiresAt = now.Add(2 * time.Hour).Unix() }, err: oauth2.InvalidGrantErr},
+		{name: "not yet valid", modify: func(claims *jwt.Claims) { claims.NotBefore = now.Add(2 * time.Minute).Unix() }, err: oauth2.InvalidGrantErr},
+		{name: "another audience", modify: func(claims *jwt.Claims) { claims.Audience = jwt.Audience{"https://other.example.com"} }, err: oauth2.InvalidGrantErr},
+		{name: "missing jwt id", modify: func(claims *jwt.Claims) { claims.Id = "" }, err: oauth2.InvalidGrantErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := JwtBearerGrantDefaultConfig
+			config.Audience = []string{testTokenEndpoint}
+
+			oauthServer, _ := newTestServer()
+			grant := NewJwtBearerGrant(oauthServer, keySetHandler, handler, newJwtIdRepository(), config)
+
+			claims := jwt.Claims{
+				Issuer:    testIssuer,
+				Subject:   testOwnerId,
+				Audience:  jwt.Audience{testTokenEndpoint},
+				ExpiresAt: now.Add(5 * time.Minute).Unix(),
+				Id:        oauth2.GenerateRandomString(16),
+			}
+
+			if test.modify != nil {
+				test.modify(&claims)
+			}
+
+			assertionSigner := test.signer
+			if assertionSigner == nil {
+				assertionSigner = signer
+			}
+
+			assertion, err := jwt.Sign(assertionSigner, "JWT", claims)
+			if err != nil {
+				t.Fatalf("Sign failed: %v", err)
+			}
+
+			accessToken, _, _, err := grant.CreateTokens(newFormRequest(url.Values{"assertion": {assertion}}), testClientId)
+			if errorType(err) != test.err {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+
+			if test.err == "" && accessToken.OwnerId != testOwnerId {
+				t.Errorf("Access token was issued to %q, expected %q", accessToken.OwnerId, testOwnerId)
+			}
+		})
+	}
+}
+
+func TestJwtBearerGrantReplay(t *testing.T) {
+	secret := []byte("a secret of at least thirty-two bytes")
+
+	keySetHandler := func(issuer string) (jwt.KeySet, error) {
+		return jwt.KeySet{{Id: "key", Algorithm: jwt.HS256, Key: secret}}, nil
+	}
+
+	handler := func(issuer, subject string) (oauth2.OauthTokenOwnerId, error) {
+		return oauth2.OauthTokenOwnerId(subject), nil
+	}
+
+	config := JwtBearerGrantDefaultConfig
+	config.Audience = []string{testTokenEndpoint}
+
+	oauthServer, _ := newTestServer()
+	repository := newJwtIdRepository()
+	grant := NewJwtBearerGrant(oauthServer, keySetHandler, handler, repository, config)
+
+	assertion, err := jwt.Sign(jwt.NewHS256Signer("key", secret), "JWT", jwt.Claims{
+		Issuer:    testIssuer,
+		Subject:   testOwnerId,
+		Audience:  jwt.Audience{testTokenEndpoint},
+		ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
+		Id:        "assertion",
+	})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	request := func() error {
+		_, _, _, err := grant.CreateTokens(newFormRequest(url.Values{"assertion": {assertion}}), testClientId)
+
+		return err
+	}
+
+	if err = request(); err != nil {
+		t.Fatalf("CreateTokens failed: %v", err)
+	}
+
+	if err = request(); err != oauth2.JwtIdAlreadyUsedErr {
+		t.Errorf("Replay returned %v, expected %v", err, oauth2.JwtIdAlreadyUsedErr)
+	}
+
+	// The jti is remembered until the assertion can no longer be used
+	jwtId := repository.ids[testIssuer+" assertion"]
+	if jwtId.ExpiresAt.Before(time.Now().Add(5*time.Minute + config.ClockSkew - time.Second)) {
+		t.Errorf("Jwt id expires at %v, before the assertion including the clock skew", jwtId.ExpiresAt)
+	}
+}
@@ -1,6 +1,7 @@
 package grant
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
@@ -9,7 +10,21 @@ import (
 
 type PasswordAuthorizationHandler func(username, password string) (oauth2.OauthTokenOwnerId, error)
 
+// Like PasswordAuthorizationHandler but receives the context of the token request
+type PasswordAuthorizationContextHandler func(ctx context.Context, username, password string) (oauth2.OauthTokenOwnerId, error)
+
 func NewPasswordGrant(server oauth2.Server, handler PasswordAuthorizationHandler, config PasswordGrantConfig) oauth2.OauthGrant {
+	var contextHandler PasswordAuthorizationContextHandler
+	if handler != nil {
+		contextHandler = func(ctx context.Context, username, password string) (oauth2.OauthTokenOwnerId, error) {
+			return handler(username, password)
+		}
+	}
+
+	return NewPasswordGrantContext(server, contextHandler, config)
+}
+
+func NewPasswordGrantContext(server oauth2.Server, handler PasswordAuthorizationContextHandler, config PasswordGrantConfig) oauth2.OauthGrant {
 	return &passwordGrant{
 		server:  server,
 		handler: handler,
@@ -19,7 +34,7 @@ func NewPasswordGrant(server oauth2.Server, handler PasswordAuthorizationHandler
 
 type passwordGrant struct {
 	server  oauth2.Server
-	handler PasswordAuthorizationHandler
+	handler PasswordAuthorizationContextHandler
 	config  PasswordGrantConfig
 }
 
@@ -28,6 +43,10 @@ func (grant *passwordGrant) CreateAuthorizationCode(r *http.Request, clientId st
 }
 
 func (grant *passwordGrant) CreateTokens(r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	return grant.CreateTokensContext(r.Context(), r, clientId)
+}
+
+func (grant *passwordGrant) CreateTokensContext(ctx context.Context, r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
 	username := r.FormValue("username")
 	password := r.FormValue("password")
 
@@ -40,7 +59,7 @@ func (grant *passwordGrant) CreateTokens(r *http.Request, clientId string) (*oau
 		return nil, nil, nil, oauth2.NewError(oauth2.InvalidRequestErr, "Missing username and/or password")
 	}
 
-	if err := grant.server.CallbackPreGrant(username, r.RemoteAddr); err != nil {
+	if err := grant.server.CallbackPreGrantContext(ctx, username, r.RemoteAddr); err != nil {
 		return nil, nil, nil, err
 	}
 
@@ -48,10 +67,10 @@ func (grant *passwordGrant) CreateTokens(r *http.Request, clientId string) (*oau
 		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, "Password grant not configured correctly")
 	}
 
-	tokenOwnerId, err := grant.handler(username, password)
+	tokenOwnerId, err := grant.handler(ctx, username, password)
 	if err != nil {
 		// Empty token signals a failed authentication attempt
-		grant.server.CallbackPostGrant(username, grant.server.GetRemoteAddr(r), "")
+		grant.server.CallbackPostGrantContext(ctx, username, grant.server.GetRemoteAddr(r), "")
 
 		return nil, nil, nil, err
 	}
@@ -59,22 +78,25 @@ func (grant *passwordGrant) CreateTokens(r *http.Request, clientId string) (*oau
 	var accessToken *oauth2.AccessToken
 	var refreshToken *oauth2.RefreshToken
 
+	// Tokens issued together belong to the same family, which is revoked as a whole on refresh token reuse
+	familyId := oauth2.NewTokenFamilyId()
+
 	// Generate access token until it is unique
-	accessToken, err = grant.server.CreateAccessToken(clientId, tokenOwnerId, grant.config.AccessTokenDuration, scopes)
+	accessToken, err = grant.server.CreateAccessTokenContext(ctx, clientId, tokenOwnerId, grant.config.AccessTokenDuration, scopes, oauth2.TokenOptions{FamilyId: familyId})
 	if err != nil {
 		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
 	}
 
 	// Should we also generate a refresh token
 	if grant.config.GenerateRefreshToken {
-		refreshToken, err = grant.server.CreateRefreshToken(clientId, tokenOwnerId, grant.config.RefreshTokenDuration, scopes)
+		refreshToken, err = grant.server.CreateRefreshTokenContext(ctx, clientId, tokenOwnerId, grant.config.RefreshTokenDuration, scopes, oauth2.TokenOptions{FamilyId: familyId})
 		if err != nil {
 			return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
 		}
 	}
 
 	// Callback with a valid token signals a successful login
-	grant.server.CallbackPostGrant(username, grant.server.GetRemoteAddr(r), accessToken.Token)
+	grant.server.CallbackPostGrantContext(ctx, username, grant.server.GetRemoteAddr(r), accessToken.Token)
 
 	return accessToken, refreshToken, nil, nil
 }
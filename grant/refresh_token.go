@@ -1,8 +1,10 @@
 package grant
 
 import (
+	"context"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/interactive-solutions/go-oauth2"
 )
@@ -10,14 +12,19 @@ import (
 type refreshTokenGrant struct {
 	config     RefreshTokenGrantConfig
 	server     oauth2.Server
-	repository oauth2.TokenRepository
+	repository oauth2.ContextTokenRepository
 }
 
 func NewRefreshTokenGrant(server oauth2.Server, repository oauth2.TokenRepository, config RefreshTokenGrantConfig) oauth2.OauthGrant {
+	// Only reuse detection keeps the rotated tokens the grace period returns the successors of
+	if config.RotationGracePeriod > 0 && !config.DetectRefreshTokenReuse {
+		panic("Refresh token grant rotation grace period requires DetectRefreshTokenReuse")
+	}
+
 	return &refreshTokenGrant{
 		config:     config,
 		server:     server,
-		repository: repository,
+		repository: oauth2.NewContextTokenRepository(repository),
 	}
 }
 
@@ -26,6 +33,10 @@ func (grant *refreshTokenGrant) CreateAuthorizationCode(r *http.Request, clientI
 }
 
 func (grant *refreshTokenGrant) CreateTokens(r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	return grant.CreateTokensContext(r.Context(), r, clientId)
+}
+
+func (grant *refreshTokenGrant) CreateTokensContext(ctx context.Context, r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
 	providedToken := r.FormValue("refresh_token")
 
 	scopes := make([]string, 0)
@@ -38,43 +49,125 @@ func (grant *refreshTokenGrant) CreateTokens(r *http.Request, clientId string) (
 	}
 
 	// Retrieve refresh token from repository
-	refreshToken, err := grant.repository.GetRefreshToken(providedToken)
+	refreshToken, err := grant.repository.GetRefreshTokenContext(ctx, providedToken)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
+	// A rotated refresh token should never be used again, assume it has been stolen
+	if refreshToken.IsRotated() {
+		return grant.handleRotated(ctx, r, refreshToken)
+	}
+
 	// Validate refresh token
 	if refreshToken.IsExpired() {
 		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Refresh token has expired")
 	}
 
+	now := time.Now()
+
+	// Tokens issued before the authentication time was recorded start their session now
+	authenticatedAt := refreshToken.AuthenticatedAt
+	if authenticatedAt.IsZero() {
+		authenticatedAt = now
+	}
+
+	sessionExpiresAt := grant.sessionExpiresAt(authenticatedAt)
+
+	if !sessionExpiresAt.IsZero() && !now.Before(sessionExpiresAt) {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Session has expired")
+	}
+
 	if !refreshToken.MatchScopes(scopes) {
 		return nil, nil, nil, oauth2.NewError(oauth2.InvalidScopeErr, "The scope of the new access token exceeds the scope(s) of the refresh token")
 	}
 
+	// Tokens issued before families were introduced start a new family
+	familyId := refreshToken.FamilyId
+	if familyId == "" {
+		familyId = oauth2.NewTokenFamilyId()
+	}
+
 	var accessToken *oauth2.AccessToken
 	var newRefreshToken *oauth2.RefreshToken
 
 	// Generate access token until it is unique
-	accessToken, err = grant.server.CreateAccessToken(clientId, refreshToken.OwnerId, grant.config.AccessTokenDuration, scopes)
+	accessToken, err = grant.server.CreateAccessTokenContext(
+		ctx,
+		clientId,
+		refreshToken.OwnerId,
+		capDuration(now, grant.config.AccessTokenDuration, sessionExpiresAt),
+		scopes,
+		oauth2.TokenOptions{FamilyId: familyId, AuthenticatedAt: authenticatedAt},
+	)
 	if err != nil {
 		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
 	}
 
 	// Return current refresh token if we're not generating a new one
 	if !grant.config.RotateRefreshTokens {
+		expiresAt := refreshToken.ExpiresAt
+
+		// Slide the expiry of the current refresh token
+		if grant.config.RefreshTokenIdleTimeout > 0 {
+			expiresAt = now.Add(grant.config.RefreshTokenIdleTimeout)
+		}
+
+		// The stored authentication time isn't updated, so a session started now is limited by the expiry
+		if !sessionExpiresAt.IsZero() && expiresAt.After(sessionExpiresAt) {
+			expiresAt = sessionExpiresAt
+		}
+
+		if !expiresAt.Equal(refreshToken.ExpiresAt) {
+			refreshToken.ExpiresAt = expiresAt
+
+			if err = grant.repository.UpdateRefreshTokenExpiresAtContext(ctx, refreshToken.Token, refreshToken.ExpiresAt); err != nil {
+				return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+			}
+		}
+
 		return accessToken, refreshToken, nil, nil
 	}
 
+	refreshTokenDuration := grant.config.RefreshTokenDuration
+	if grant.config.RefreshTokenIdleTimeout > 0 {
+		refreshTokenDuration = grant.config.RefreshTokenIdleTimeout
+	}
+
 	// Should we also generate a refresh token
-	refreshToken, err = grant.server.CreateRefreshToken(clientId, refreshToken.OwnerId, grant.config.RefreshTokenDuration, scopes)
+	newRefreshToken, err = grant.server.CreateRefreshTokenContext(
+		ctx,
+		clientId,
+		refreshToken.OwnerId,
+		capDuration(now, refreshTokenDuration, sessionExpiresAt),
+		scopes,
+		oauth2.TokenOptions{FamilyId: familyId, AuthenticatedAt: authenticatedAt},
+	)
 	if err != nil {
 		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
 	}
 
+	// Mark the old token as rotated, only one request can win the rotation. Tokens without a family
+	// join the new one so reusing them revokes their successors
+	if grant.config.DetectRefreshTokenReuse {
+		refreshToken.FamilyId = familyId
+		refreshToken.RotatedAt = now
+		refreshToken.RotatedTo = newRefreshToken.Token
+		refreshToken.RotatedToAccessToken = accessToken.Token
+
+		err = grant.repository.RotateRefreshTokenContext(ctx, refreshToken)
+		if err == oauth2.RefreshTokenAlreadyRotatedErr {
+			return grant.handleLostRotation(ctx, r, refreshToken, accessToken, newRefreshToken)
+		} else if err != nil {
+			return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+		}
+
+		return accessToken, newRefreshToken, nil, nil
+	}
+
 	// Should we delete the old refresh token ?
 	if grant.config.RevokeRotatedRefreshTokens {
-		if err = grant.repository.DeleteRefreshToken(refreshToken.Token); err != nil {
+		if err = grant.repository.DeleteRefreshTokenContext(ctx, refreshToken.Token); err != nil {
 			return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
 		}
 	}
@@ -82,6 +175,106 @@ func (grant *refreshTokenGrant) CreateTokens(r *http.Request, clientId string) (
 	return accessToken, newRefreshToken, nil, nil
 }
 
+// The absolute expiry of the session, zero if there is no maximum lifetime
+func (grant *refreshTokenGrant) sessionExpiresAt(authenticatedAt time.Time) time.Time {
+	if grant.config.RefreshTokenMaxLifetime <= 0 {
+		return time.Time{}
+	}
+
+	return authenticatedAt.Add(grant.config.RefreshTokenMaxLifetime)
+}
+
+// Shorten the duration so a token never outlives the session
+func capDuration(now time.Time, duration time.Duration, expiresAt time.Time) time.Duration {
+	if !expiresAt.IsZero() && now.Add(duration).After(expiresAt) {
+		return expiresAt.Sub(now)
+	}
+
+	return duration
+}
+
+// Another request rotated the token at the same time, discard our tokens and use the winners
+func (grant *refreshTokenGrant) handleLostRotation(
+	ctx context.Context,
+	r *http.Request,
+	refreshToken *oauth2.RefreshToken,
+	accessToken *oauth2.AccessToken,
+	newRefreshToken *oauth2.RefreshToken,
+) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	if err := grant.repository.DeleteAccessTokenContext(ctx, accessToken.Token); err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	if err := grant.repository.DeleteRefreshTokenContext(ctx, newRefreshToken.Token); err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	rotatedToken, err := grant.repository.GetRefreshTokenContext(ctx, refreshToken.Token)
+	if err == oauth2.RefreshTokenNotFoundErr {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Refresh token has been revoked")
+	} else if err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	return grant.handleRotated(ctx, r, rotatedToken)
+}
+
+// A rotated token within the grace period returns its successors, otherwise it is reuse
+func (grant *refreshTokenGrant) handleRotated(
+	ctx context.Context,
+	r *http.Request,
+	refreshToken *oauth2.RefreshToken,
+) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	if time.Since(refreshToken.RotatedAt) > grant.config.RotationGracePeriod {
+		return nil, nil, nil, grant.handleReuse(ctx, r, refreshToken)
+	}
+
+	// Repositories storing hashed tokens can't return the successors
+	if refreshToken.RotatedToAccessToken == "" {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidGrantErr, "Refresh token has already been rotated")
+	}
+
+	successorRefreshToken, err := grant.repository.GetRefreshTokenContext(ctx, refreshToken.RotatedTo)
+	if err == oauth2.RefreshTokenNotFoundErr {
+		return nil, nil, nil, grant.handleReuse(ctx, r, refreshToken)
+	} else if err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	// The successor has been rotated as well, the family has moved on without this client
+	if successorRefreshToken.IsRotated() {
+		return nil, nil, nil, grant.handleReuse(ctx, r, refreshToken)
+	}
+
+	successorAccessToken, err := grant.repository.GetAccessTokenContext(ctx, refreshToken.RotatedToAccessToken)
+	if err == oauth2.AccessTokenNotFoundErr {
+		return nil, nil, nil, grant.handleReuse(ctx, r, refreshToken)
+	} else if err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	return successorAccessToken, successorRefreshToken, nil, nil
+}
+
+// Revoke the whole token family when a rotated refresh token is used again
+func (grant *refreshTokenGrant) handleReuse(ctx context.Context, r *http.Request, refreshToken *oauth2.RefreshToken) error {
+	var err error
+
+	if refreshToken.FamilyId != "" {
+		err = grant.repository.DeleteTokenFamilyContext(ctx, refreshToken.FamilyId)
+	} else {
+		err = grant.repository.DeleteRefreshTokenContext(ctx, refreshToken.Token)
+	}
+
+	if err != nil {
+		return oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	grant.server.CallbackRefreshTokenReuseContext(ctx, refreshToken, grant.server.GetRemoteAddr(r))
+
+	return oauth2.NewError(oauth2.InvalidGrantErr, "Refresh token has been revoked")
+}
+
 func (grant *refreshTokenGrant) AllowPublicClients() bool {
 	return true
 }
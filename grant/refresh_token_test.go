@@ -0,0 +1,399 @@
+package grant
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/server"
+)
+
+var reuseDetectionConfig = RefreshTokenGrantConfig{
+	AccessTokenDuration:     time.Hour,
+	RefreshTokenDuration:    time.Hour * 24,
+	RotateRefreshTokens:     true,
+	DetectRefreshTokenReuse: true,
+}
+
+func newTestRefreshToken(t *testing.T, oauthServer *server.OauthServer, familyId string) *oauth2.RefreshToken {
+	refreshToken, err := oauthServer.CreateRefreshTokenContext(
+		context.Background(),
+		testClientId,
+		testOwnerId,
+		time.Hour,
+		nil,
+		oauth2.TokenOptions{FamilyId: familyId},
+	)
+	if err != nil {
+		t.Fatalf("CreateRefreshToken failed: %v", err)
+	}
+
+	return refreshToken
+}
+
+func refresh(grant oauth2.OauthGrant, token string) (*oauth2.AccessToken, *oauth2.RefreshToken, error) {
+	accessToken, refreshToken, _, err := grant.CreateTokens(newFormRequest(url.Values{"refresh_token": {token}}), testClientId)
+
+	return accessToken, refreshToken, err
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	tests := []struct {
+		name     string
+		familyId string
+		reuse    bool
+		err      oauth2.OauthErrorType
+		revoked  bool
+	}{
+		{name: "rotation", familyId: oauth2.NewTokenFamilyId()},
+		{name: "reuse", familyId: oauth2.NewTokenFamilyId(), reuse: true, err: oauth2.InvalidGrantErr, revoked: true},
+		{name: "reuse of a token issued without a family", reuse: true, err: oauth2.InvalidGrantErr, revoked: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauthServer, repository := newTestServer()
+			grant := NewRefreshTokenGrant(oauthServer, repository, reuseDetectionConfig)
+
+			reused := 0
+			oauthServer.Config.CallbackRefreshTokenReuse = func(refreshToken *oauth2.RefreshToken, ipAddr string) {
+				reused++
+			}
+
+			refreshToken := newTestRefreshToken(t, oauthServer, test.familyId)
+
+			successorAccessToken, successorRefreshToken, err := refresh(grant, refreshToken.Token)
+			if err != nil {
+				t.Fatalf("Refresh failed: %v", err)
+			}
+
+			if successorRefreshToken.Token == refreshToken.Token {
+				t.Fatalf("Refresh token was not rotated")
+			}
+
+			if test.reuse {
+				_, _, err = refresh(grant, refreshToken.Token)
+			}
+
+			if errorType(err) != test.err {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+
+			if test.revoked != (reused > 0) {
+				t.Errorf("Reuse callback called %d times, expected revoked to be %v", reused, test.revoked)
+			}
+
+			// Reuse revokes the successors, also of tokens that had no family before the rotation
+			_, err = repository.GetRefreshToken(successorRefreshToken.Token)
+			if test.revoked != (err == oauth2.RefreshTokenNotFoundErr) {
+				t.Errorf("Successor refresh token lookup returned %v, expected revoked to be %v", err, test.revoked)
+			}
+
+			_, err = repository.GetAccessToken(successorAccessToken.Token)
+			if test.revoked != (err == oauth2.AccessTokenNotFoundErr) {
+				t.Errorf("Successor access token lookup returned %v, expected revoked to be %v", err, test.revoked)
+			}
+
+			if test.revoked {
+				return
+			}
+
+			// The rotated token is kept to detect reuse
+			rotated, err := repository.GetRefreshToken(refreshToken.Token)
+			if err != nil {
+				t.Fatalf("GetRefreshToken of the rotated token failed: %v", err)
+			}
+
+			if !rotated.IsRotated() || rotated.RotatedTo != successorRefreshToken.Token {
+				t.Errorf("Rotated token does not point to its successor")
+			}
+		})
+	}
+}
+
+func TestRefreshTokenGracePeriod(t *testing.T) {
+	tests := []struct {
+		name            string
+		gracePeriod     time.Duration
+		rotateSuccessor bool
+		err             oauth2.OauthErrorType
+	}{
+		{name: "within the grace period", gracePeriod: time.Minute},
+		{name: "after the grace period", err: oauth2.InvalidGrantErr},
+		{name: "successor already rotated", gracePeriod: time.Minute, rotateSuccessor: true, err: oauth2.InvalidGrantErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := reuseDetectionConfig
+			config.RotationGracePeriod = test.gracePeriod
+
+			oauthServer, repository := newTestServer()
+			grant := NewRefreshTokenGrant(oauthServer, repository, config)
+
+			refreshToken := newTestRefreshToken(t, oauthServer, oauth2.NewTokenFamilyId())
+
+			successorAccessToken, successorRefreshToken, err := refresh(grant, refreshToken.Token)
+			if err != nil {
+				t.Fatalf("Refresh failed: %v", err)
+			}
+
+			if test.rotateSuccessor {
+				if _, _, err = refresh(grant, successorRefreshToken.Token); err != nil {
+					t.Fatalf("Refresh of the successor failed: %v", err)
+				}
+			}
+
+			accessToken, newRefreshToken, err := refresh(grant, refreshToken.Token)
+			if errorType(err) != test.err {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+
+			if test.err != "" {
+				if _, err = repository.GetRefreshToken(successorRefreshToken.Token); err != oauth2.RefreshTokenNotFoundErr {
+					t.Errorf("Successor refresh token was not revoked: %v", err)
+				}
+
+				return
+			}
+
+			// A retry within the grace period gets the tokens of the first request
+			if accessToken.Token != successorAccessToken.Token || newRefreshToken.Token != successorRefreshToken.Token {
+				t.Errorf("Retry returned new tokens instead of the successors")
+			}
+		})
+	}
+}
+
+func TestConcurrentRefreshWithinGracePeriod(t *testing.T) {
+	config := reuseDetectionConfig
+	config.RotationGracePeriod = time.Minute
+
+	oauthServer, repository := newTestServer()
+	grant := NewRefreshTokenGrant(oauthServer, repository, config)
+
+	refreshToken := newTestRefreshToken(t, oauthServer, oauth2.NewTokenFamilyId())
+
+	const requests = 8
+
+	tokens := make(chan string, requests)
+	errs := make(chan error, requests)
+
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, newRefreshToken, err := refresh(grant, refreshToken.Token)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			tokens <- newRefreshToken.Token
+		}()
+	}
+
+	wg.Wait()
+	close(tokens)
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("Concurrent refresh failed: %v", err)
+	}
+
+	// Every request must end up with the successor of the winning rotation
+	successors := make(map[string]bool)
+	for token := range tokens {
+		successors[token] = true
+	}
+
+	if len(successors) != 1 {
+		t.Fatalf("Concurrent refreshes returned %d different refresh tokens, expected 1", len(successors))
+	}
+
+	for token := range successors {
+		if _, err := repository.GetRefreshToken(token); err != nil {
+			t.Errorf("Successor refresh token lookup failed: %v", err)
+		}
+	}
+}
+
+func TestRotationGracePeriodConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config func(config *RefreshTokenGrantConfig)
+		panics bool
+	}{
+		{
+			name:   "with reuse detection",
+			config: func(config *RefreshTokenGrantConfig) { config.RotationGracePeriod = time.Minute },
+		},
+		{
+			name: "with revoked rotated tokens",
+			config: func(config *RefreshTokenGrantConfig) {
+				config.DetectRefreshTokenReuse = false
+				config.RevokeRotatedRefreshTokens = true
+				config.RotationGracePeriod = time.Minute
+			},
+			panics: true,
+		},
+		{
+			name: "revoked rotated tokens without grace period",
+			config: func(config *RefreshTokenGrantConfig) {
+				config.DetectRefreshTokenReuse = false
+				config.RevokeRotatedRefreshTokens = true
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := reuseDetectionConfig
+			test.config(&config)
+
+			oauthServer, repository := newTestServer()
+
+			defer func() {
+				if recovered := recover(); test.panics != (recovered != nil) {
+					t.Errorf("NewRefreshTokenGrant panicked with %v, expected a panic to be %v", recovered, test.panics)
+				}
+			}()
+
+			NewRefreshTokenGrant(oauthServer, repository, config)
+		})
+	}
+}
+
+func TestRefreshTokenSessionLifetime(t *testing.T) {
+	const (
+		idleTimeout = 2 * time.Hour
+		maxLifetime = 24 * time.Hour
+	)
+
+	tests := []struct {
+		name string
+		// How long ago the owner authenticated, zero for tokens without an authentication time
+		authenticated time.Duration
+		// Lifetime of the presented refresh token, a minute if not set
+		duration    time.Duration
+		idleTimeout time.Duration
+		maxLifetime time.Duration
+		err         oauth2.OauthErrorType
+		// Expected lifetimes of the tokens after the refresh
+		accessToken  time.Duration
+		refreshToken time.Duration
+	}{
+		{
+			name:          "idle timeout slides",
+			authenticated: time.Hour,
+			idleTimeout:   idleTimeout,
+			maxLifetime:   maxLifetime,
+			accessToken:   time.Hour,
+			refreshToken:  idleTimeout,
+		},
+		{
+			name:          "capped at the maximum lifetime",
+			authenticated: maxLifetime - 30*time.Minute,
+			idleTimeout:   idleTimeout,
+			maxLifetime:   maxLifetime,
+			accessToken:   30 * time.Minute,
+			refreshToken:  30 * time.Minute,
+		},
+		{
+			name:          "session expired",
+			authenticated: maxLifetime + time.Minute,
+			idleTimeout:   idleTimeout,
+			maxLifetime:   maxLifetime,
+			err:           oauth2.InvalidGrantErr,
+		},
+		{
+			name:         "no authentication time",
+			duration:     2 * maxLifetime,
+			maxLifetime:  maxLifetime,
+			accessToken:  time.Hour,
+			refreshToken: maxLifetime,
+		},
+	}
+
+	for _, rotate := range []bool{true, false} {
+		for _, test := range tests {
+			name := test.name
+			if !rotate {
+				name += " without rotation"
+			}
+
+			t.Run(name, func(t *testing.T) {
+				config := RefreshTokenGrantDefaultConfig
+				config.AccessTokenDuration = time.Hour
+				config.RefreshTokenDuration = 48 * time.Hour
+				config.RotateRefreshTokens = rotate
+				config.RefreshTokenIdleTimeout = test.idleTimeout
+				config.RefreshTokenMaxLifetime = test.maxLifetime
+
+				oauthServer, repository := newTestServer()
+				grant := NewRefreshTokenGrant(oauthServer, repository, config)
+
+				duration := test.duration
+				if duration == 0 {
+					duration = time.Minute
+				}
+
+				refreshToken := oauth2.NewRefreshToken(testClientId, testOwnerId, duration, nil)
+				refreshToken.AuthenticatedAt = time.Time{}
+				if test.authenticated > 0 {
+					refreshToken.AuthenticatedAt = time.Now().Add(-test.authenticated)
+				}
+
+				if err := repository.CreateRefreshToken(refreshToken); err != nil {
+					t.Fatalf("CreateRefreshToken failed: %v", err)
+				}
+
+				now := time.Now()
+
+				accessToken, newRefreshToken, err := refresh(grant, refreshToken.Token)
+				if errorType(err) != test.err {
+					t.Fatalf("Expected error %q, got %v", test.err, err)
+				}
+
+				if test.err != "" {
+					return
+				}
+
+				if rotate == (newRefreshToken.Token == refreshToken.Token) {
+					t.Fatalf("Refresh token was rotated: %v, expected %v", newRefreshToken.Token != refreshToken.Token, rotate)
+				}
+
+				// The stored expiry is checked, the non rotating path updates the existing token
+				stored, err := repository.GetRefreshToken(newRefreshToken.Token)
+				if err != nil {
+					t.Fatalf("GetRefreshToken failed: %v", err)
+				}
+
+				expiries := map[string]struct {
+					expiresAt time.Time
+					expected  time.Duration
+				}{
+					"access token":  {accessToken.ExpiresAt, test.accessToken},
+					"refresh token": {stored.ExpiresAt, test.refreshToken},
+				}
+
+				for kind, expiry := range expiries {
+					if lifetime := expiry.expiresAt.Sub(now); lifetime < expiry.expected-time.Second || lifetime > expiry.expected+time.Second {
+						t.Errorf("The %s expires in %v, expected %v", kind, lifetime.Round(time.Second), expiry.expected)
+					}
+				}
+
+				// A session started by the refresh keeps its start for the next refresh
+				if rotate && test.authenticated == 0 && stored.AuthenticatedAt.Before(now.Add(-time.Second)) {
+					t.Errorf("Successor was authenticated at %v, expected the time of the refresh", stored.AuthenticatedAt)
+				}
+			})
+		}
+	}
+}
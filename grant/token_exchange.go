@@ -0,0 +1,159 @@
+package grant
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+// TokenExchangeHandler decides if the client may exchange the subject token, optionally acting
+// through the actor token, for a token for the requested audience. Return an oauth2.AccessDeniedErr
+// to reject the exchange.
+type TokenExchangeHandler func(
+	clientId string,
+	subjectToken *oauth2.AccessToken,
+	actorToken *oauth2.AccessToken,
+	audience []string,
+	scopes []string,
+) error
+
+type tokenExchangeGrant struct {
+	server     oauth2.Server
+	repository oauth2.ContextTokenRepository
+	handler    TokenExchangeHandler
+	config     TokenExchangeGrantConfig
+}
+
+func NewTokenExchangeGrant(
+	server oauth2.Server,
+	repository oauth2.TokenRepository,
+	handler TokenExchangeHandler,
+	config TokenExchangeGrantConfig,
+) oauth2.OauthGrant {
+	return &tokenExchangeGrant{
+		server:     server,
+		repository: oauth2.NewContextTokenRepository(repository),
+		handler:    handler,
+		config:     config,
+	}
+}
+
+func (grant *tokenExchangeGrant) CreateAuthorizationCode(r *http.Request, clientId string) (*oauth2.AuthorizationCode, error) {
+	return nil, oauth2.NewError(oauth2.InvalidRequestErr, "Token exchange grant does not support authorization")
+}
+
+func (grant *tokenExchangeGrant) CreateTokens(r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	return grant.CreateTokensContext(r.Context(), r, clientId)
+}
+
+func (grant *tokenExchangeGrant) CreateTokensContext(ctx context.Context, r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	requestedTokenType := oauth2.TokenTypeIdentifier(r.FormValue("requested_token_type"))
+
+	// Both parameters can be repeated to request a token for multiple targets
+	audience := make([]string, 0)
+	audience = append(audience, r.Form["audience"]...)
+	audience = append(audience, r.Form["resource"]...)
+
+	if requestedTokenType != "" && requestedTokenType != oauth2.TokenTypeIdentifierAccessToken {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidRequestErr, "Only access tokens can be requested")
+	}
+
+	subjectToken, err := grant.getToken(ctx, r.FormValue("subject_token"), r.FormValue("subject_token_type"))
+	if err != nil {
+		return nil, nil, nil, err
+	} else if subjectToken == nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidRequestErr, "Missing subject token")
+	}
+
+	actorToken, err := grant.getToken(ctx, r.FormValue("actor_token"), r.FormValue("actor_token_type"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Default to the scopes of the subject token, requested scopes can only narrow them down
+	scopes := subjectToken.Scopes
+	if providedScopes := r.FormValue("scope"); providedScopes != "" {
+		scopes = strings.Split(providedScopes, " ")
+	}
+
+	if !subjectToken.MatchScopes(scopes) {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidScopeErr, "The requested scope exceeds the scope(s) of the subject token")
+	}
+
+	// Default to the audience of the subject token, an audience restricted token can only be narrowed down
+	if len(audience) == 0 {
+		audience = subjectToken.Audience
+	} else if len(subjectToken.Audience) > 0 && !subjectToken.MatchAudience(audience) {
+		return nil, nil, nil, oauth2.NewError(oauth2.InvalidTargetErr, "The requested audience exceeds the audience of the subject token")
+	}
+
+	if grant.handler == nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, "Token exchange grant not configured correctly")
+	}
+
+	if err = grant.handler(clientId, subjectToken, actorToken, audience, scopes); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// The actor token identifies who acts on behalf of the subject, otherwise keep the current actor
+	actor := subjectToken.Actor
+	if actorToken != nil {
+		actor = string(actorToken.OwnerId)
+		if actor == "" {
+			actor = actorToken.ClientId
+		}
+	}
+
+	// The new token must not outlive the token it was exchanged from
+	duration := grant.config.AccessTokenDuration
+	if remaining := time.Until(subjectToken.ExpiresAt); remaining < duration {
+		duration = remaining
+	}
+
+	accessToken, err := grant.server.CreateAccessTokenContext(ctx, clientId, subjectToken.OwnerId, duration, scopes, oauth2.TokenOptions{
+		FamilyId:        subjectToken.FamilyId,
+		AuthenticatedAt: subjectToken.AuthenticatedAt,
+		Audience:        audience,
+		Actor:           actor,
+	})
+	if err != nil {
+		return nil, nil, nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	return accessToken, nil, oauth2.TokenExchangeMeta{IssuedTokenType: oauth2.TokenTypeIdentifierAccessToken}, nil
+}
+
+// Get and validate a subject or actor token, returns nil if no token was provided
+func (grant *tokenExchangeGrant) getToken(ctx context.Context, providedToken, tokenType string) (*oauth2.AccessToken, error) {
+	if providedToken == "" {
+		if tokenType != "" {
+			return nil, oauth2.NewError(oauth2.InvalidRequestErr, "Token type provided without a token")
+		}
+
+		return nil, nil
+	}
+
+	if oauth2.TokenTypeIdentifier(tokenType) != oauth2.TokenTypeIdentifierAccessToken {
+		return nil, oauth2.NewError(oauth2.InvalidRequestErr, "Only access tokens can be exchanged")
+	}
+
+	token, err := grant.repository.GetAccessTokenContext(ctx, providedToken)
+	if err == oauth2.AccessTokenNotFoundErr {
+		return nil, oauth2.NewError(oauth2.InvalidGrantErr, "Token is invalid")
+	} else if err != nil {
+		return nil, err
+	}
+
+	if token.IsExpired() {
+		return nil, oauth2.NewError(oauth2.InvalidGrantErr, "Token has expired")
+	}
+
+	return token, nil
+}
+
+func (grant *tokenExchangeGrant) AllowPublicClients() bool {
+	return false
+}
@@ -0,0 +1,195 @@
+package grant
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+func TestTokenExchangeGrant(t *testing.T) {
+	const accessTokenType = string(oauth2.TokenTypeIdentifierAccessToken)
+
+	allow := func(clientId string, subjectToken, actorToken *oauth2.AccessToken, audience, scopes []string) error {
+		return nil
+	}
+
+	tests := []struct {
+		name    string
+		values  url.Values
+		handler TokenExchangeHandler
+		err     oauth2.OauthErrorType
+		// Expected properties of the issued token
+		scopes   []string
+		audience []string
+		actor    string
+	}{
+		{
+			name:     "subject token",
+			values:   url.Values{"subject_token": {"subject"}, "subject_token_type": {accessTokenType}},
+			handler:  allow,
+			scopes:   []string{"read", "write"},
+			audience: []string{"https://api.example.com", "https://other.example.com"},
+		},
+		{
+			name: "downscoped",
+			values: url.Values{
+				"subject_token":      {"subject"},
+				"subject_token_type": {accessTokenType},
+				"scope":              {"read"},
+				"audience":           {"https://api.example.com"},
+			},
+			handler:  allow,
+			scopes:   []string{"read"},
+			audience: []string{"https://api.example.com"},
+		},
+		{
+			name: "with actor",
+			values: url.Values{
+				"subject_token":      {"subject"},
+				"subject_token_type": {accessTokenType},
+				"actor_token":        {"actor"},
+				"actor_token_type":   {accessTokenType},
+			},
+			handler:  allow,
+			scopes:   []string{"read", "write"},
+			audience: []string{"https://api.example.com", "https://other.example.com"},
+			actor:    "service",
+		},
+		{
+			name:    "scope exceeds the subject token",
+			values:  url.Values{"subject_token": {"subject"}, "subject_token_type": {accessTokenType}, "scope": {"read admin"}},
+			handler: allow,
+			err:     oauth2.InvalidScopeErr,
+		},
+		{
+			name: "audience exceeds the subject token",
+			values: url.Values{
+				"subject_token":      {"subject"},
+				"subject_token_type": {accessTokenType},
+				"resource":           {"https://attacker.example.com"},
+			},
+			handler: allow,
+			err:     oauth2.InvalidTargetErr,
+		},
+		{name: "missing subject token", values: url.Values{}, handler: allow, err: oauth2.InvalidRequestErr},
+		{
+			name:    "missing subject token type",
+			values:  url.Values{"subject_token": {"subject"}},
+			handler: allow,
+			err:     oauth2.InvalidRequestErr,
+		},
+		{
+			name:    "refresh token as subject token",
+			values:  url.Values{"subject_token": {"subject"}, "subject_token_type": {string(oauth2.TokenTypeIdentifierRefreshToken)}},
+			handler: allow,
+			err:     oauth2.InvalidRequestErr,
+		},
+		{
+			name:    "unknown subject token",
+			values:  url.Values{"subject_token": {"unknown"}, "subject_token_type": {accessTokenType}},
+			handler: allow,
+			err:     oauth2.InvalidGrantErr,
+		},
+		{
+			name:    "expired subject token",
+			values:  url.Values{"subject_token": {"expired"}, "subject_token_type": {accessTokenType}},
+			handler: allow,
+			err:     oauth2.InvalidGrantErr,
+		},
+		{
+			name:    "actor token type without actor token",
+			values:  url.Values{"subject_token": {"subject"}, "subject_token_type": {accessTokenType}, "actor_token_type": {accessTokenType}},
+			handler: allow,
+			err:     oauth2.InvalidRequestErr,
+		},
+		{
+			name:   "rejected by the handler",
+			values: url.Values{"subject_token": {"subject"}, "subject_token_type": {accessTokenType}},
+			handler: func(clientId string, subjectToken, actorToken *oauth2.AccessToken, audience, scopes []string) error {
+				return oauth2.NewError(oauth2.AccessDeniedErr, "Not allowed")
+			},
+			err: oauth2.AccessDeniedErr,
+		},
+		{
+			name:   "no handler",
+			values: url.Values{"subject_token": {"subject"}, "subject_token_type": {accessTokenType}},
+			err:    oauth2.ServerErrorErr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oauthServer, repository := newTestServer()
+			grant := NewTokenExchangeGrant(oauthServer, repository, test.handler, TokenExchangeGrantDefaultConfig)
+
+			tokens := map[string]struct {
+				ownerId  oauth2.OauthTokenOwnerId
+				duration time.Duration
+				audience []string
+			}{
+				"subject": {ownerId: testOwnerId, duration: time.Hour, audience: []string{"https://api.example.com", "https://other.example.com"}},
+				"actor":   {ownerId: "service", duration: time.Hour},
+				"expired": {ownerId: testOwnerId, duration: -time.Hour},
+			}
+
+			// The request carries the generated tokens instead of their names
+			for name, token := range tokens {
+				accessToken, err := oauthServer.CreateAccessTokenContext(
+					context.Background(),
+					"frontend",
+					token.ownerId,
+					token.duration,
+					[]string{"read", "write"},
+					oauth2.TokenOptions{Audience: token.audience},
+				)
+				if err != nil {
+					t.Fatalf("CreateAccessToken failed: %v", err)
+				}
+
+				for _, parameter := range []string{"subject_token", "actor_token"} {
+					if test.values.Get(parameter) == name {
+						test.values.Set(parameter, accessToken.Token)
+					}
+				}
+			}
+
+			accessToken, _, meta, err := grant.CreateTokens(newFormRequest(test.values), testClientId)
+			if errorType(err) != test.err {
+				t.Fatalf("Expected error %q, got %v", test.err, err)
+			}
+
+			if test.err != "" {
+				return
+			}
+
+			if exchangeMeta, ok := meta.(oauth2.TokenExchangeMeta); !ok || exchangeMeta.IssuedTokenType != oauth2.TokenTypeIdentifierAccessToken {
+				t.Errorf("Issued token type is %v", meta)
+			}
+
+			stored, err := repository.GetAccessToken(accessToken.Token)
+			if err != nil {
+				t.Fatalf("GetAccessToken failed: %v", err)
+			}
+
+			if stored.ClientId != testClientId || stored.OwnerId != testOwnerId {
+				t.Errorf("Token was issued to %q on behalf of %q", stored.ClientId, stored.OwnerId)
+			}
+
+			if strings.Join(stored.Scopes, " ") != strings.Join(test.scopes, " ") {
+				t.Errorf("Scopes are %v, expected %v", stored.Scopes, test.scopes)
+			}
+
+			if strings.Join(stored.Audience, " ") != strings.Join(test.audience, " ") {
+				t.Errorf("Audience is %v, expected %v", stored.Audience, test.audience)
+			}
+
+			if stored.Actor != test.actor {
+				t.Errorf("Actor is %q, expected %q", stored.Actor, test.actor)
+			}
+		})
+	}
+}
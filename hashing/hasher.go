@@ -0,0 +1,42 @@
+package hashing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// TokenHasher hashes tokens before they are stored, the result MUST be deterministic
+type TokenHasher interface {
+	HashToken(token string) string
+}
+
+type sha256Hasher struct{}
+
+// NewSha256Hasher hashes tokens with SHA-256, hex encoded
+func NewSha256Hasher() TokenHasher {
+	return &sha256Hasher{}
+}
+
+func (hasher *sha256Hasher) HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(hash[:])
+}
+
+type hmacHasher struct {
+	key []byte
+}
+
+// NewHmacHasher hashes tokens with HMAC-SHA-256, hex encoded. Without the key a stolen
+// database can't even be used to check guessed tokens offline
+func NewHmacHasher(key []byte) TokenHasher {
+	return &hmacHasher{key: key}
+}
+
+func (hasher *hmacHasher) HashToken(token string) string {
+	mac := hmac.New(sha256.New, hasher.key)
+	mac.Write([]byte(token))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
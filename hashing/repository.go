@@ -0,0 +1,314 @@
+package hashing
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+var RepositoryDefaultConfig = RepositoryConfig{
+	AllowPlaintext:   false,
+	UpgradePlaintext: false,
+}
+
+type RepositoryConfig struct {
+	// Fall back to looking up the plain token, needed while old plaintext rows remain
+	AllowPlaintext bool
+
+	// Replace plaintext rows with hashed rows when they are found by a lookup
+	UpgradePlaintext bool
+}
+
+// Stored hashes start with a prefix plaintext tokens never have, so the plaintext fallback can't be
+// used to look up a row by its hash
+const hashPrefix = "hash:"
+
+type tokenRepository struct {
+	repository oauth2.ContextTokenRepository
+	hasher     TokenHasher
+	config     RepositoryConfig
+}
+
+// NewTokenRepository wraps a repository so only hashes of tokens are stored. Tokens returned
+// by lookups carry the presented token, never the hash. The successor tokens of rotated refresh
+// tokens can't be recovered from a hash, a rotated refresh token presented within the
+// rotation grace period is therefore rejected without revoking the family.
+func NewTokenRepository(repository oauth2.TokenRepository, hasher TokenHasher, config RepositoryConfig) oauth2.TokenRepository {
+	if repository == nil {
+		panic("No token repository given to hashing token repository")
+	}
+
+	return &tokenRepository{
+		repository: oauth2.NewContextTokenRepository(repository),
+		hasher:     hasher,
+		config:     config,
+	}
+}
+
+func (repository *tokenRepository) hash(token string) string {
+	return hashPrefix + repository.hasher.HashToken(token)
+}
+
+// Only fall back to plaintext lookups for values that can't be a stored hash
+func (repository *tokenRepository) allowPlaintext(token string) bool {
+	return repository.config.AllowPlaintext && !strings.HasPrefix(token, hashPrefix)
+}
+
+// Copy the token with the token value hashed, the caller keeps the plain token
+func (repository *tokenRepository) hashOauthToken(token *oauth2.OauthToken) *oauth2.OauthToken {
+	hashed := *token
+	hashed.Token = repository.hash(token.Token)
+
+	return &hashed
+}
+
+// Copy the refresh token with the token and its successor hashed, the successor access token
+// can't be looked up through a hash so it is not stored
+func (repository *tokenRepository) hashRefreshToken(token *oauth2.RefreshToken) *oauth2.RefreshToken {
+	hashed := *token
+	hashed.OauthToken = repository.hashOauthToken(token.OauthToken)
+	hashed.RotatedToAccessToken = ""
+
+	if token.RotatedTo != "" {
+		hashed.RotatedTo = repository.hash(token.RotatedTo)
+	}
+
+	return &hashed
+}
+
+func (repository *tokenRepository) CreateAccessToken(token *oauth2.AccessToken) error {
+	return repository.CreateAccessTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) CreateAccessTokenContext(ctx context.Context, token *oauth2.AccessToken) error {
+	hashed := *token
+	hashed.OauthToken = repository.hashOauthToken(token.OauthToken)
+
+	return repository.repository.CreateAccessTokenContext(ctx, &hashed)
+}
+
+func (repository *tokenRepository) CreateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.CreateRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) CreateRefreshTokenContext(ctx context.Context, token *oauth2.RefreshToken) error {
+	return repository.repository.CreateRefreshTokenContext(ctx, repository.hashRefreshToken(token))
+}
+
+func (repository *tokenRepository) CreateAuthorizationCode(code *oauth2.AuthorizationCode) error {
+	return repository.CreateAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *tokenRepository) CreateAuthorizationCodeContext(ctx context.Context, code *oauth2.AuthorizationCode) error {
+	hashed := *code
+	hashed.OauthToken = repository.hashOauthToken(code.OauthToken)
+
+	return repository.repository.CreateAuthorizationCodeContext(ctx, &hashed)
+}
+
+func (repository *tokenRepository) GetAccessToken(token string) (*oauth2.AccessToken, error) {
+	return repository.GetAccessTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) GetAccessTokenContext(ctx context.Context, token string) (*oauth2.AccessToken, error) {
+	accessToken, err := repository.repository.GetAccessTokenContext(ctx, repository.hash(token))
+	if err == oauth2.AccessTokenNotFoundErr && repository.allowPlaintext(token) {
+		if accessToken, err = repository.repository.GetAccessTokenContext(ctx, token); err == nil && accessToken != nil {
+			repository.upgradeAccessToken(ctx, accessToken)
+		}
+	}
+
+	if err != nil || accessToken == nil {
+		return accessToken, err
+	}
+
+	accessToken.Token = token
+
+	return accessToken, nil
+}
+
+func (repository *tokenRepository) GetRefreshToken(token string) (*oauth2.RefreshToken, error) {
+	return repository.GetRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) GetRefreshTokenContext(ctx context.Context, token string) (*oauth2.RefreshToken, error) {
+	refreshToken, err := repository.repository.GetRefreshTokenContext(ctx, repository.hash(token))
+	if err == oauth2.RefreshTokenNotFoundErr && repository.allowPlaintext(token) {
+		if refreshToken, err = repository.repository.GetRefreshTokenContext(ctx, token); err == nil && refreshToken != nil {
+			repository.upgradeRefreshToken(ctx, refreshToken)
+		}
+	}
+
+	if err != nil || refreshToken == nil {
+		return refreshToken, err
+	}
+
+	refreshToken.Token = token
+
+	return refreshToken, nil
+}
+
+func (repository *tokenRepository) GetAuthorizationCode(code string) (*oauth2.AuthorizationCode, error) {
+	return repository.GetAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *tokenRepository) GetAuthorizationCodeContext(ctx context.Context, code string) (*oauth2.AuthorizationCode, error) {
+	authorizationCode, err := repository.repository.GetAuthorizationCodeContext(ctx, repository.hash(code))
+	if err == oauth2.AuthorizationCodeNotFoundErr && repository.allowPlaintext(code) {
+		authorizationCode, err = repository.repository.GetAuthorizationCodeContext(ctx, code)
+	}
+
+	if err != nil || authorizationCode == nil {
+		return authorizationCode, err
+	}
+
+	authorizationCode.Token = code
+
+	return authorizationCode, nil
+}
+
+func (repository *tokenRepository) DeleteAccessToken(token string) error {
+	return repository.DeleteAccessTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) DeleteAccessTokenContext(ctx context.Context, token string) error {
+	if err := repository.repository.DeleteAccessTokenContext(ctx, repository.hash(token)); err != nil {
+		return err
+	}
+
+	if repository.allowPlaintext(token) {
+		return repository.repository.DeleteAccessTokenContext(ctx, token)
+	}
+
+	return nil
+}
+
+func (repository *tokenRepository) DeleteRefreshToken(token string) error {
+	return repository.DeleteRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) DeleteRefreshTokenContext(ctx context.Context, token string) error {
+	if err := repository.repository.DeleteRefreshTokenContext(ctx, repository.hash(token)); err != nil {
+		return err
+	}
+
+	if repository.allowPlaintext(token) {
+		return repository.repository.DeleteRefreshTokenContext(ctx, token)
+	}
+
+	return nil
+}
+
+func (repository *tokenRepository) DeleteAuthorizationCode(code string) error {
+	return repository.DeleteAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *tokenRepository) DeleteAuthorizationCodeContext(ctx context.Context, code string) error {
+	err := repository.repository.DeleteAuthorizationCodeContext(ctx, repository.hash(code))
+	if err == oauth2.AuthorizationCodeNotFoundErr && repository.allowPlaintext(code) {
+		return repository.repository.DeleteAuthorizationCodeContext(ctx, code)
+	}
+
+	return err
+}
+
+func (repository *tokenRepository) DeleteExpiredAccessTokens() error {
+	return repository.DeleteExpiredAccessTokensContext(context.Background())
+}
+
+func (repository *tokenRepository) DeleteExpiredAccessTokensContext(ctx context.Context) error {
+	return repository.repository.DeleteExpiredAccessTokensContext(ctx)
+}
+
+func (repository *tokenRepository) DeleteExpiredRefreshTokens() error {
+	return repository.DeleteExpiredRefreshTokensContext(context.Background())
+}
+
+func (repository *tokenRepository) DeleteExpiredRefreshTokensContext(ctx context.Context) error {
+	return repository.repository.DeleteExpiredRefreshTokensContext(ctx)
+}
+
+func (repository *tokenRepository) DeleteExpiredAuthorizationCodes() error {
+	return repository.DeleteExpiredAuthorizationCodesContext(context.Background())
+}
+
+func (repository *tokenRepository) DeleteExpiredAuthorizationCodesContext(ctx context.Context) error {
+	return repository.repository.DeleteExpiredAuthorizationCodesContext(ctx)
+}
+
+func (repository *tokenRepository) RotateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.RotateRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) RotateRefreshTokenContext(ctx context.Context, token *oauth2.RefreshToken) error {
+	// The lineage is kept through the hash
+	hashed := repository.hashRefreshToken(token)
+
+	err := repository.repository.RotateRefreshTokenContext(ctx, hashed)
+	if err == oauth2.RefreshTokenAlreadyRotatedErr && repository.allowPlaintext(token.Token) {
+		hashed.Token = token.Token
+
+		return repository.repository.RotateRefreshTokenContext(ctx, hashed)
+	}
+
+	return err
+}
+
+func (repository *tokenRepository) UpdateRefreshTokenExpiresAt(token string, expiresAt time.Time) error {
+	return repository.UpdateRefreshTokenExpiresAtContext(context.Background(), token, expiresAt)
+}
+
+func (repository *tokenRepository) UpdateRefreshTokenExpiresAtContext(ctx context.Context, token string, expiresAt time.Time) error {
+	if err := repository.repository.UpdateRefreshTokenExpiresAtContext(ctx, repository.hash(token), expiresAt); err != nil {
+		return err
+	}
+
+	if repository.allowPlaintext(token) {
+		return repository.repository.UpdateRefreshTokenExpiresAtContext(ctx, token, expiresAt)
+	}
+
+	return nil
+}
+
+func (repository *tokenRepository) DeleteTokenFamily(familyId string) error {
+	return repository.DeleteTokenFamilyContext(context.Background(), familyId)
+}
+
+func (repository *tokenRepository) DeleteTokenFamilyContext(ctx context.Context, familyId string) error {
+	return repository.repository.DeleteTokenFamilyContext(ctx, familyId)
+}
+
+func (repository *tokenRepository) DeleteAccessTokensWithoutFamily(clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	return repository.DeleteAccessTokensWithoutFamilyContext(context.Background(), clientId, ownerId)
+}
+
+func (repository *tokenRepository) DeleteAccessTokensWithoutFamilyContext(ctx context.Context, clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	return repository.repository.DeleteAccessTokensWithoutFamilyContext(ctx, clientId, ownerId)
+}
+
+// Replace a plaintext row with a hashed row, best effort since the lookup already succeeded
+func (repository *tokenRepository) upgradeAccessToken(ctx context.Context, accessToken *oauth2.AccessToken) {
+	if !repository.config.UpgradePlaintext {
+		return
+	}
+
+	if err := repository.CreateAccessTokenContext(ctx, accessToken); err != nil {
+		return
+	}
+
+	repository.repository.DeleteAccessTokenContext(ctx, accessToken.Token)
+}
+
+func (repository *tokenRepository) upgradeRefreshToken(ctx context.Context, refreshToken *oauth2.RefreshToken) {
+	if !repository.config.UpgradePlaintext {
+		return
+	}
+
+	if err := repository.CreateRefreshTokenContext(ctx, refreshToken); err != nil {
+		return
+	}
+
+	repository.repository.DeleteRefreshTokenContext(ctx, refreshToken.Token)
+}
@@ -0,0 +1,258 @@
+package hashing
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/token/memory"
+)
+
+func newAccessToken(token string) *oauth2.AccessToken {
+	accessToken := oauth2.NewAccessToken("client", "owner", time.Hour, []string{"read"})
+	accessToken.Token = token
+
+	return accessToken
+}
+
+func newRefreshToken(token string) *oauth2.RefreshToken {
+	refreshToken := oauth2.NewRefreshToken("client", "owner", time.Hour, []string{"read"})
+	refreshToken.Token = token
+
+	return refreshToken
+}
+
+func TestGetAccessToken(t *testing.T) {
+	hasher := NewSha256Hasher()
+
+	tests := []struct {
+		name      string
+		config    RepositoryConfig
+		plaintext bool
+		lookup    func(stored string) string
+		found     bool
+	}{
+		{
+			name:   "hashed token",
+			config: RepositoryDefaultConfig,
+			lookup: func(stored string) string { return stored },
+			found:  true,
+		},
+		{
+			name:      "plaintext token without fallback",
+			config:    RepositoryDefaultConfig,
+			plaintext: true,
+			lookup:    func(stored string) string { return stored },
+			found:     false,
+		},
+		{
+			name:      "plaintext token with fallback",
+			config:    RepositoryConfig{AllowPlaintext: true},
+			plaintext: true,
+			lookup:    func(stored string) string { return stored },
+			found:     true,
+		},
+		{
+			name:   "stored hash with fallback",
+			config: RepositoryConfig{AllowPlaintext: true},
+			lookup: func(stored string) string { return hashPrefix + hasher.HashToken(stored) },
+			found:  false,
+		},
+		{
+			name:   "unknown token with fallback",
+			config: RepositoryConfig{AllowPlaintext: true},
+			lookup: func(stored string) string { return "unknown" },
+			found:  false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			backend := memory.NewTokenRepository(memory.RepositoryDefaultConfig)
+			repository := NewTokenRepository(backend, hasher, test.config)
+
+			token := oauth2.GenerateRandomString(32)
+			if test.plaintext {
+				mustNotFail(t, backend.CreateAccessToken(newAccessToken(token)))
+			} else {
+				mustNotFail(t, repository.CreateAccessToken(newAccessToken(token)))
+			}
+
+			provided := test.lookup(token)
+
+			accessToken, err := repository.GetAccessToken(provided)
+			if !test.found {
+				if err != oauth2.AccessTokenNotFoundErr {
+					t.Fatalf("Expected %v, got %v", oauth2.AccessTokenNotFoundErr, err)
+				}
+
+				return
+			}
+
+			mustNotFail(t, err)
+
+			if accessToken.Token != provided {
+				t.Errorf("Token is %q, expected the presented token %q", accessToken.Token, provided)
+			}
+		})
+	}
+}
+
+func TestTokensAreStoredHashed(t *testing.T) {
+	hasher := NewHmacHasher([]byte("key"))
+	backend := memory.NewTokenRepository(memory.RepositoryDefaultConfig)
+	repository := NewTokenRepository(backend, hasher, RepositoryDefaultConfig)
+
+	token := oauth2.GenerateRandomString(32)
+	mustNotFail(t, repository.CreateAccessToken(newAccessToken(token)))
+
+	if _, err := backend.GetAccessToken(token); err != oauth2.AccessTokenNotFoundErr {
+		t.Errorf("Plaintext token was stored")
+	}
+
+	if _, err := backend.GetAccessToken(hashPrefix + hasher.HashToken(token)); err != nil {
+		t.Errorf("Hashed token was not stored: %v", err)
+	}
+}
+
+func TestUpgradePlaintext(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   RepositoryConfig
+		upgraded bool
+	}{
+		{
+			name:     "upgrade",
+			config:   RepositoryConfig{AllowPlaintext: true, UpgradePlaintext: true},
+			upgraded: true,
+		},
+		{
+			name:     "keep plaintext",
+			config:   RepositoryConfig{AllowPlaintext: true},
+			upgraded: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hasher := NewSha256Hasher()
+			backend := memory.NewTokenRepository(memory.RepositoryDefaultConfig)
+			repository := NewTokenRepository(backend, hasher, test.config)
+
+			successor := oauth2.GenerateRandomString(32)
+
+			refreshToken := newRefreshToken(oauth2.GenerateRandomString(32))
+			refreshToken.RotatedAt = time.Now()
+			refreshToken.RotatedTo = successor
+			refreshToken.RotatedToAccessToken = oauth2.GenerateRandomString(32)
+			mustNotFail(t, backend.CreateRefreshToken(refreshToken))
+
+			_, err := repository.GetRefreshToken(refreshToken.Token)
+			mustNotFail(t, err)
+
+			_, err = backend.GetRefreshToken(refreshToken.Token)
+			if test.upgraded != (err == oauth2.RefreshTokenNotFoundErr) {
+				t.Fatalf("Plaintext row upgraded is %v, expected %v", err == oauth2.RefreshTokenNotFoundErr, test.upgraded)
+			}
+
+			if !test.upgraded {
+				return
+			}
+
+			stored, err := backend.GetRefreshToken(hashPrefix + hasher.HashToken(refreshToken.Token))
+			mustNotFail(t, err)
+
+			if stored.RotatedTo != hashPrefix+hasher.HashToken(successor) {
+				t.Errorf("RotatedTo is %q, expected the hash of the successor", stored.RotatedTo)
+			}
+
+			if stored.RotatedToAccessToken != "" {
+				t.Errorf("RotatedToAccessToken is %q, expected it to be cleared", stored.RotatedToAccessToken)
+			}
+		})
+	}
+}
+
+func TestDeleteAuthorizationCode(t *testing.T) {
+	tests := []struct {
+		name      string
+		plaintext bool
+		err       error
+	}{
+		{name: "hashed code"},
+		{name: "plaintext code", plaintext: true},
+		{name: "stored hash", err: oauth2.AuthorizationCodeNotFoundErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hasher := NewSha256Hasher()
+			backend := memory.NewTokenRepository(memory.RepositoryDefaultConfig)
+			repository := NewTokenRepository(backend, hasher, RepositoryConfig{AllowPlaintext: true})
+
+			code := oauth2.NewAuthorizationCode("client", "owner", time.Minute, nil, "https://example.com/callback")
+			code.Token = oauth2.GenerateRandomString(32)
+
+			if test.plaintext {
+				mustNotFail(t, backend.CreateAuthorizationCode(code))
+			} else {
+				mustNotFail(t, repository.CreateAuthorizationCode(code))
+			}
+
+			provided := code.Token
+			if test.err != nil {
+				provided = hashPrefix + hasher.HashToken(code.Token)
+			}
+
+			if err := repository.DeleteAuthorizationCode(provided); err != test.err {
+				t.Fatalf("Expected %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+type contextKey struct{}
+
+// Records the context of lookups, everything else is passed to the memory repository
+type contextRecorder struct {
+	*memory.TokenRepository
+	oauth2.ContextTokenRepository
+
+	ctx context.Context
+}
+
+func newContextRecorder() *contextRecorder {
+	repository := memory.NewTokenRepository(memory.RepositoryDefaultConfig)
+
+	return &contextRecorder{
+		TokenRepository:        repository,
+		ContextTokenRepository: oauth2.NewContextTokenRepository(repository),
+	}
+}
+
+func (recorder *contextRecorder) GetAccessTokenContext(ctx context.Context, token string) (*oauth2.AccessToken, error) {
+	recorder.ctx = ctx
+
+	return recorder.ContextTokenRepository.GetAccessTokenContext(ctx, token)
+}
+
+func TestContextIsForwarded(t *testing.T) {
+	backend := newContextRecorder()
+	repository := oauth2.NewContextTokenRepository(NewTokenRepository(backend, NewSha256Hasher(), RepositoryDefaultConfig))
+
+	ctx := context.WithValue(context.Background(), contextKey{}, "request")
+	repository.GetAccessTokenContext(ctx, "token")
+
+	if backend.ctx == nil || backend.ctx.Value(contextKey{}) != "request" {
+		t.Errorf("The context was not passed to the wrapped repository")
+	}
+}
+
+func mustNotFail(t *testing.T, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package jwt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ExpiredErr     = errors.New("Token has expired")
+	NotYetValidErr = errors.New("Token is not valid yet")
+	MissingExpErr  = errors.New("Token has no expiration time")
+)
+
+// Registered claims from RFC 7519
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Id        string   `json:"jti,omitempty"`
+}
+
+// Validate checks exp and nbf, leeway allows for some clock skew between the parties
+func (claims *Claims) Validate(now time.Time, leeway time.Duration) error {
+	if claims.ExpiresAt == 0 {
+		return MissingExpErr
+	}
+
+	if now.Add(-leeway).Unix() >= claims.ExpiresAt {
+		return ExpiredErr
+	}
+
+	if claims.NotBefore != 0 && now.Add(leeway).Unix() < claims.NotBefore {
+		return NotYetValidErr
+	}
+
+	return nil
+}
+
+func (claims *Claims) ExpiresAtTime() time.Time {
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
+// Audience can be either a single string or an array of strings
+type Audience []string
+
+func (audience Audience) Contains(value string) bool {
+	for _, aud := range audience {
+		if aud == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (audience Audience) MarshalJSON() ([]byte, error) {
+	if len(audience) == 1 {
+		return json.Marshal(audience[0])
+	}
+
+	return json.Marshal([]string(audience))
+}
+
+func (audience *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*audience = Audience{single}
+		return nil
+	}
+
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+
+	*audience = Audience(multiple)
+
+	return nil
+}
+
+// The type of JWT access tokens from RFC 9068
+const AccessTokenType = "at+jwt"
+
+// AccessTokenClaims of a JWT access token as profiled by RFC 9068
+type AccessTokenClaims struct {
+	Claims
+
+	ClientId string       `json:"client_id"`
+	Scope    string       `json:"scope,omitempty"`
+	AuthTime int64        `json:"auth_time,omitempty"`
+	Actor    *ActorClaims `json:"act,omitempty"`
+}
+
+// ActorClaims identifies the party acting on behalf of the subject, RFC 8693 section 4.1
+type ActorClaims struct {
+	Subject string `json:"sub"`
+}
@@ -0,0 +1,141 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+)
+
+type jsonWebKey struct {
+	KeyType   string    `json:"kty"`
+	KeyId     string    `json:"kid,omitempty"`
+	Algorithm Algorithm `json:"alg,omitempty"`
+	Use       string    `json:"use,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// MarshalJSON encodes the key set as a JWKS, symmetric keys are never published
+func (keys KeySet) MarshalJSON() ([]byte, error) {
+	set := jsonWebKeySet{Keys: make([]jsonWebKey, 0, len(keys))}
+
+	for _, key := range keys {
+		jwk := jsonWebKey{KeyId: key.Id, Algorithm: key.Algorithm, Use: "sig"}
+
+		switch publicKey := key.Key.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encodeBigInt(publicKey.N)
+			jwk.E = encodeBigInt(big.NewInt(int64(publicKey.E)))
+
+		case *ecdsa.PublicKey:
+			size := (publicKey.Curve.Params().BitSize + 7) / 8
+			jwk.KeyType = "EC"
+			jwk.Curve = publicKey.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size)))
+
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return json.Marshal(set)
+}
+
+// UnmarshalJSON decodes a JWKS, unsupported keys are skipped
+func (keys *KeySet) UnmarshalJSON(data []byte) error {
+	set := jsonWebKeySet{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return err
+	}
+
+	*keys = make(KeySet, 0, len(set.Keys))
+
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key := Key{Id: jwk.KeyId, Algorithm: jwk.Algorithm}
+
+		switch {
+		case jwk.KeyType == "RSA":
+			n, errN := decodeBigInt(jwk.N)
+			e, errE := decodeBigInt(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+
+			key.Key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+			if key.Algorithm == "" {
+				key.Algorithm = RS256
+			}
+
+		case jwk.KeyType == "EC" && jwk.Curve == "P-256":
+			x, errX := decodeBigInt(jwk.X)
+			y, errY := decodeBigInt(jwk.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+
+			key.Key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+			if key.Algorithm == "" {
+				key.Algorithm = ES256
+			}
+
+		case jwk.KeyType == "OKP" && jwk.Curve == "Ed25519":
+			x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				continue
+			}
+
+			key.Key = ed25519.PublicKey(x)
+			if key.Algorithm == "" {
+				key.Algorithm = EdDSA
+			}
+
+		default:
+			continue
+		}
+
+		*keys = append(*keys, key)
+	}
+
+	return nil
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return new(big.Int).SetBytes(bytes), nil
+}
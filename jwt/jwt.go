@@ -0,0 +1,101 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type Algorithm string
+
+const (
+	RS256 Algorithm = "RS256"
+	ES256 Algorithm = "ES256"
+	EdDSA Algorithm = "EdDSA"
+	HS256 Algorithm = "HS256"
+)
+
+var (
+	MalformedErr        = errors.New("Token is malformed")
+	InvalidSignatureErr = errors.New("Token signature is invalid")
+	KeyNotFoundErr      = errors.New("No key found to verify the token")
+	UnsupportedCurveErr = errors.New("ES256 requires a P-256 key")
+)
+
+type Header struct {
+	Algorithm Algorithm `json:"alg"`
+	Type      string    `json:"typ,omitempty"`
+	KeyId     string    `json:"kid,omitempty"`
+}
+
+// Token is a parsed but not yet verified JWS compact serialization
+type Token struct {
+	Header Header
+
+	payload      []byte
+	signingInput string
+	signature    []byte
+}
+
+// Parse decodes the token, the signature MUST be checked with Verify before trusting any claims
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, MalformedErr
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, MalformedErr
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, MalformedErr
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, MalformedErr
+	}
+
+	token := &Token{
+		payload:      payload,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}
+
+	if err = json.Unmarshal(header, &token.Header); err != nil {
+		return nil, MalformedErr
+	}
+
+	return token, nil
+}
+
+// Claims decodes the payload into v, which usually embeds Claims
+func (token *Token) Claims(v interface{}) error {
+	if err := json.Unmarshal(token.payload, v); err != nil {
+		return MalformedErr
+	}
+
+	return nil
+}
+
+// Verify checks the signature against the key set, the key MUST match both the key id
+// and the algorithm of the header, this prevents algorithm confusion attacks
+func (token *Token) Verify(keys KeySet) error {
+	candidates := keys.Find(token.Header.KeyId, token.Header.Algorithm)
+	if len(candidates) == 0 {
+		return KeyNotFoundErr
+	}
+
+	for _, key := range candidates {
+		if err := key.verify([]byte(token.signingInput), token.signature); err == nil {
+			return nil
+		}
+	}
+
+	return InvalidSignatureErr
+}
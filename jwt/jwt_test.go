@@ -0,0 +1,230 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testKey struct {
+	signer Signer
+	key    Key
+}
+
+func newTestKeys(t *testing.T) map[Algorithm]testKey {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	es256Signer, err := NewES256Signer("es256", ecdsaKey)
+	if err != nil {
+		t.Fatalf("NewES256Signer failed: %v", err)
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	secret := []byte("a secret of at least thirty-two bytes")
+
+	return map[Algorithm]testKey{
+		RS256: {NewRS256Signer("rs256", rsaKey), Key{Id: "rs256", Algorithm: RS256, Key: &rsaKey.PublicKey}},
+		ES256: {es256Signer, Key{Id: "es256", Algorithm: ES256, Key: &ecdsaKey.PublicKey}},
+		EdDSA: {NewEdDSASigner("eddsa", privateKey), Key{Id: "eddsa", Algorithm: EdDSA, Key: publicKey}},
+		HS256: {NewHS256Signer("hs256", secret), Key{Id: "hs256", Algorithm: HS256, Key: secret}},
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for algorithm, key := range newTestKeys(t) {
+		t.Run(string(algorithm), func(t *testing.T) {
+			raw, err := Sign(key.signer, AccessTokenType, Claims{Subject: "owner", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+			if err != nil {
+				t.Fatalf("Sign failed: %v", err)
+			}
+
+			token, err := Parse(raw)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			if token.Header.Algorithm != algorithm || token.Header.KeyId != key.key.Id || token.Header.Type != AccessTokenType {
+				t.Errorf("Header is %+v", token.Header)
+			}
+
+			if err = token.Verify(KeySet{key.key}); err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+
+			claims := Claims{}
+			if err = token.Claims(&claims); err != nil {
+				t.Fatalf("Claims failed: %v", err)
+			}
+
+			if claims.Subject != "owner" {
+				t.Errorf("Subject is %q, expected %q", claims.Subject, "owner")
+			}
+		})
+	}
+}
+
+func TestVerifyRejection(t *testing.T) {
+	keys := newTestKeys(t)
+
+	encode := func(value string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+
+	sign := func(algorithm Algorithm) string {
+		raw, err := Sign(keys[algorithm].signer, AccessTokenType, Claims{Subject: "owner"})
+		if err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+
+		return raw
+	}
+
+	// A token whose signature belongs to another payload
+	tampered := strings.Split(sign(ES256), ".")
+	tampered[1] = encode(`{"sub":"admin"}`)
+
+	// The public RSA key used as HMAC secret, the classic algorithm confusion attack
+	publicKey, err := x509.MarshalPKIXPublicKey(keys[RS256].key.Key)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey failed: %v", err)
+	}
+
+	confused, err := Sign(NewHS256Signer("rs256", publicKey), AccessTokenType, Claims{Subject: "admin"})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	// An ES256 key id pointing to an RSA key
+	mismatched := keys[ES256].key
+	mismatched.Key = keys[RS256].key.Key
+
+	otherKey := newTestKeys(t)[ES256].key
+
+	tests := []struct {
+		name string
+		raw  string
+		keys KeySet
+		err  error
+	}{
+		{
+			name: "none algorithm",
+			raw:  encode(`{"alg":"none"}`) + "." + encode(`{"sub":"owner"}`) + ".",
+			keys: KeySet{keys[HS256].key},
+			err:  KeyNotFoundErr,
+		},
+		{name: "algorithm confusion", raw: confused, keys: KeySet{keys[RS256].key}, err: KeyNotFoundErr},
+		{name: "key of another type", raw: sign(ES256), keys: KeySet{mismatched}, err: InvalidSignatureErr},
+		{name: "unknown key id", raw: sign(ES256), keys: KeySet{keys[RS256].key, keys[EdDSA].key}, err: KeyNotFoundErr},
+		{name: "another key with the same id", raw: sign(ES256), keys: KeySet{otherKey}, err: InvalidSignatureErr},
+		{name: "tampered payload", raw: strings.Join(tampered, "."), keys: KeySet{keys[ES256].key}, err: InvalidSignatureErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := Parse(test.raw)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			if err = token.Verify(test.keys); err != test.err {
+				t.Errorf("Expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []string{
+		"",
+		"header.payload",
+		"a.b.c.d",
+		"not base64!.e30.",
+		"e30.not base64!.",
+		"bm90IGpzb24.e30.",
+	}
+
+	for _, raw := range tests {
+		if _, err := Parse(raw); err != MalformedErr {
+			t.Errorf("Parse of %q returned %v, expected %v", raw, err, MalformedErr)
+		}
+	}
+}
+
+func TestClaimsValidate(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		claims Claims
+		leeway time.Duration
+		err    error
+	}{
+		{name: "valid", claims: Claims{ExpiresAt: now.Add(time.Minute).Unix()}},
+		{name: "no expiration", claims: Claims{}, err: MissingExpErr},
+		{name: "expired", claims: Claims{ExpiresAt: now.Add(-time.Minute).Unix()}, err: ExpiredErr},
+		{name: "expired within the leeway", claims: Claims{ExpiresAt: now.Add(-time.Minute).Unix()}, leeway: 2 * time.Minute},
+		{
+			name:   "not yet valid",
+			claims: Claims{ExpiresAt: now.Add(time.Hour).Unix(), NotBefore: now.Add(time.Minute).Unix()},
+			err:    NotYetValidErr,
+		},
+		{
+			name:   "not yet valid within the leeway",
+			claims: Claims{ExpiresAt: now.Add(time.Hour).Unix(), NotBefore: now.Add(time.Minute).Unix()},
+			leeway: 2 * time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.claims.Validate(now, test.leeway); err != test.err {
+				t.Errorf("Expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestAudience(t *testing.T) {
+	tests := []struct {
+		json     string
+		audience Audience
+	}{
+		{json: `"api"`, audience: Audience{"api"}},
+		{json: `["api","other"]`, audience: Audience{"api", "other"}},
+	}
+
+	for _, test := range tests {
+		audience := Audience{}
+		if err := audience.UnmarshalJSON([]byte(test.json)); err != nil {
+			t.Fatalf("UnmarshalJSON of %s failed: %v", test.json, err)
+		}
+
+		if !audience.Contains("api") || len(audience) != len(test.audience) {
+			t.Errorf("Audience of %s is %v, expected %v", test.json, audience, test.audience)
+		}
+
+		encoded, err := audience.MarshalJSON()
+		if err != nil || string(encoded) != test.json {
+			t.Errorf("MarshalJSON returned %s, expected %s", encoded, test.json)
+		}
+	}
+}
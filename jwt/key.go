@@ -0,0 +1,113 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+// Key used to verify tokens, Key is one of *rsa.PublicKey, *ecdsa.PublicKey,
+// ed25519.PublicKey or a []byte secret for HS256
+type Key struct {
+	Id        string
+	Algorithm Algorithm
+	Key       interface{}
+}
+
+type KeySet []Key
+
+// Find all keys for the algorithm, if a key id is provided only the key with that id matches
+func (keys KeySet) Find(keyId string, algorithm Algorithm) []Key {
+	found := make([]Key, 0, 1)
+
+	for _, key := range keys {
+		if key.Algorithm != algorithm {
+			continue
+		}
+
+		if keyId != "" && key.Id != keyId {
+			continue
+		}
+
+		found = append(found, key)
+	}
+
+	return found
+}
+
+func (key Key) verify(signingInput, signature []byte) error {
+	hash := sha256.Sum256(signingInput)
+
+	switch key.Algorithm {
+	case RS256:
+		publicKey, ok := key.Key.(*rsa.PublicKey)
+		if !ok {
+			return InvalidSignatureErr
+		}
+
+		if err := rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature); err != nil {
+			return InvalidSignatureErr
+		}
+
+		return nil
+
+	case ES256:
+		publicKey, ok := key.Key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return InvalidSignatureErr
+		}
+
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+
+		if !ecdsa.Verify(publicKey, hash[:], r, s) {
+			return InvalidSignatureErr
+		}
+
+		return nil
+
+	case EdDSA:
+		publicKey, ok := key.Key.(ed25519.PublicKey)
+		if !ok || !ed25519.Verify(publicKey, signingInput, signature) {
+			return InvalidSignatureErr
+		}
+
+		return nil
+
+	case HS256:
+		secret, ok := key.Key.([]byte)
+		if !ok {
+			return InvalidSignatureErr
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signingInput)
+
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return InvalidSignatureErr
+		}
+
+		return nil
+	}
+
+	return InvalidSignatureErr
+}
+
+// KeyProvider provides the keys used to verify tokens, a KeySet provides itself
+type KeyProvider interface {
+	Keys() (KeySet, error)
+}
+
+func (keys KeySet) Keys() (KeySet, error) {
+	return keys, nil
+}
+
+// KeyRefresher is implemented by key providers that can fetch new keys on demand, e.g. when a
+// token is signed by a key that has been published after the keys were cached
+type KeyRefresher interface {
+	RefreshKeys() (KeySet, error)
+}
@@ -0,0 +1,174 @@
+package jwt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Timeout of the client used when no client is given
+	remoteKeySetTimeout = 10 * time.Second
+
+	// Forced refreshes, e.g. for an unknown key id, are done at most once per interval
+	minimumRefreshInterval = 10 * time.Second
+
+	// Failed fetches are retried after a backoff doubling from the minimum up to the maximum
+	minimumErrorBackoff = time.Second
+	maximumErrorBackoff = 5 * time.Minute
+)
+
+type remoteKeySet struct {
+	uri             string
+	client          *http.Client
+	refreshInterval time.Duration
+
+	mutex       sync.Mutex
+	keys        KeySet
+	err         error
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	retryAt     time.Time
+	failures    uint
+	// Closed when the running fetch is done, nil if no fetch is running
+	fetching chan struct{}
+}
+
+// NewRemoteKeySet fetches the keys from a JWKS uri and caches them for the refresh interval, a
+// client with a 10 second timeout is used if no client is given. The keys are refreshed early when
+// a token is signed by an unknown key.
+func NewRemoteKeySet(uri string, client *http.Client, refreshInterval time.Duration) KeyProvider {
+	if client == nil {
+		client = &http.Client{Timeout: remoteKeySetTimeout}
+	}
+
+	return &remoteKeySet{
+		uri:             uri,
+		client:          client,
+		refreshInterval: refreshInterval,
+	}
+}
+
+func (remote *remoteKeySet) Keys() (KeySet, error) {
+	return remote.get(false)
+}
+
+// RefreshKeys fetches the keys unless they were fetched very recently
+func (remote *remoteKeySet) RefreshKeys() (KeySet, error) {
+	return remote.get(true)
+}
+
+func (remote *remoteKeySet) get(force bool) (KeySet, error) {
+	remote.mutex.Lock()
+
+	if !remote.shouldFetch(force, time.Now()) {
+		defer remote.mutex.Unlock()
+
+		return remote.result()
+	}
+
+	// Only one request fetches the keys, the others use the current keys or wait for the result
+	if done := remote.fetching; done != nil {
+		if remote.keys != nil && !force {
+			defer remote.mutex.Unlock()
+
+			return remote.keys, nil
+		}
+
+		remote.mutex.Unlock()
+		<-done
+
+		remote.mutex.Lock()
+		defer remote.mutex.Unlock()
+
+		return remote.result()
+	}
+
+	done := make(chan struct{})
+	remote.fetching = done
+	remote.mutex.Unlock()
+
+	keys, err := remote.fetch()
+
+	remote.mutex.Lock()
+	defer remote.mutex.Unlock()
+
+	now := time.Now()
+	remote.attemptedAt = now
+	remote.err = err
+
+	if err != nil {
+		remote.failures++
+		remote.retryAt = now.Add(errorBackoff(remote.failures))
+	} else {
+		remote.keys = keys
+		remote.fetchedAt = now
+		remote.failures = 0
+		remote.retryAt = time.Time{}
+	}
+
+	remote.fetching = nil
+	close(done)
+
+	return remote.result()
+}
+
+// Must be called with the mutex held
+func (remote *remoteKeySet) shouldFetch(force bool, now time.Time) bool {
+	if now.Before(remote.retryAt) {
+		return false
+	}
+
+	if force {
+		return now.Sub(remote.attemptedAt) >= minimumRefreshInterval
+	}
+
+	return remote.keys == nil || now.Sub(remote.fetchedAt) >= remote.refreshInterval
+}
+
+// Keep using the previous keys if the issuer is temporarily unavailable, must be called with the mutex held
+func (remote *remoteKeySet) result() (KeySet, error) {
+	if remote.keys != nil {
+		return remote.keys, nil
+	}
+
+	if remote.err != nil {
+		return nil, remote.err
+	}
+
+	return nil, KeyNotFoundErr
+}
+
+func (remote *remoteKeySet) fetch() (KeySet, error) {
+	res, err := remote.client.Get(remote.uri)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fetching keys failed with status %d", res.StatusCode)
+	}
+
+	keys := KeySet{}
+	if err = json.NewDecoder(res.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func errorBackoff(failures uint) time.Duration {
+	if failures > 16 {
+		return maximumErrorBackoff
+	}
+
+	backoff := minimumErrorBackoff << (failures - 1)
+	if backoff > maximumErrorBackoff {
+		return maximumErrorBackoff
+	}
+
+	return backoff
+}
@@ -0,0 +1,165 @@
+package jwt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serves the key set, failing while fail is set, and counts the requests
+type jwksServer struct {
+	mutex    sync.Mutex
+	keys     KeySet
+	fail     bool
+	requests int
+}
+
+func (server *jwksServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.requests++
+
+	if server.fail {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	json.NewEncoder(w).Encode(server.keys)
+}
+
+func (server *jwksServer) set(keys KeySet, fail bool) int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	server.keys = keys
+	server.fail = fail
+
+	return server.requests
+}
+
+func newTestRemoteKeySet(t *testing.T, keys KeySet, refreshInterval time.Duration) (*jwksServer, KeyProvider) {
+	jwks := &jwksServer{keys: keys}
+
+	server := httptest.NewServer(jwks)
+	t.Cleanup(server.Close)
+
+	return jwks, NewRemoteKeySet(server.URL, nil, refreshInterval)
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	keys := newTestKeys(t)
+	published := KeySet{keys[RS256].key, keys[ES256].key, keys[EdDSA].key}
+
+	jwks, remote := newTestRemoteKeySet(t, published, time.Hour)
+
+	for algorithm := range keys {
+		raw, err := Sign(keys[algorithm].signer, AccessTokenType, Claims{Subject: "owner"})
+		if err != nil {
+			t.Fatalf("Sign failed: %v", err)
+		}
+
+		token, err := Parse(raw)
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+
+		fetched, err := remote.Keys()
+		if err != nil {
+			t.Fatalf("Keys failed: %v", err)
+		}
+
+		// The key is selected by the key id of the token, symmetric keys are never published
+		err = token.Verify(fetched)
+		if algorithm == HS256 && err != KeyNotFoundErr {
+			t.Errorf("Verify of the %s token returned %v, expected %v", algorithm, err, KeyNotFoundErr)
+		} else if algorithm != HS256 && err != nil {
+			t.Errorf("Verify of the %s token failed: %v", algorithm, err)
+		}
+	}
+
+	// The keys are cached for the refresh interval
+	if requests := jwks.set(published, false); requests != 1 {
+		t.Errorf("Keys were fetched %d times, expected 1", requests)
+	}
+}
+
+func TestRemoteKeySetRefresh(t *testing.T) {
+	keys := newTestKeys(t)
+	jwks, remote := newTestRemoteKeySet(t, KeySet{keys[RS256].key}, time.Hour)
+
+	if _, err := remote.Keys(); err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+
+	jwks.set(KeySet{keys[RS256].key, keys[ES256].key}, false)
+
+	// Forced refreshes right after a fetch are ignored, e.g. for tokens with made up key ids
+	refreshed, err := remote.(KeyRefresher).RefreshKeys()
+	if err != nil {
+		t.Fatalf("RefreshKeys failed: %v", err)
+	}
+
+	if len(refreshed) != 1 {
+		t.Errorf("Got %d keys, expected the cached key", len(refreshed))
+	}
+
+	if requests := jwks.set(nil, true); requests != 1 {
+		t.Errorf("Keys were fetched %d times, expected 1", requests)
+	}
+}
+
+func TestRemoteKeySetBackoff(t *testing.T) {
+	keys := newTestKeys(t)
+
+	// Without a refresh interval every lookup fetches the keys, unless a fetch failed recently
+	jwks, remote := newTestRemoteKeySet(t, KeySet{keys[RS256].key}, 0)
+
+	if _, err := remote.Keys(); err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+
+	jwks.set(nil, true)
+
+	for i := 0; i < 3; i++ {
+		// The previous keys are used while the issuer is unavailable
+		cached, err := remote.Keys()
+		if err != nil || len(cached) != 1 {
+			t.Fatalf("Keys returned %v %v, expected the previous keys", cached, err)
+		}
+	}
+
+	if requests := jwks.set(nil, true); requests != 2 {
+		t.Errorf("Keys were fetched %d times, expected no fetches during the backoff", requests)
+	}
+
+	// Without previous keys the error is returned
+	unavailableJwks, unavailable := newTestRemoteKeySet(t, nil, time.Hour)
+	unavailableJwks.set(nil, true)
+
+	if _, err := unavailable.Keys(); err == nil {
+		t.Errorf("Keys of an unavailable issuer returned no error")
+	}
+}
+
+func TestErrorBackoff(t *testing.T) {
+	tests := []struct {
+		failures uint
+		backoff  time.Duration
+	}{
+		{failures: 1, backoff: minimumErrorBackoff},
+		{failures: 2, backoff: 2 * minimumErrorBackoff},
+		{failures: 5, backoff: 16 * minimumErrorBackoff},
+		{failures: 10, backoff: maximumErrorBackoff},
+		{failures: 100, backoff: maximumErrorBackoff},
+	}
+
+	for _, test := range tests {
+		if backoff := errorBackoff(test.failures); backoff != test.backoff {
+			t.Errorf("Backoff after %d failures is %v, expected %v", test.failures, backoff, test.backoff)
+		}
+	}
+}
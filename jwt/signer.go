@@ -0,0 +1,131 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+)
+
+// Signer signs tokens, implement it to sign with keys kept in a KMS or HSM
+type Signer interface {
+	Algorithm() Algorithm
+	KeyId() string
+	Sign(signingInput []byte) ([]byte, error)
+}
+
+// RotatingSigner is a signer whose key can change, such as a key manager. Current returns
+// a snapshot so the key id in the header always matches the key used for the signature
+type RotatingSigner interface {
+	Signer
+	Current() (Signer, error)
+}
+
+// Sign creates a JWS compact serialization of the claims
+func Sign(signer Signer, tokenType string, claims interface{}) (string, error) {
+	if rotating, ok := signer.(RotatingSigner); ok {
+		current, err := rotating.Current()
+		if err != nil {
+			return "", err
+		}
+
+		signer = current
+	}
+
+	header, err := json.Marshal(Header{
+		Algorithm: signer.Algorithm(),
+		Type:      tokenType,
+		KeyId:     signer.KeyId(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	signature, err := signer.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+type keySigner struct {
+	algorithm Algorithm
+	keyId     string
+	key       interface{}
+}
+
+func NewRS256Signer(keyId string, key *rsa.PrivateKey) Signer {
+	return &keySigner{algorithm: RS256, keyId: keyId, key: key}
+}
+
+// NewES256Signer returns UnsupportedCurveErr for keys on any curve other than P-256
+func NewES256Signer(keyId string, key *ecdsa.PrivateKey) (Signer, error) {
+	if key.Curve != elliptic.P256() {
+		return nil, UnsupportedCurveErr
+	}
+
+	return &keySigner{algorithm: ES256, keyId: keyId, key: key}, nil
+}
+
+func NewEdDSASigner(keyId string, key ed25519.PrivateKey) Signer {
+	return &keySigner{algorithm: EdDSA, keyId: keyId, key: key}
+}
+
+func NewHS256Signer(keyId string, secret []byte) Signer {
+	return &keySigner{algorithm: HS256, keyId: keyId, key: secret}
+}
+
+func (signer *keySigner) Algorithm() Algorithm {
+	return signer.algorithm
+}
+
+func (signer *keySigner) KeyId() string {
+	return signer.keyId
+}
+
+func (signer *keySigner) Sign(signingInput []byte) ([]byte, error) {
+	hash := sha256.Sum256(signingInput)
+
+	switch key := signer.key.(type) {
+	case *rsa.PrivateKey:
+		return rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+		if err != nil {
+			return nil, err
+		}
+
+		// Fixed size r || s as required by JWA
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+
+		return signature, nil
+
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, signingInput), nil
+
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signingInput)
+
+		return mac.Sum(nil), nil
+	}
+
+	return nil, KeyNotFoundErr
+}
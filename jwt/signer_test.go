@@ -0,0 +1,42 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+)
+
+func TestES256SignerCurve(t *testing.T) {
+	tests := []struct {
+		name  string
+		curve elliptic.Curve
+		err   error
+	}{
+		{name: "P-256", curve: elliptic.P256()},
+		{name: "P-384", curve: elliptic.P384(), err: UnsupportedCurveErr},
+		{name: "P-521", curve: elliptic.P521(), err: UnsupportedCurveErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := ecdsa.GenerateKey(test.curve, rand.Reader)
+			if err != nil {
+				t.Fatalf("GenerateKey failed: %v", err)
+			}
+
+			signer, err := NewES256Signer("key", key)
+			if err != test.err {
+				t.Fatalf("Expected error %v, got %v", test.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if _, err = Sign(signer, "JWT", map[string]string{"sub": "owner"}); err != nil {
+				t.Errorf("Sign failed: %v", err)
+			}
+		})
+	}
+}
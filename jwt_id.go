@@ -0,0 +1,19 @@
+package oauth2
+
+import "time"
+
+// JwtId records the jti of a used assertion so it can not be replayed before it expires
+type JwtId struct {
+	// Postgres
+	TableName struct{} `sql:"oauth_jwt_ids"`
+
+	Issuer    string `sql:",pk"`
+	Id        string `sql:",pk"`
+	ExpiresAt time.Time
+}
+
+type JwtIdRepository interface {
+	// CreateJwtId MUST return JwtIdAlreadyUsedErr if the issuer has already used the id
+	CreateJwtId(jwtId *JwtId) error
+	DeleteExpiredJwtIds() error
+}
@@ -0,0 +1,129 @@
+package keys
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+type fileStore struct {
+	mutex sync.Mutex
+	path  string
+}
+
+// NewFileStore keeps keys in a JSON file, the file contains private keys and is created with mode 0600
+func NewFileStore(path string) Store {
+	return &fileStore{
+		path: path,
+	}
+}
+
+func (store *fileStore) GetKeys() ([]*SigningKey, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.read()
+}
+
+func (store *fileStore) CreateKey(key *SigningKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	keys, err := store.read()
+	if err != nil {
+		return err
+	}
+
+	if hasStateConflict(keys, key) {
+		return KeyStateConflictErr
+	}
+
+	return store.write(append(keys, key))
+}
+
+func (store *fileStore) SaveKey(key *SigningKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	keys, err := store.read()
+	if err != nil {
+		return err
+	}
+
+	replaced := false
+	for i, existing := range keys {
+		if existing.Id == key.Id {
+			keys[i] = key
+			replaced = true
+		}
+	}
+
+	if !replaced {
+		keys = append(keys, key)
+	}
+
+	return store.write(keys)
+}
+
+func (store *fileStore) DeleteKey(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	keys, err := store.read()
+	if err != nil {
+		return err
+	}
+
+	remaining := make([]*SigningKey, 0, len(keys))
+	for _, key := range keys {
+		if key.Id != id {
+			remaining = append(remaining, key)
+		}
+	}
+
+	return store.write(remaining)
+}
+
+func (store *fileStore) read() ([]*SigningKey, error) {
+	data, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return []*SigningKey{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*SigningKey, 0)
+	if err = json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Write to a temporary file and rename it so the file is never left half written
+func (store *fileStore) write(keys []*SigningKey) error {
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), store.path)
+}
@@ -0,0 +1,151 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/jwt"
+	"github.com/pkg/errors"
+)
+
+type State string
+
+const (
+	// Published but not used for signing yet, gives caches time to pick it up
+	StateNext State = "next"
+	// Used for signing
+	StateActive State = "active"
+	// Only used for verifying tokens signed before the rotation
+	StateRetired State = "retired"
+)
+
+var (
+	KeyNotFoundErr          = errors.New("Signing key not found")
+	UnsupportedAlgorithmErr = errors.New("Signing algorithm is not supported")
+	KeyStateConflictErr     = errors.New("Another key already has this state")
+)
+
+type SigningKey struct {
+	// Postgres
+	TableName struct{} `sql:"oauth_signing_keys"`
+
+	Id        string `sql:",pk"`
+	Algorithm jwt.Algorithm
+	State     State
+
+	// PKCS #8 encoded private key, or the raw secret for HS256
+	PrivateKey []byte
+
+	CreatedAt   time.Time
+	ActivatedAt time.Time
+	RetiredAt   time.Time
+}
+
+// Generate a new key, it starts as the next key
+func generateSigningKey(algorithm jwt.Algorithm, rsaKeySize int) (*SigningKey, error) {
+	var privateKey interface{}
+	var err error
+
+	switch algorithm {
+	case jwt.RS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, rsaKeySize)
+	case jwt.ES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.EdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case jwt.HS256:
+		secret := make([]byte, 32)
+		_, err = rand.Read(secret)
+		privateKey = secret
+	default:
+		return nil, UnsupportedAlgorithmErr
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, ok := privateKey.([]byte)
+	if !ok {
+		if encoded, err = x509.MarshalPKCS8PrivateKey(privateKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return &SigningKey{
+		Id:         oauth2.GenerateRandomString(16),
+		Algorithm:  algorithm,
+		State:      StateNext,
+		PrivateKey: encoded,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// Signer for the key
+func (key *SigningKey) Signer() (jwt.Signer, error) {
+	if key.Algorithm == jwt.HS256 {
+		return jwt.NewHS256Signer(key.Id, key.PrivateKey), nil
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return jwt.NewRS256Signer(key.Id, privateKey), nil
+	case *ecdsa.PrivateKey:
+		return jwt.NewES256Signer(key.Id, privateKey)
+	case ed25519.PrivateKey:
+		return jwt.NewEdDSASigner(key.Id, privateKey), nil
+	}
+
+	return nil, UnsupportedAlgorithmErr
+}
+
+// Verification key for the key, the public key or the secret for HS256
+func (key *SigningKey) VerificationKey() (jwt.Key, error) {
+	if key.Algorithm == jwt.HS256 {
+		return jwt.Key{Id: key.Id, Algorithm: key.Algorithm, Key: key.PrivateKey}, nil
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(key.PrivateKey)
+	if err != nil {
+		return jwt.Key{}, err
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return jwt.Key{}, UnsupportedAlgorithmErr
+	}
+
+	return jwt.Key{Id: key.Id, Algorithm: key.Algorithm, Key: signer.Public()}, nil
+}
+
+// Store persists signing keys
+type Store interface {
+	GetKeys() ([]*SigningKey, error)
+	// CreateKey inserts a new next or active key, it MUST return KeyStateConflictErr if another key
+	// already has that state so instances sharing the store can't create more than one
+	CreateKey(key *SigningKey) error
+	// SaveKey inserts or updates the key
+	SaveKey(key *SigningKey) error
+	DeleteKey(id string) error
+}
+
+// Keys in other states than retired are unique, check if any of the keys conflicts with the new key
+func hasStateConflict(keys []*SigningKey, key *SigningKey) bool {
+	if key.State == StateRetired {
+		return false
+	}
+
+	return findKey(keys, key.State) != nil
+}
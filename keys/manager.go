@@ -0,0 +1,271 @@
+package keys
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2/jwt"
+)
+
+var ManagerDefaultConfig = ManagerConfig{
+	Algorithm:        jwt.ES256,
+	RotationInterval: time.Hour * 24 * 30,
+	MaxTokenLifetime: time.Hour * 24,
+	RsaKeySize:       2048,
+}
+
+type ManagerConfig struct {
+	// Algorithm of generated keys
+	Algorithm jwt.Algorithm
+
+	// How long a key is used for signing before it is rotated
+	RotationInterval time.Duration
+
+	// The longest lifetime of any token signed by the keys, retired keys
+	// keep verifying tokens until this has passed
+	MaxTokenLifetime time.Duration
+
+	// Size of generated RSA keys
+	RsaKeySize int
+}
+
+// Manager keeps an active key for signing, a next key that is published before it becomes active
+// and retired keys that still verify tokens. It can be used both as ServerConfig.AccessTokenSigner
+// and ServerConfig.AccessTokenKeys.
+type Manager struct {
+	store  Store
+	config ManagerConfig
+
+	mutex  sync.RWMutex
+	active jwt.Signer
+	keys   jwt.KeySet
+}
+
+// NewManager loads the keys from the store, creating the active and next keys if they are missing
+func NewManager(store Store, config ManagerConfig) (*Manager, error) {
+	if store == nil {
+		panic("No key store given to key manager")
+	}
+
+	manager := &Manager{
+		store:  store,
+		config: config,
+	}
+
+	if err := manager.RotateIfDue(); err != nil {
+		return nil, err
+	}
+
+	return manager, nil
+}
+
+func (manager *Manager) Current() (jwt.Signer, error) {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+
+	if manager.active == nil {
+		return nil, KeyNotFoundErr
+	}
+
+	return manager.active, nil
+}
+
+func (manager *Manager) Algorithm() jwt.Algorithm {
+	return manager.config.Algorithm
+}
+
+func (manager *Manager) KeyId() string {
+	signer, err := manager.Current()
+	if err != nil {
+		return ""
+	}
+
+	return signer.KeyId()
+}
+
+func (manager *Manager) Sign(signingInput []byte) ([]byte, error) {
+	signer, err := manager.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	return signer.Sign(signingInput)
+}
+
+// Keys returns the active, next and retired keys
+func (manager *Manager) Keys() (jwt.KeySet, error) {
+	manager.mutex.RLock()
+	defer manager.mutex.RUnlock()
+
+	return manager.keys, nil
+}
+
+// Rotate promotes the next key to active, retires the active key and generates a new next key
+func (manager *Manager) Rotate() error {
+	keys, err := manager.store.GetKeys()
+	if err != nil {
+		return err
+	}
+
+	if err = manager.rotate(keys); err != nil {
+		return err
+	}
+
+	return manager.Reload()
+}
+
+// RotateIfDue reloads the keys and rotates them if the active key is older than the rotation interval,
+// also used to pick up rotations made by other instances sharing the store
+func (manager *Manager) RotateIfDue() error {
+	keys, err := manager.store.GetKeys()
+	if err != nil {
+		return err
+	}
+
+	if err = manager.deleteExpiredKeys(keys); err != nil {
+		return err
+	}
+
+	active := findKey(keys, StateActive)
+	next := findKey(keys, StateNext)
+
+	switch {
+	case active == nil || time.Since(active.ActivatedAt) >= manager.config.RotationInterval:
+		err = manager.rotate(keys)
+	case next == nil:
+		err = manager.createNextKey()
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return manager.Reload()
+}
+
+// RotatePeriodically checks if the keys are due for rotation every interval until the context is done
+func (manager *Manager) RotatePeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			manager.RotateIfDue()
+		}
+	}
+}
+
+// Reload the keys from the store
+func (manager *Manager) Reload() error {
+	keys, err := manager.store.GetKeys()
+	if err != nil {
+		return err
+	}
+
+	var active jwt.Signer
+	keySet := make(jwt.KeySet, 0, len(keys))
+
+	for _, key := range keys {
+		verificationKey, err := key.VerificationKey()
+		if err != nil {
+			return err
+		}
+
+		keySet = append(keySet, verificationKey)
+
+		if key.State == StateActive {
+			if active, err = key.Signer(); err != nil {
+				return err
+			}
+		}
+	}
+
+	manager.mutex.Lock()
+	manager.active = active
+	manager.keys = keySet
+	manager.mutex.Unlock()
+
+	return nil
+}
+
+func (manager *Manager) rotate(keys []*SigningKey) error {
+	now := time.Now()
+
+	if active := findKey(keys, StateActive); active != nil {
+		active.State = StateRetired
+		active.RetiredAt = now
+
+		if err := manager.store.SaveKey(active); err != nil {
+			return err
+		}
+	}
+
+	// Without a next key, e.g. on the first start, generate the key to activate right away
+	next := findKey(keys, StateNext)
+	if next == nil {
+		next, err := generateSigningKey(manager.config.Algorithm, manager.config.RsaKeySize)
+		if err != nil {
+			return err
+		}
+
+		next.State = StateActive
+		next.ActivatedAt = now
+
+		// Another instance activated a key at the same time
+		if err = manager.store.CreateKey(next); err != nil && err != KeyStateConflictErr {
+			return err
+		}
+
+		return manager.createNextKey()
+	}
+
+	next.State = StateActive
+	next.ActivatedAt = now
+
+	if err := manager.store.SaveKey(next); err != nil {
+		return err
+	}
+
+	return manager.createNextKey()
+}
+
+// Create a next key unless another instance sharing the store already did
+func (manager *Manager) createNextKey() error {
+	next, err := generateSigningKey(manager.config.Algorithm, manager.config.RsaKeySize)
+	if err != nil {
+		return err
+	}
+
+	if err = manager.store.CreateKey(next); err != nil && err != KeyStateConflictErr {
+		return err
+	}
+
+	return nil
+}
+
+// Retired keys are only needed until every token they signed has expired
+func (manager *Manager) deleteExpiredKeys(keys []*SigningKey) error {
+	for _, key := range keys {
+		if key.State == StateRetired && time.Since(key.RetiredAt) > manager.config.MaxTokenLifetime {
+			if err := manager.store.DeleteKey(key.Id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func findKey(keys []*SigningKey, state State) *SigningKey {
+	for _, key := range keys {
+		if key.State == state {
+			return key
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,202 @@
+package keys
+
+import (
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2/jwt"
+)
+
+func newTestManager(t *testing.T, store Store) *Manager {
+	manager, err := NewManager(store, ManagerDefaultConfig)
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+
+	return manager
+}
+
+// The ids of the keys in each state
+func keyStates(t *testing.T, store Store) map[State][]string {
+	keys, err := store.GetKeys()
+	if err != nil {
+		t.Fatalf("GetKeys failed: %v", err)
+	}
+
+	states := make(map[State][]string)
+	for _, key := range keys {
+		states[key.State] = append(states[key.State], key.Id)
+	}
+
+	return states
+}
+
+func sign(t *testing.T, signer jwt.Signer) *jwt.Token {
+	raw, err := jwt.Sign(signer, jwt.AccessTokenType, jwt.Claims{Subject: "owner"})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	token, err := jwt.Parse(raw)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	return token
+}
+
+func TestNewManager(t *testing.T) {
+	store := NewMemoryStore()
+	manager := newTestManager(t, store)
+
+	states := keyStates(t, store)
+	if len(states[StateActive]) != 1 || len(states[StateNext]) != 1 || len(states[StateRetired]) != 0 {
+		t.Fatalf("Keys are %v, expected one active and one next key", states)
+	}
+
+	if manager.KeyId() != states[StateActive][0] {
+		t.Errorf("Signing with %q, expected the active key %q", manager.KeyId(), states[StateActive][0])
+	}
+
+	// The next key is published before it is used for signing
+	keys, _ := manager.Keys()
+	if len(keys.Find(states[StateNext][0], jwt.ES256)) != 1 {
+		t.Errorf("Next key is not published")
+	}
+
+	// Another instance sharing the store uses the same keys
+	newTestManager(t, store)
+
+	if shared := keyStates(t, store); len(shared[StateActive]) != 1 || len(shared[StateNext]) != 1 || shared[StateActive][0] != states[StateActive][0] {
+		t.Errorf("Keys are %v after starting another instance, expected %v", shared, states)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	store := NewMemoryStore()
+	manager := newTestManager(t, store)
+
+	before := keyStates(t, store)
+	token := sign(t, manager)
+
+	if err := manager.Rotate(); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	after := keyStates(t, store)
+
+	if len(after[StateActive]) != 1 || after[StateActive][0] != before[StateNext][0] {
+		t.Errorf("Active key is %v, expected the previous next key %v", after[StateActive], before[StateNext])
+	}
+
+	if len(after[StateRetired]) != 1 || after[StateRetired][0] != before[StateActive][0] {
+		t.Errorf("Retired keys are %v, expected the previous active key %v", after[StateRetired], before[StateActive])
+	}
+
+	if len(after[StateNext]) != 1 || after[StateNext][0] == before[StateNext][0] {
+		t.Errorf("No new next key was created")
+	}
+
+	if manager.KeyId() != after[StateActive][0] {
+		t.Errorf("Signing with %q, expected the new active key %q", manager.KeyId(), after[StateActive][0])
+	}
+
+	// Tokens signed before the rotation are verified by the retired key
+	keys, _ := manager.Keys()
+	if err := token.Verify(keys); err != nil {
+		t.Errorf("Token signed before the rotation failed to verify: %v", err)
+	}
+
+	if err := sign(t, manager).Verify(keys); err != nil {
+		t.Errorf("Token signed after the rotation failed to verify: %v", err)
+	}
+}
+
+func TestRotateIfDue(t *testing.T) {
+	tests := []struct {
+		name      string
+		activated time.Duration
+		retired   time.Duration
+		rotated   bool
+		deleted   bool
+	}{
+		{name: "not due", activated: time.Hour, retired: time.Hour},
+		{name: "due", activated: ManagerDefaultConfig.RotationInterval + time.Hour, retired: time.Hour, rotated: true},
+		{name: "retired key expired", activated: time.Hour, retired: ManagerDefaultConfig.MaxTokenLifetime + time.Hour, deleted: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			manager := newTestManager(t, store)
+
+			if err := manager.Rotate(); err != nil {
+				t.Fatalf("Rotate failed: %v", err)
+			}
+
+			keys, _ := store.GetKeys()
+			for _, key := range keys {
+				key.ActivatedAt = time.Now().Add(-test.activated)
+				key.RetiredAt = time.Now().Add(-test.retired)
+
+				if err := store.SaveKey(key); err != nil {
+					t.Fatalf("SaveKey failed: %v", err)
+				}
+			}
+
+			before := keyStates(t, store)
+
+			if err := manager.RotateIfDue(); err != nil {
+				t.Fatalf("RotateIfDue failed: %v", err)
+			}
+
+			after := keyStates(t, store)
+
+			if rotated := after[StateActive][0] != before[StateActive][0]; rotated != test.rotated {
+				t.Errorf("Keys were rotated: %v, expected %v", rotated, test.rotated)
+			}
+
+			deleted := true
+			for _, id := range after[StateRetired] {
+				if id == before[StateRetired][0] {
+					deleted = false
+				}
+			}
+
+			if deleted != test.deleted {
+				t.Errorf("Retired key was deleted: %v, expected %v", deleted, test.deleted)
+			}
+		})
+	}
+}
+
+func TestSigningKeyAlgorithms(t *testing.T) {
+	for _, algorithm := range []jwt.Algorithm{jwt.RS256, jwt.ES256, jwt.EdDSA, jwt.HS256} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			config := ManagerDefaultConfig
+			config.Algorithm = algorithm
+
+			manager, err := NewManager(NewMemoryStore(), config)
+			if err != nil {
+				t.Fatalf("NewManager failed: %v", err)
+			}
+
+			token := sign(t, manager)
+			if token.Header.Algorithm != algorithm {
+				t.Errorf("Token is signed with %q, expected %q", token.Header.Algorithm, algorithm)
+			}
+
+			keys, _ := manager.Keys()
+			if err = token.Verify(keys); err != nil {
+				t.Errorf("Verify failed: %v", err)
+			}
+		})
+	}
+
+	config := ManagerDefaultConfig
+	config.Algorithm = "none"
+
+	if _, err := NewManager(NewMemoryStore(), config); err != UnsupportedAlgorithmErr {
+		t.Errorf("Expected error %v, got %v", UnsupportedAlgorithmErr, err)
+	}
+}
@@ -0,0 +1,61 @@
+package keys
+
+import "sync"
+
+type memoryStore struct {
+	mutex sync.RWMutex
+	keys  map[string]SigningKey
+}
+
+// NewMemoryStore keeps keys in memory, all keys are lost on restart
+func NewMemoryStore() Store {
+	return &memoryStore{
+		keys: map[string]SigningKey{},
+	}
+}
+
+func (store *memoryStore) GetKeys() ([]*SigningKey, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	keys := make([]*SigningKey, 0, len(store.keys))
+	for _, key := range store.keys {
+		key := key
+		keys = append(keys, &key)
+	}
+
+	return keys, nil
+}
+
+func (store *memoryStore) CreateKey(key *SigningKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, existing := range store.keys {
+		if existing.State == key.State && key.State != StateRetired {
+			return KeyStateConflictErr
+		}
+	}
+
+	store.keys[key.Id] = *key
+
+	return nil
+}
+
+func (store *memoryStore) SaveKey(key *SigningKey) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.keys[key.Id] = *key
+
+	return nil
+}
+
+func (store *memoryStore) DeleteKey(id string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.keys, id)
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package keys
+
+import (
+	"github.com/go-pg/pg"
+)
+
+type postgresStore struct {
+	db *pg.DB
+}
+
+// NewPostgresStore keeps keys in the oauth_signing_keys table, which needs a unique index on the
+// state of next and active keys:
+//
+//	CREATE UNIQUE INDEX oauth_signing_keys_state ON oauth_signing_keys (state) WHERE state <> 'retired';
+func NewPostgresStore(db *pg.DB) Store {
+	return &postgresStore{
+		db: db,
+	}
+}
+
+func (store *postgresStore) GetKeys() ([]*SigningKey, error) {
+	keys := make([]*SigningKey, 0)
+
+	err := store.db.Model(&keys).Select()
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (store *postgresStore) CreateKey(key *SigningKey) error {
+	_, err := store.db.Model(key).Insert()
+	// Only a unique violation means another instance created a key of the same state
+	if pgErr, ok := err.(pg.Error); ok && pgErr.Field('C') == "23505" {
+		return KeyStateConflictErr
+	}
+
+	return err
+}
+
+func (store *postgresStore) SaveKey(key *SigningKey) error {
+	_, err := store.db.Model(key).
+		OnConflict("(id) DO UPDATE").
+		Set("state = EXCLUDED.state").
+		Set("activated_at = EXCLUDED.activated_at").
+		Set("retired_at = EXCLUDED.retired_at").
+		Insert()
+
+	return err
+}
+
+func (store *postgresStore) DeleteKey(id string) error {
+	key := &SigningKey{}
+
+	_, err := store.db.Model(key).Where("id = ?", id).Delete()
+
+	return err
+}
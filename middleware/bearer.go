@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+type BearerMiddleware struct {
+	validator TokenValidator
+
+	// Realm included in the WWW-Authenticate header, optional
+	Realm string
+}
+
+func NewBearerMiddleware(validator TokenValidator) *BearerMiddleware {
+	if validator == nil {
+		panic("No token validator given to bearer middleware")
+	}
+
+	return &BearerMiddleware{
+		validator: validator,
+	}
+}
+
+// Handler requires a valid access token with all of the scopes before calling next,
+// the access token is available through AccessTokenFromContext
+func (middleware *BearerMiddleware) Handler(next http.Handler, scopes ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		providedToken, err := getBearerToken(r)
+		if err != nil {
+			middleware.writeError(w, http.StatusBadRequest, err, nil)
+			return
+		}
+
+		// No authentication attempt, the error code SHOULD NOT be included
+		if providedToken == "" {
+			middleware.writeError(w, http.StatusUnauthorized, nil, nil)
+			return
+		}
+
+		accessToken, err := middleware.validateToken(r, providedToken)
+		if err == oauth2.AccessTokenNotFoundErr {
+			middleware.writeError(w, http.StatusUnauthorized, oauth2.NewError(oauth2.InvalidTokenErr, "The access token is invalid"), nil)
+			return
+		} else if err != nil {
+			middleware.writeError(w, http.StatusInternalServerError, oauth2.NewError(oauth2.ServerErrorErr, err.Error()), nil)
+			return
+		}
+
+		if accessToken.IsExpired() {
+			middleware.writeError(w, http.StatusUnauthorized, oauth2.NewError(oauth2.InvalidTokenErr, "The access token has expired"), nil)
+			return
+		}
+
+		if !accessToken.MatchScopes(scopes) {
+			middleware.writeError(w, http.StatusForbidden, oauth2.NewError(oauth2.InsufficientScopeErr, "The access token is missing required scopes"), scopes)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), accessToken)))
+	})
+}
+
+// HandlerFunc wraps a single route, e.g. mux.HandleFunc("/users", m.HandlerFunc(listUsers, "users:read"))
+func (middleware *BearerMiddleware) HandlerFunc(next http.HandlerFunc, scopes ...string) http.HandlerFunc {
+	return middleware.Handler(next, scopes...).ServeHTTP
+}
+
+// RequireScopes returns a wrapper for routers using func(http.Handler) http.Handler middlewares
+func (middleware *BearerMiddleware) RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return middleware.Handler(next, scopes...)
+	}
+}
+
+// Pass the context of the request on to validators that are context aware
+func (middleware *BearerMiddleware) validateToken(r *http.Request, token string) (*oauth2.AccessToken, error) {
+	if validator, ok := middleware.validator.(ContextTokenValidator); ok {
+		return validator.ValidateTokenContext(r.Context(), token)
+	}
+
+	return middleware.validator.ValidateToken(token)
+}
+
+// Get the bearer token from the authorization header, empty if there is none
+func getBearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", nil
+	}
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", nil
+	}
+
+	token := strings.TrimSpace(parts[1])
+	if token == "" {
+		return "", oauth2.NewError(oauth2.InvalidRequestErr, "The bearer token is missing")
+	}
+
+	return token, nil
+}
+
+func (middleware *BearerMiddleware) writeError(w http.ResponseWriter, status int, err error, scopes []string) {
+	params := make([]string, 0, 4)
+
+	if middleware.Realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", middleware.Realm))
+	}
+
+	oauthError, ok := err.(oauth2.OauthError)
+	if ok && oauthError.Err != oauth2.ServerErrorErr {
+		params = append(params, fmt.Sprintf("error=%q", oauthError.Err))
+		params = append(params, fmt.Sprintf("error_description=%q", oauthError.Description))
+	}
+
+	if len(scopes) > 0 {
+		params = append(params, fmt.Sprintf("scope=%q", strings.Join(scopes, " ")))
+	}
+
+	challenge := "Bearer"
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+
+	if status != http.StatusInternalServerError {
+		w.Header().Set("WWW-Authenticate", challenge)
+	}
+
+	if !ok {
+		w.WriteHeader(status)
+		return
+	}
+
+	body, _ := json.Marshal(oauthError)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
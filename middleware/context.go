@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+type contextKey int
+
+const accessTokenKey contextKey = iota
+
+// NewContext returns a copy of the context carrying the validated access token
+func NewContext(ctx context.Context, accessToken *oauth2.AccessToken) context.Context {
+	return context.WithValue(ctx, accessTokenKey, accessToken)
+}
+
+// AccessTokenFromContext returns the access token validated by the middleware
+func AccessTokenFromContext(ctx context.Context) (*oauth2.AccessToken, bool) {
+	accessToken, ok := ctx.Value(accessTokenKey).(*oauth2.AccessToken)
+
+	return accessToken, ok && accessToken != nil
+}
+
+// OwnerIdFromContext returns the owner of the validated access token, empty for client tokens
+func OwnerIdFromContext(ctx context.Context) (oauth2.OauthTokenOwnerId, bool) {
+	accessToken, ok := AccessTokenFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	return accessToken.OwnerId, true
+}
+
+// ClientIdFromContext returns the client the validated access token was issued to
+func ClientIdFromContext(ctx context.Context) (string, bool) {
+	accessToken, ok := AccessTokenFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+
+	return accessToken.ClientId, true
+}
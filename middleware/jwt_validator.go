@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"strings"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/jwt"
+)
+
+type jwtValidator struct {
+	keys     jwt.KeyProvider
+	issuer   string
+	audience string
+	leeway   time.Duration
+}
+
+// NewJwtValidator validates self-contained JWT access tokens (RFC 9068) without the token
+// repository, revoked tokens stay valid until they expire
+func NewJwtValidator(keys jwt.KeyProvider, issuer, audience string, leeway time.Duration) TokenValidator {
+	return &jwtValidator{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		leeway:   leeway,
+	}
+}
+
+func (validator *jwtValidator) ValidateToken(token string) (*oauth2.AccessToken, error) {
+	parsed, err := jwt.Parse(token)
+	if err != nil {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	// Prevents other JWTs signed by the same keys, such as id tokens, to be used as access tokens
+	if !strings.EqualFold(parsed.Header.Type, jwt.AccessTokenType) &&
+		!strings.EqualFold(parsed.Header.Type, "application/"+jwt.AccessTokenType) {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	keys, err := validator.keys.Keys()
+	if err != nil {
+		return nil, err
+	}
+
+	err = parsed.Verify(keys)
+
+	// The token may be signed by a key published since the keys were fetched
+	if refresher, ok := validator.keys.(jwt.KeyRefresher); ok && err == jwt.KeyNotFoundErr {
+		if keys, err = refresher.RefreshKeys(); err == nil {
+			err = parsed.Verify(keys)
+		}
+	}
+
+	if err != nil {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	claims := &jwt.AccessTokenClaims{}
+	if err = parsed.Claims(claims); err != nil {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	if err = claims.Validate(time.Now(), validator.leeway); err != nil {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	if claims.Issuer != validator.issuer {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	if validator.audience != "" && !claims.Audience.Contains(validator.audience) {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	scopes := make([]string, 0)
+	if claims.Scope != "" {
+		scopes = strings.Split(claims.Scope, " ")
+	}
+
+	// Tokens issued to a client on its own behalf use the client as subject
+	ownerId := oauth2.OauthTokenOwnerId(claims.Subject)
+	if claims.Subject == claims.ClientId {
+		ownerId = ""
+	}
+
+	accessToken := &oauth2.AccessToken{
+		OauthToken: &oauth2.OauthToken{
+			Token:     token,
+			ExpiresAt: claims.ExpiresAtTime(),
+			Scopes:    scopes,
+			ClientId:  claims.ClientId,
+			OwnerId:   ownerId,
+		},
+		Audience: claims.Audience,
+	}
+
+	if claims.Actor != nil {
+		accessToken.Actor = claims.Actor.Subject
+	}
+
+	if claims.AuthTime != 0 {
+		accessToken.AuthenticatedAt = time.Unix(claims.AuthTime, 0)
+	}
+
+	return accessToken, nil
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/jwt"
+)
+
+const (
+	testIssuer   = "https://example.com"
+	testAudience = "https://api.example.com"
+)
+
+func TestJwtValidator(t *testing.T) {
+	secret := []byte("a secret of at least thirty-two bytes")
+	signer := jwt.NewHS256Signer("key", secret)
+	keys := jwt.KeySet{{Id: "key", Algorithm: jwt.HS256, Key: secret}}
+
+	valid := func() jwt.AccessTokenClaims {
+		return jwt.AccessTokenClaims{
+			Claims: jwt.Claims{
+				Issuer:    testIssuer,
+				Subject:   "owner",
+				Audience:  jwt.Audience{testAudience},
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+			ClientId: "client",
+			Scope:    "read write",
+		}
+	}
+
+	tests := []struct {
+		name      string
+		signer    jwt.Signer
+		tokenType string
+		modify    func(claims *jwt.AccessTokenClaims)
+		ownerId   oauth2.OauthTokenOwnerId
+		valid     bool
+	}{
+		{name: "valid", ownerId: "owner", valid: true},
+		{name: "media type", tokenType: "application/at+jwt", ownerId: "owner", valid: true},
+		{
+			name:   "client credentials",
+			modify: func(claims *jwt.AccessTokenClaims) { claims.Subject = claims.ClientId },
+			valid:  true,
+		},
+		{name: "another token type", tokenType: "JWT"},
+		{name: "unknown key", signer: jwt.NewHS256Signer("other", secret)},
+		{name: "bad signature", signer: jwt.NewHS256Signer("key", []byte("another secret of thirty-two bytes"))},
+		{name: "expired", modify: func(claims *jwt.AccessTokenClaims) { claims.ExpiresAt = time.Now().Add(-time.Hour).Unix() }},
+		{name: "no expiration", modify: func(claims *jwt.AccessTokenClaims) { claims.ExpiresAt = 0 }},
+		{name: "not yet valid", modify: func(claims *jwt.AccessTokenClaims) { claims.NotBefore = time.Now().Add(time.Hour).Unix() }},
+		{name: "another issuer", modify: func(claims *jwt.AccessTokenClaims) { claims.Issuer = "https://attacker.example.com" }},
+		{name: "another audience", modify: func(claims *jwt.AccessTokenClaims) { claims.Audience = jwt.Audience{"https://other.example.com"} }},
+		{name: "no audience", modify: func(claims *jwt.AccessTokenClaims) { claims.Audience = nil }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := valid()
+			if test.modify != nil {
+				test.modify(&claims)
+			}
+
+			tokenSigner := test.signer
+			if tokenSigner == nil {
+				tokenSigner = signer
+			}
+
+			tokenType := test.tokenType
+			if tokenType == "" {
+				tokenType = jwt.AccessTokenType
+			}
+
+			token, err := jwt.Sign(tokenSigner, tokenType, claims)
+			if err != nil {
+				t.Fatalf("Sign failed: %v", err)
+			}
+
+			accessToken, err := NewJwtValidator(keys, testIssuer, testAudience, 0).ValidateToken(token)
+			if !test.valid {
+				if err != oauth2.AccessTokenNotFoundErr {
+					t.Errorf("Expected error %v, got %v", oauth2.AccessTokenNotFoundErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ValidateToken failed: %v", err)
+			}
+
+			if accessToken.ClientId != "client" || accessToken.OwnerId != test.ownerId {
+				t.Errorf("Access token was issued to %q %q, expected %q %q", accessToken.ClientId, accessToken.OwnerId, "client", test.ownerId)
+			}
+
+			if !accessToken.MatchScopes([]string{"read", "write"}) {
+				t.Errorf("Scopes are %v", accessToken.Scopes)
+			}
+		})
+	}
+}
+
+// Fails the first lookup as if the key was published after the keys were cached
+type refreshingKeyProvider struct {
+	keys      jwt.KeySet
+	refreshed bool
+}
+
+func (provider *refreshingKeyProvider) Keys() (jwt.KeySet, error) {
+	if provider.refreshed {
+		return provider.keys, nil
+	}
+
+	return jwt.KeySet{}, nil
+}
+
+func (provider *refreshingKeyProvider) RefreshKeys() (jwt.KeySet, error) {
+	provider.refreshed = true
+
+	return provider.keys, nil
+}
+
+func TestJwtValidatorRefreshesKeys(t *testing.T) {
+	secret := []byte("a secret of at least thirty-two bytes")
+	provider := &refreshingKeyProvider{keys: jwt.KeySet{{Id: "key", Algorithm: jwt.HS256, Key: secret}}}
+
+	token, err := jwt.Sign(jwt.NewHS256Signer("key", secret), jwt.AccessTokenType, jwt.AccessTokenClaims{
+		Claims:   jwt.Claims{Issuer: testIssuer, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		ClientId: "client",
+	})
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	if _, err = NewJwtValidator(provider, testIssuer, "", 0).ValidateToken(token); err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+
+	if !provider.refreshed {
+		t.Errorf("Keys were not refreshed")
+	}
+}
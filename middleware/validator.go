@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/jwt"
+)
+
+// TokenValidator looks up a bearer token, it MUST return oauth2.AccessTokenNotFoundErr
+// for unknown or inactive tokens, any other error is treated as a server error
+type TokenValidator interface {
+	ValidateToken(token string) (*oauth2.AccessToken, error)
+}
+
+// ContextTokenValidator is implemented by validators that pass the context of the request
+// on to storage or the introspection endpoint
+type ContextTokenValidator interface {
+	TokenValidator
+
+	ValidateTokenContext(ctx context.Context, token string) (*oauth2.AccessToken, error)
+}
+
+type repositoryValidator struct {
+	repository oauth2.ContextTokenRepository
+}
+
+// NewRepositoryValidator validates tokens directly against the token repository
+func NewRepositoryValidator(repository oauth2.TokenRepository) TokenValidator {
+	return &repositoryValidator{
+		repository: oauth2.NewContextTokenRepository(repository),
+	}
+}
+
+func (validator *repositoryValidator) ValidateToken(token string) (*oauth2.AccessToken, error) {
+	return validator.ValidateTokenContext(context.Background(), token)
+}
+
+func (validator *repositoryValidator) ValidateTokenContext(ctx context.Context, token string) (*oauth2.AccessToken, error) {
+	accessToken, err := validator.repository.GetAccessTokenContext(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if accessToken == nil {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	return accessToken, nil
+}
+
+// Timeout of the client used when no client is given
+const introspectionTimeout = 10 * time.Second
+
+type introspectionValidator struct {
+	endpoint     string
+	clientId     string
+	clientSecret string
+	client       *http.Client
+}
+
+// NewIntrospectionValidator validates tokens with an RFC 7662 introspection endpoint, only tokens
+// with token_type Bearer are accepted. A client with a 10 second timeout is used if no client is given
+func NewIntrospectionValidator(endpoint, clientId, clientSecret string, client *http.Client) TokenValidator {
+	if client == nil {
+		client = &http.Client{Timeout: introspectionTimeout}
+	}
+
+	return &introspectionValidator{
+		endpoint:     endpoint,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		client:       client,
+	}
+}
+
+func (validator *introspectionValidator) ValidateToken(token string) (*oauth2.AccessToken, error) {
+	return validator.ValidateTokenContext(context.Background(), token)
+}
+
+func (validator *introspectionValidator) ValidateTokenContext(ctx context.Context, token string) (*oauth2.AccessToken, error) {
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", string(oauth2.TokenTypeHintAccessToken))
+
+	req, err := http.NewRequest(http.MethodPost, validator.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(validator.clientId, validator.clientSecret)
+
+	res, err := validator.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Introspection failed with status %d", res.StatusCode)
+	}
+
+	payload := struct {
+		Active    bool                     `json:"active"`
+		Scope     string                   `json:"scope"`
+		ClientId  string                   `json:"client_id"`
+		Subject   oauth2.OauthTokenOwnerId `json:"sub"`
+		ExpiresAt int64                    `json:"exp"`
+		TokenType string                   `json:"token_type"`
+		Audience  jwt.Audience             `json:"aud"`
+		Actor     *jwt.ActorClaims         `json:"act"`
+	}{}
+
+	if err = json.NewDecoder(res.Body).Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	// Refresh tokens are never accepted as bearer tokens, they have no token type
+	if !payload.Active || !strings.EqualFold(payload.TokenType, string(oauth2.TokenTypeBearer)) {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	scopes := make([]string, 0)
+	if payload.Scope != "" {
+		scopes = strings.Split(payload.Scope, " ")
+	}
+
+	accessToken := &oauth2.AccessToken{
+		OauthToken: &oauth2.OauthToken{
+			Token:     token,
+			ExpiresAt: time.Unix(payload.ExpiresAt, 0),
+			Scopes:    scopes,
+			ClientId:  payload.ClientId,
+			OwnerId:   payload.Subject,
+		},
+		Audience: payload.Audience,
+	}
+
+	if payload.Actor != nil {
+		accessToken.Actor = payload.Actor.Subject
+	}
+
+	return accessToken, nil
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+func TestIntrospectionValidatorDefaultClient(t *testing.T) {
+	validator := NewIntrospectionValidator("https://example.com/oauth/introspect", "api", "secret", nil).(*introspectionValidator)
+
+	if validator.client == http.DefaultClient || validator.client.Timeout != introspectionTimeout {
+		t.Errorf("Default client has timeout %v, expected %v", validator.client.Timeout, introspectionTimeout)
+	}
+}
+
+func TestIntrospectionValidator(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name     string
+		status   int
+		response map[string]interface{}
+		err      bool
+		// Inactive tokens are reported as not found, other failures as errors
+		inactive bool
+		ownerId  oauth2.OauthTokenOwnerId
+	}{
+		{
+			name:   "active",
+			status: http.StatusOK,
+			response: map[string]interface{}{
+				"active":     true,
+				"scope":      "read write",
+				"client_id":  "client",
+				"sub":        "owner",
+				"exp":        expiresAt,
+				"token_type": "Bearer",
+			},
+			ownerId: "owner",
+		},
+		{name: "inactive", status: http.StatusOK, response: map[string]interface{}{"active": false}, err: true, inactive: true},
+		{
+			name:     "refresh token",
+			status:   http.StatusOK,
+			response: map[string]interface{}{"active": true, "client_id": "client", "exp": expiresAt},
+			err:      true,
+			inactive: true,
+		},
+		{
+			name:     "another token type",
+			status:   http.StatusOK,
+			response: map[string]interface{}{"active": true, "client_id": "client", "exp": expiresAt, "token_type": "refresh_token"},
+			err:      true,
+			inactive: true,
+		},
+		{name: "endpoint failure", status: http.StatusInternalServerError, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				clientId, clientSecret, _ := r.BasicAuth()
+				if clientId != "api" || clientSecret != "secret" {
+					t.Errorf("Authenticated as %q %q, expected %q %q", clientId, clientSecret, "api", "secret")
+				}
+
+				if r.FormValue("token") != "token" || r.FormValue("token_type_hint") != "access_token" {
+					t.Errorf("Introspected %q with hint %q", r.FormValue("token"), r.FormValue("token_type_hint"))
+				}
+
+				w.WriteHeader(test.status)
+				json.NewEncoder(w).Encode(test.response)
+			}))
+			defer endpoint.Close()
+
+			accessToken, err := NewIntrospectionValidator(endpoint.URL, "api", "secret", nil).ValidateToken("token")
+			if test.err {
+				if err == nil || test.inactive != (err == oauth2.AccessTokenNotFoundErr) {
+					t.Errorf("ValidateToken returned %v, expected the token to be inactive: %v", err, test.inactive)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ValidateToken failed: %v", err)
+			}
+
+			if accessToken.ClientId != "client" || accessToken.OwnerId != test.ownerId || accessToken.ExpiresAt.Unix() != expiresAt {
+				t.Errorf("Access token is %+v", accessToken.OauthToken)
+			}
+
+			if !accessToken.MatchScopes([]string{"read", "write"}) {
+				t.Errorf("Scopes are %v", accessToken.Scopes)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+type CodeChallengeMethod string
+
+const (
+	CodeChallengeMethodPlain CodeChallengeMethod = "plain"
+	CodeChallengeMethodS256  CodeChallengeMethod = "S256"
+)
+
+type PkceRequirement int
+
+const (
+	// PKCE is used when the client provides a code challenge
+	PkceOptional PkceRequirement = iota
+	// Public clients MUST provide a code challenge
+	PkceRequiredForPublicClients
+	// All clients MUST provide a code challenge, as required by OAuth 2.1
+	PkceRequiredForAllClients
+)
+
+// Both code challenges and code verifiers are 43 to 128 characters from the unreserved set
+var pkceValuePattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+func IsValidCodeChallengeMethod(method CodeChallengeMethod) bool {
+	return method == CodeChallengeMethodPlain || method == CodeChallengeMethodS256
+}
+
+func IsValidCodeChallenge(challenge string) bool {
+	return pkceValuePattern.MatchString(challenge)
+}
+
+// VerifyCodeVerifier checks the code verifier against the stored code challenge
+func VerifyCodeVerifier(challenge string, method CodeChallengeMethod, verifier string) bool {
+	if !pkceValuePattern.MatchString(verifier) {
+		return false
+	}
+
+	switch method {
+	case CodeChallengeMethodS256:
+		hash := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(hash[:])
+	case CodeChallengeMethodPlain:
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(verifier)) == 1
+}
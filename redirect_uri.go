@@ -0,0 +1,15 @@
+package oauth2
+
+// NewRegisteredRedirectUriHandler returns a ClientRedirectUriHandler allowing only the redirect uris
+// registered for each client. Uris are compared exactly, as RFC 6749 section 3.1.2.3 requires
+func NewRegisteredRedirectUriHandler(redirectUris map[string][]string) func(clientId, redirectUri string) (bool, error) {
+	return func(clientId, redirectUri string) (bool, error) {
+		for _, registered := range redirectUris[clientId] {
+			if registered == redirectUri {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+}
@@ -10,6 +10,26 @@ type CallbackPreGrant func(identifier, ipAddr string) error
 type CallbackPostGrant func(identifier, ipAddr, token string)
 type CallbackPrePersistAccessToken func(accessToken *AccessToken) error
 type CallbackPrePersistRefreshToken func(refreshToken *RefreshToken) error
+type CallbackRefreshTokenReuse func(refreshToken *RefreshToken, ipAddr string)
+
+// Context aware callbacks, used instead of the callbacks above when configured
+type CallbackPreGrantContext func(ctx context.Context, identifier, ipAddr string) error
+type CallbackPostGrantContext func(ctx context.Context, identifier, ipAddr, token string)
+type CallbackPrePersistAccessTokenContext func(ctx context.Context, accessToken *AccessToken) error
+type CallbackPrePersistRefreshTokenContext func(ctx context.Context, refreshToken *RefreshToken) error
+type CallbackRefreshTokenReuseContext func(ctx context.Context, refreshToken *RefreshToken, ipAddr string)
+
+// TokenOptions are the optional properties of tokens created by the server
+type TokenOptions struct {
+	// Links tokens issued by the same grant, may be empty
+	FamilyId string
+	// When the owner authenticated, zero means now
+	AuthenticatedAt time.Time
+
+	// Audiences and actor of access tokens issued by token exchange
+	Audience []string
+	Actor    string
+}
 
 type Server interface {
 	// PeriodicallyDeleteExpiredTokens
@@ -21,6 +41,54 @@ type Server interface {
 	// CreateRefreshToken
 	CreateRefreshToken(clientId string, owner OauthTokenOwnerId, duration time.Duration, scopes []string) (*RefreshToken, error)
 
+	// CreateAuthorizationCode
+	CreateAuthorizationCode(
+		clientId string,
+		owner OauthTokenOwnerId,
+		duration time.Duration,
+		scopes []string,
+		redirectUri string,
+		codeChallenge string,
+		codeChallengeMethod CodeChallengeMethod,
+	) (*AuthorizationCode, error)
+
+	// CreateAccessTokenContext is CreateAccessToken passing the context on to storage and callbacks,
+	// with the optional properties of the token
+	CreateAccessTokenContext(
+		ctx context.Context,
+		clientId string,
+		owner OauthTokenOwnerId,
+		duration time.Duration,
+		scopes []string,
+		options TokenOptions,
+	) (*AccessToken, error)
+
+	// CreateRefreshTokenContext is CreateRefreshToken passing the context on to storage and callbacks,
+	// with the optional properties of the token
+	CreateRefreshTokenContext(
+		ctx context.Context,
+		clientId string,
+		owner OauthTokenOwnerId,
+		duration time.Duration,
+		scopes []string,
+		options TokenOptions,
+	) (*RefreshToken, error)
+
+	// CreateAuthorizationCodeContext is CreateAuthorizationCode passing the context on to storage
+	CreateAuthorizationCodeContext(
+		ctx context.Context,
+		clientId string,
+		owner OauthTokenOwnerId,
+		duration time.Duration,
+		scopes []string,
+		redirectUri string,
+		codeChallenge string,
+		codeChallengeMethod CodeChallengeMethod,
+	) (*AuthorizationCode, error)
+
+	// GenerateToken generates a token of the kind with the configured token generator
+	GenerateToken(kind TokenKind) (string, error)
+
 	// CallbackPreGrant is called before any grant is executed with an extracted identifier from the request
 	CallbackPreGrant(identifier, ipAddr string) error
 
@@ -36,12 +104,38 @@ type Server interface {
 	// Allows an opportunity to modify a refresh token before it's persisted to storage
 	CallbackPrePersistRefreshToken(refreshToken *RefreshToken) error
 
+	// CallbackRefreshTokenReuse is called when an already rotated refresh token is used again,
+	// the token family has been revoked when it's called
+	CallbackRefreshTokenReuse(refreshToken *RefreshToken, ipAddr string)
+
+	// Context aware versions of the callbacks above
+	CallbackPreGrantContext(ctx context.Context, identifier, ipAddr string) error
+	CallbackPostGrantContext(ctx context.Context, identifier, ipAddr, token string)
+	CallbackPrePersistAccessTokenContext(ctx context.Context, accessToken *AccessToken) error
+	CallbackPrePersistRefreshTokenContext(ctx context.Context, refreshToken *RefreshToken) error
+	CallbackRefreshTokenReuseContext(ctx context.Context, refreshToken *RefreshToken, ipAddr string)
+
 	// HandleTokenRequest usually listens to /oauth/token
 	HandleTokenRequest(w http.ResponseWriter, r *http.Request)
 
 	// HandleAuthorizationRequest usually listens /oauth/authorize
 	HandleAuthorizationRequest(w http.ResponseWriter, r *http.Request)
 
+	// HandleDeviceAuthorizationRequest usually listens to /oauth/device_authorization
+	HandleDeviceAuthorizationRequest(w http.ResponseWriter, r *http.Request)
+
+	// HandleDeviceVerificationRequest is called when a signed in user approves or denies a user code
+	HandleDeviceVerificationRequest(w http.ResponseWriter, r *http.Request)
+
+	// HandleIntrospectionRequest usually listens to /oauth/introspect
+	HandleIntrospectionRequest(w http.ResponseWriter, r *http.Request)
+
+	// HandleRevocationRequest usually listens to /oauth/revoke
+	HandleRevocationRequest(w http.ResponseWriter, r *http.Request)
+
+	// HandleJwksRequest usually listens to /.well-known/jwks.json
+	HandleJwksRequest(w http.ResponseWriter, r *http.Request)
+
 	// GetRemoteAddr gets the remote ip address from the request
 	GetRemoteAddr(r *http.Request) string
 }
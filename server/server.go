@@ -4,16 +4,18 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/interactive-solutions/go-oauth2"
 	"github.com/interactive-solutions/go-oauth2/api"
+	"github.com/interactive-solutions/go-oauth2/jwt"
 )
 
 type OauthServer struct {
 	Config          oauth2.ServerConfig
-	tokenRepository oauth2.TokenRepository
+	tokenRepository oauth2.ContextTokenRepository
 }
 
 func NewDefaultOauthServer(tokenRepository oauth2.TokenRepository) *OauthServer {
@@ -27,7 +29,7 @@ func NewOauthServer(config oauth2.ServerConfig, tokenRepository oauth2.TokenRepo
 
 	return &OauthServer{
 		Config:          config,
-		tokenRepository: tokenRepository,
+		tokenRepository: oauth2.NewContextTokenRepository(tokenRepository),
 	}
 }
 
@@ -52,63 +54,265 @@ func (server *OauthServer) GetRemoteAddr(r *http.Request) string {
 }
 
 func (server *OauthServer) CallbackPreGrant(identifier, ipAddr string) error {
-	return server.Config.CallbackPreGrant(identifier, ipAddr)
+	return server.CallbackPreGrantContext(context.Background(), identifier, ipAddr)
 }
 
 func (server *OauthServer) CallbackPostGrant(identifier, ipAddr, token string) {
-	server.Config.CallbackPostGrant(identifier, ipAddr, token)
+	server.CallbackPostGrantContext(context.Background(), identifier, ipAddr, token)
 }
 
 func (server *OauthServer) CallbackPrePersistAccessToken(accessToken *oauth2.AccessToken) error {
-	return server.Config.CallbackPrePersistAccessToken(accessToken)
+	return server.CallbackPrePersistAccessTokenContext(context.Background(), accessToken)
 }
 
 func (server *OauthServer) CallbackPrePersistRefreshToken(refreshToken *oauth2.RefreshToken) error {
+	return server.CallbackPrePersistRefreshTokenContext(context.Background(), refreshToken)
+}
+
+func (server *OauthServer) CallbackRefreshTokenReuse(refreshToken *oauth2.RefreshToken, ipAddr string) {
+	server.CallbackRefreshTokenReuseContext(context.Background(), refreshToken, ipAddr)
+}
+
+func (server *OauthServer) CallbackPreGrantContext(ctx context.Context, identifier, ipAddr string) error {
+	if server.Config.CallbackPreGrantContext != nil {
+		return server.Config.CallbackPreGrantContext(ctx, identifier, ipAddr)
+	}
+
+	return server.Config.CallbackPreGrant(identifier, ipAddr)
+}
+
+func (server *OauthServer) CallbackPostGrantContext(ctx context.Context, identifier, ipAddr, token string) {
+	if server.Config.CallbackPostGrantContext != nil {
+		server.Config.CallbackPostGrantContext(ctx, identifier, ipAddr, token)
+		return
+	}
+
+	server.Config.CallbackPostGrant(identifier, ipAddr, token)
+}
+
+func (server *OauthServer) CallbackPrePersistAccessTokenContext(ctx context.Context, accessToken *oauth2.AccessToken) error {
+	if server.Config.CallbackPrePersistAccessTokenContext != nil {
+		return server.Config.CallbackPrePersistAccessTokenContext(ctx, accessToken)
+	}
+
+	return server.Config.CallbackPrePersistAccessToken(accessToken)
+}
+
+func (server *OauthServer) CallbackPrePersistRefreshTokenContext(ctx context.Context, refreshToken *oauth2.RefreshToken) error {
+	if server.Config.CallbackPrePersistRefreshTokenContext != nil {
+		return server.Config.CallbackPrePersistRefreshTokenContext(ctx, refreshToken)
+	}
+
 	return server.Config.CallbackPrePersistRefreshToken(refreshToken)
 }
 
-func (server *OauthServer) CreateAccessToken(clientId string, owner oauth2.OauthTokenOwnerId, duration time.Duration, scopes []string) (*oauth2.AccessToken, error) {
+func (server *OauthServer) CallbackRefreshTokenReuseContext(ctx context.Context, refreshToken *oauth2.RefreshToken, ipAddr string) {
+	if server.Config.CallbackRefreshTokenReuseContext != nil {
+		server.Config.CallbackRefreshTokenReuseContext(ctx, refreshToken, ipAddr)
+		return
+	}
+
+	server.Config.CallbackRefreshTokenReuse(refreshToken, ipAddr)
+}
+
+func (server *OauthServer) GenerateToken(kind oauth2.TokenKind) (string, error) {
+	if server.Config.TokenGenerator == nil {
+		return oauth2.DefaultTokenGenerator.GenerateToken(kind)
+	}
+
+	return server.Config.TokenGenerator.GenerateToken(kind)
+}
+
+func (server *OauthServer) CreateAccessToken(
+	clientId string,
+	owner oauth2.OauthTokenOwnerId,
+	duration time.Duration,
+	scopes []string,
+) (*oauth2.AccessToken, error) {
+	return server.CreateAccessTokenContext(context.Background(), clientId, owner, duration, scopes, oauth2.TokenOptions{})
+}
+
+func (server *OauthServer) CreateAccessTokenContext(
+	ctx context.Context,
+	clientId string,
+	owner oauth2.OauthTokenOwnerId,
+	duration time.Duration,
+	scopes []string,
+	options oauth2.TokenOptions,
+) (*oauth2.AccessToken, error) {
 	var accessToken *oauth2.AccessToken
 
-	for {
+	// The callback sees the token that is persisted, so it is called again if the token collided
+	err := oauth2.InsertUniqueToken(server.Config.TokenGenerationRetries, func() error {
 		accessToken = oauth2.NewAccessToken(clientId, owner, duration, scopes)
 
-		if t, _ := server.tokenRepository.GetAccessToken(accessToken.Token); t == nil {
-			break
+		token, err := server.GenerateToken(oauth2.TokenKindAccessToken)
+		if err != nil {
+			return err
 		}
-	}
 
-	if err := server.CallbackPrePersistAccessToken(accessToken); err != nil {
-		return nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
-	}
+		accessToken.Token = token
+		accessToken.FamilyId = options.FamilyId
+		accessToken.Audience = options.Audience
+		accessToken.Actor = options.Actor
+
+		if !options.AuthenticatedAt.IsZero() {
+			accessToken.AuthenticatedAt = options.AuthenticatedAt
+		}
+
+		if err = server.CallbackPrePersistAccessTokenContext(ctx, accessToken); err != nil {
+			return err
+		}
 
-	if err := server.tokenRepository.CreateAccessToken(accessToken); err != nil {
+		// Sign after the callback so the claims reflect any modifications
+		if server.Config.AccessTokenSigner != nil {
+			if err = server.signAccessToken(accessToken); err != nil {
+				return err
+			}
+		}
+
+		return server.tokenRepository.CreateAccessTokenContext(ctx, accessToken)
+	})
+	if err != nil {
 		return nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
 	}
 
 	return accessToken, nil
 }
 
-func (server *OauthServer) CreateRefreshToken(clientId string, owner oauth2.OauthTokenOwnerId, duration time.Duration, scopes []string) (*oauth2.RefreshToken, error) {
+// Replace the opaque token with a signed JWT, the opaque token becomes the jti
+func (server *OauthServer) signAccessToken(accessToken *oauth2.AccessToken) error {
+	claims := jwt.AccessTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    server.Config.AccessTokenIssuer,
+			Subject:   string(accessToken.OwnerId),
+			Audience:  jwt.Audience(server.Config.AccessTokenAudience),
+			ExpiresAt: accessToken.ExpiresAt.Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Id:        accessToken.Token,
+		},
+		ClientId: accessToken.ClientId,
+		Scope:    strings.Join(accessToken.Scopes, " "),
+	}
+
+	// Tokens issued to a client on its own behalf use the client as subject
+	if claims.Subject == "" {
+		claims.Subject = accessToken.ClientId
+	}
+
+	if !accessToken.AuthenticatedAt.IsZero() {
+		claims.AuthTime = accessToken.AuthenticatedAt.Unix()
+	}
+
+	// Exchanged tokens are restricted to the audience they were requested for
+	if len(accessToken.Audience) > 0 {
+		claims.Audience = jwt.Audience(accessToken.Audience)
+	}
+
+	if accessToken.Actor != "" {
+		claims.Actor = &jwt.ActorClaims{Subject: accessToken.Actor}
+	}
+
+	token, err := jwt.Sign(server.Config.AccessTokenSigner, jwt.AccessTokenType, claims)
+	if err != nil {
+		return err
+	}
+
+	accessToken.Token = token
+
+	return nil
+}
+
+func (server *OauthServer) CreateRefreshToken(
+	clientId string,
+	owner oauth2.OauthTokenOwnerId,
+	duration time.Duration,
+	scopes []string,
+) (*oauth2.RefreshToken, error) {
+	return server.CreateRefreshTokenContext(context.Background(), clientId, owner, duration, scopes, oauth2.TokenOptions{})
+}
+
+func (server *OauthServer) CreateRefreshTokenContext(
+	ctx context.Context,
+	clientId string,
+	owner oauth2.OauthTokenOwnerId,
+	duration time.Duration,
+	scopes []string,
+	options oauth2.TokenOptions,
+) (*oauth2.RefreshToken, error) {
 	var refreshToken *oauth2.RefreshToken
 
-	for {
+	err := oauth2.InsertUniqueToken(server.Config.TokenGenerationRetries, func() error {
 		refreshToken = oauth2.NewRefreshToken(clientId, owner, duration, scopes)
 
-		if t, _ := server.tokenRepository.GetRefreshToken(refreshToken.Token); t == nil {
-			break
+		token, err := server.GenerateToken(oauth2.TokenKindRefreshToken)
+		if err != nil {
+			return err
 		}
-	}
 
-	if err := server.CallbackPrePersistRefreshToken(refreshToken); err != nil {
+		refreshToken.Token = token
+		refreshToken.FamilyId = options.FamilyId
+
+		if !options.AuthenticatedAt.IsZero() {
+			refreshToken.AuthenticatedAt = options.AuthenticatedAt
+		}
+
+		if err = server.CallbackPrePersistRefreshTokenContext(ctx, refreshToken); err != nil {
+			return err
+		}
+
+		return server.tokenRepository.CreateRefreshTokenContext(ctx, refreshToken)
+	})
+	if err != nil {
 		return nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
 	}
 
-	if err := server.tokenRepository.CreateRefreshToken(refreshToken); err != nil {
+	return refreshToken, nil
+}
+
+func (server *OauthServer) CreateAuthorizationCode(
+	clientId string,
+	owner oauth2.OauthTokenOwnerId,
+	duration time.Duration,
+	scopes []string,
+	redirectUri string,
+	codeChallenge string,
+	codeChallengeMethod oauth2.CodeChallengeMethod,
+) (*oauth2.AuthorizationCode, error) {
+	return server.CreateAuthorizationCodeContext(context.Background(), clientId, owner, duration, scopes, redirectUri, codeChallenge, codeChallengeMethod)
+}
+
+func (server *OauthServer) CreateAuthorizationCodeContext(
+	ctx context.Context,
+	clientId string,
+	owner oauth2.OauthTokenOwnerId,
+	duration time.Duration,
+	scopes []string,
+	redirectUri string,
+	codeChallenge string,
+	codeChallengeMethod oauth2.CodeChallengeMethod,
+) (*oauth2.AuthorizationCode, error) {
+	var code *oauth2.AuthorizationCode
+
+	err := oauth2.InsertUniqueToken(server.Config.TokenGenerationRetries, func() error {
+		code = oauth2.NewAuthorizationCode(clientId, owner, duration, scopes, redirectUri)
+
+		token, err := server.GenerateToken(oauth2.TokenKindAuthorizationCode)
+		if err != nil {
+			return err
+		}
+
+		code.Token = token
+		code.CodeChallenge = codeChallenge
+		code.CodeChallengeMethod = codeChallengeMethod
+
+		return server.tokenRepository.CreateAuthorizationCodeContext(ctx, code)
+	})
+	if err != nil {
 		return nil, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
 	}
 
-	return refreshToken, nil
+	return code, nil
 }
 
 func (server *OauthServer) PeriodicallyDeleteExpiredTokens(ctx context.Context, interval time.Duration) {
@@ -119,20 +323,90 @@ func (server *OauthServer) PeriodicallyDeleteExpiredTokens(ctx context.Context,
 		timer.Stop()
 		return
 	case <-timer.C:
-		server.tokenRepository.DeleteExpiredAccessTokens()
-		server.tokenRepository.DeleteExpiredRefreshTokens()
+		server.tokenRepository.DeleteExpiredAccessTokensContext(ctx)
+		server.tokenRepository.DeleteExpiredRefreshTokensContext(ctx)
+		server.tokenRepository.DeleteExpiredAuthorizationCodesContext(ctx)
 
 		timer.Reset(interval)
 	}
 }
 
 func (server *OauthServer) HandleAuthorizationRequest(w http.ResponseWriter, r *http.Request) {
-	responseType := r.FormValue("response_type")
+	// Until the client and redirect uri have been validated we MUST NOT redirect back to the client
+	clientId, redirectUri, err := server.getRedirectUri(r)
+	if err != nil {
+		server.writeError(w, err)
+		return
+	}
+
+	state := r.FormValue("state")
+	responseType := oauth2.ResponseType(r.FormValue("response_type"))
+
+	// The implicit flow returns both tokens and errors in the fragment of the redirect uri
+	isImplicit := responseType == oauth2.ResponseTypeToken && server.Config.AllowImplicitGrant
 
 	if responseType == "" {
-		server.writeError(w, oauth2.NewError(oauth2.InvalidRequestErr, "No grant response type was found in request"))
+		server.writeErrorRedirect(w, r, redirectUri, state, false, oauth2.NewError(oauth2.InvalidRequestErr, "No grant response type was found in request"))
+		return
+	}
+
+	if responseType != oauth2.ResponseTypeCode && !isImplicit {
+		server.writeErrorRedirect(w, r, redirectUri, state, false, oauth2.NewError(
+			oauth2.UnsupportedResponseTypeErr,
+			fmt.Sprintf("Response type %s is not supported by this server", responseType),
+		))
 		return
 	}
+
+	var grantType oauth2.GrantType = oauth2.GrantTypeAuthorizationCode
+	if isImplicit {
+		grantType = oauth2.GrantTypeImplicit
+	}
+
+	oauthGrant, err := server.getGrant(grantType)
+	if err != nil {
+		server.writeErrorRedirect(w, r, redirectUri, state, isImplicit, oauth2.NewError(oauth2.UnsupportedResponseTypeErr, err.Error()))
+		return
+	}
+
+	// Check if client can access scope if we have handler set
+	if server.Config.ClientScopeHandler != nil && r.FormValue("scope") != "" {
+		allowed, err := server.Config.ClientScopeHandler(clientId, strings.Split(r.FormValue("scope"), " "))
+		if err != nil {
+			server.writeErrorRedirect(w, r, redirectUri, state, isImplicit, err)
+			return
+		}
+
+		if !allowed {
+			server.writeErrorRedirect(w, r, redirectUri, state, isImplicit, oauth2.NewError(oauth2.InvalidScopeErr, "Client not allowed to access provided scope"))
+			return
+		}
+	}
+
+	if isImplicit {
+		// The implicit grant MUST NOT issue refresh tokens
+		accessToken, _, _, err := createTokens(oauthGrant, r, clientId)
+		if err != nil {
+			server.writeErrorRedirect(w, r, redirectUri, state, true, err)
+			return
+		}
+
+		api.WriteImplicitTokenResponse(w, r, redirectUri, accessToken, state)
+		return
+	}
+
+	if err = server.checkPkceRequirement(r, clientId); err != nil {
+		server.writeErrorRedirect(w, r, redirectUri, state, false, err)
+		return
+	}
+
+	code, err := oauthGrant.CreateAuthorizationCode(r, clientId)
+	if err != nil {
+		server.writeErrorRedirect(w, r, redirectUri, state, false, err)
+		return
+	}
+
+	api.WriteAuthorizationCodeResponse(w, r, redirectUri, code, state)
 }
 
 func (server *OauthServer) HandleTokenRequest(w http.ResponseWriter, r *http.Request) {
@@ -143,6 +417,12 @@ func (server *OauthServer) HandleTokenRequest(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// The implicit grant is only available from the authorization endpoint
+	if grantType == oauth2.GrantTypeImplicit {
+		server.writeError(w, oauth2.NewError(oauth2.UnsupportedGrantTypeErr, "Implicit grant can not be used at the token endpoint"))
+		return
+	}
+
 	oauthGrant, err := server.getGrant(oauth2.GrantType(grantType))
 	if err != nil {
 		server.writeError(w, err)
@@ -155,7 +435,12 @@ func (server *OauthServer) HandleTokenRequest(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	accessToken, refreshToken, meta, err := oauthGrant.CreateTokens(r, clientId)
+	if err = server.verifyTokenParameters(r, oauth2.GrantType(grantType)); err != nil {
+		server.writeError(w, err)
+		return
+	}
+
+	accessToken, refreshToken, meta, err := createTokens(oauthGrant, r, clientId)
 	if err != nil {
 		server.writeError(w, err)
 		return
@@ -169,6 +454,303 @@ func (server *OauthServer) HandleTokenRequest(w http.ResponseWriter, r *http.Req
 	api.WriteTokenResponse(w, accessToken, refreshToken, useRefreshTokenScope, meta)
 }
 
+func (server *OauthServer) HandleDeviceAuthorizationRequest(w http.ResponseWriter, r *http.Request) {
+	deviceGrant, err := server.getDeviceAuthorizationGrant()
+	if err != nil {
+		server.writeError(w, err)
+		return
+	}
+
+	clientId, err := server.getClient(r, deviceGrant.AllowPublicClients())
+	if err != nil {
+		server.writeError(w, err)
+		return
+	}
+
+	code, err := deviceGrant.CreateDeviceCode(r, clientId)
+	if err != nil {
+		server.writeError(w, err)
+		return
+	}
+
+	api.WriteDeviceAuthorizationResponse(w, code, deviceGrant.VerificationUri())
+}
+
+func (server *OauthServer) HandleDeviceVerificationRequest(w http.ResponseWriter, r *http.Request) {
+	deviceGrant, err := server.getDeviceAuthorizationGrant()
+	if err != nil {
+		server.writeError(w, err)
+		return
+	}
+
+	if err = deviceGrant.VerifyUserCode(r); err != nil {
+		server.writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (server *OauthServer) HandleIntrospectionRequest(w http.ResponseWriter, r *http.Request) {
+	// Only authenticated clients, usually resource servers, may introspect tokens
+	if _, err := server.getClient(r, false); err != nil {
+		server.writeError(w, err)
+		return
+	}
+
+	providedToken := r.FormValue("token")
+	if providedToken == "" {
+		server.writeError(w, oauth2.NewError(oauth2.InvalidRequestErr, "Missing token"))
+		return
+	}
+
+	// Malformed tokens can't be active, no need to look them up
+	if server.verifyToken(providedToken, oauth2.TokenKindAccessToken, oauth2.TokenKindRefreshToken) != nil {
+		api.WriteIntrospectionResponse(w, nil, "")
+		return
+	}
+
+	// The hint only decides the lookup order, unknown hints are ignored
+	if oauth2.TokenTypeHint(r.FormValue("token_type_hint")) == oauth2.TokenTypeHintRefreshToken {
+		if token := server.introspectRefreshToken(r.Context(), providedToken); token != nil {
+			api.WriteIntrospectionResponse(w, token, "")
+			return
+		}
+	}
+
+	if token := server.introspectAccessToken(r.Context(), providedToken); token != nil {
+		api.WriteAccessTokenIntrospectionResponse(w, token)
+		return
+	}
+
+	// Refresh tokens have no token type in the sense of RFC 6749 section 7.1, so it is omitted
+	if token := server.introspectRefreshToken(r.Context(), providedToken); token != nil {
+		api.WriteIntrospectionResponse(w, token, "")
+		return
+	}
+
+	// Unknown, expired or revoked tokens are never an error, they are just not active
+	api.WriteIntrospectionResponse(w, nil, "")
+}
+
+func (server *OauthServer) HandleRevocationRequest(w http.ResponseWriter, r *http.Request) {
+	clientId, err := server.getClient(r, true)
+	if err != nil {
+		server.writeError(w, err)
+		return
+	}
+
+	providedToken := r.FormValue("token")
+	if providedToken == "" {
+		server.writeError(w, oauth2.NewError(oauth2.InvalidRequestErr, "Missing token"))
+		return
+	}
+
+	// Malformed tokens can't have been issued, there is nothing to revoke
+	if server.verifyToken(providedToken, oauth2.TokenKindAccessToken, oauth2.TokenKindRefreshToken) != nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// The hint only decides the lookup order, unknown hints are ignored
+	revoked := false
+	if oauth2.TokenTypeHint(r.FormValue("token_type_hint")) == oauth2.TokenTypeHintRefreshToken {
+		if revoked, err = server.revokeRefreshToken(r.Context(), clientId, providedToken); err != nil {
+			server.writeError(w, err)
+			return
+		}
+	}
+
+	if !revoked {
+		if revoked, err = server.revokeAccessToken(r.Context(), clientId, providedToken); err != nil {
+			server.writeError(w, err)
+			return
+		}
+	}
+
+	if !revoked {
+		if _, err = server.revokeRefreshToken(r.Context(), clientId, providedToken); err != nil {
+			server.writeError(w, err)
+			return
+		}
+	}
+
+	// Invalid tokens do not cause an error response since the client can't handle it anyway
+	w.WriteHeader(http.StatusOK)
+}
+
+// Revoke the access token, returns false if the token was not found
+func (server *OauthServer) revokeAccessToken(ctx context.Context, clientId, providedToken string) (bool, error) {
+	accessToken, err := server.tokenRepository.GetAccessTokenContext(ctx, providedToken)
+	if err == oauth2.AccessTokenNotFoundErr || (err == nil && accessToken == nil) {
+		return false, nil
+	} else if err != nil {
+		return false, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	if accessToken.ClientId != clientId {
+		return false, oauth2.NewError(oauth2.UnauthorizedClientErr, "Token was not issued to the client")
+	}
+
+	if err = server.tokenRepository.DeleteAccessTokenContext(ctx, accessToken.Token); err != nil {
+		return false, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	return true, nil
+}
+
+// Revoke the refresh token together with the access tokens issued from it,
+// returns false if the token was not found
+func (server *OauthServer) revokeRefreshToken(ctx context.Context, clientId, providedToken string) (bool, error) {
+	refreshToken, err := server.tokenRepository.GetRefreshTokenContext(ctx, providedToken)
+	if err == oauth2.RefreshTokenNotFoundErr || (err == nil && refreshToken == nil) {
+		return false, nil
+	} else if err != nil {
+		return false, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	if refreshToken.ClientId != clientId {
+		return false, oauth2.NewError(oauth2.UnauthorizedClientErr, "Token was not issued to the client")
+	}
+
+	if refreshToken.FamilyId != "" {
+		err = server.tokenRepository.DeleteTokenFamilyContext(ctx, refreshToken.FamilyId)
+	} else {
+		err = server.revokeTokensWithoutFamily(ctx, refreshToken)
+	}
+
+	if err != nil {
+		return false, oauth2.NewError(oauth2.ServerErrorErr, err.Error())
+	}
+
+	return true, nil
+}
+
+// Tokens issued before families existed are not linked to their access tokens, so all access
+// tokens of the client and owner without a family are revoked with the refresh token
+func (server *OauthServer) revokeTokensWithoutFamily(ctx context.Context, refreshToken *oauth2.RefreshToken) error {
+	err := server.tokenRepository.DeleteAccessTokensWithoutFamilyContext(ctx, refreshToken.ClientId, refreshToken.OwnerId)
+	if err != nil {
+		return err
+	}
+
+	return server.tokenRepository.DeleteRefreshTokenContext(ctx, refreshToken.Token)
+}
+
+// Get the access token if it is active
+func (server *OauthServer) introspectAccessToken(ctx context.Context, providedToken string) *oauth2.AccessToken {
+	accessToken, err := server.tokenRepository.GetAccessTokenContext(ctx, providedToken)
+	if err != nil || accessToken == nil || accessToken.IsExpired() {
+		return nil
+	}
+
+	return accessToken
+}
+
+// Get the refresh token if it is active
+func (server *OauthServer) introspectRefreshToken(ctx context.Context, providedToken string) *oauth2.OauthToken {
+	refreshToken, err := server.tokenRepository.GetRefreshTokenContext(ctx, providedToken)
+	if err != nil || refreshToken == nil || refreshToken.IsExpired() || refreshToken.IsRotated() {
+		return nil
+	}
+
+	return refreshToken.OauthToken
+}
+
+// Pass the context of the request on to grants that are context aware
+func createTokens(oauthGrant oauth2.OauthGrant, r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	if contextGrant, ok := oauthGrant.(oauth2.ContextOauthGrant); ok {
+		return contextGrant.CreateTokensContext(r.Context(), r, clientId)
+	}
+
+	return oauthGrant.CreateTokens(r, clientId)
+}
+
+// Token parameters of each grant and the kinds of token they may contain
+var tokenParameters = map[oauth2.GrantType]map[string][]oauth2.TokenKind{
+	oauth2.GrantTypeRefreshToken: {
+		"refresh_token": {oauth2.TokenKindRefreshToken},
+	},
+	oauth2.GrantTypeAuthorizationCode: {
+		"code": {oauth2.TokenKindAuthorizationCode},
+	},
+	oauth2.GrantTypeDeviceCode: {
+		"device_code": {oauth2.TokenKindDeviceCode},
+	},
+	oauth2.GrantTypeTokenExchange: {
+		"subject_token": {oauth2.TokenKindAccessToken, oauth2.TokenKindRefreshToken},
+		"actor_token":   {oauth2.TokenKindAccessToken, oauth2.TokenKindRefreshToken},
+	},
+}
+
+// Reject tokens with a bad format or checksum before the grant looks them up
+func (server *OauthServer) verifyTokenParameters(r *http.Request, grantType oauth2.GrantType) error {
+	for parameter, kinds := range tokenParameters[grantType] {
+		providedToken := r.FormValue(parameter)
+		if providedToken == "" {
+			continue
+		}
+
+		if err := server.verifyToken(providedToken, kinds...); err != nil {
+			return oauth2.NewError(oauth2.InvalidGrantErr, fmt.Sprintf("Malformed %s", parameter))
+		}
+	}
+
+	return nil
+}
+
+// Verify the token is any of the kinds if the token generator supports verification
+func (server *OauthServer) verifyToken(token string, kinds ...oauth2.TokenKind) error {
+	verifier, ok := server.Config.TokenGenerator.(oauth2.TokenVerifier)
+	if !ok {
+		return nil
+	}
+
+	var err error
+	for _, kind := range kinds {
+		// Signed access tokens are JWTs and do not use the generated format
+		if kind == oauth2.TokenKindAccessToken && server.Config.AccessTokenSigner != nil {
+			return nil
+		}
+
+		if err = verifier.VerifyToken(kind, token); err == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+func (server *OauthServer) HandleJwksRequest(w http.ResponseWriter, r *http.Request) {
+	keys := jwt.KeySet{}
+
+	if server.Config.AccessTokenKeys != nil {
+		var err error
+
+		if keys, err = server.Config.AccessTokenKeys.Keys(); err != nil {
+			server.writeError(w, err)
+			return
+		}
+	}
+
+	api.WriteJwksResponse(w, keys)
+}
+
+// Get the device authorization grant
+func (server *OauthServer) getDeviceAuthorizationGrant() (oauth2.DeviceAuthorizationGrant, error) {
+	oauthGrant, err := server.getGrant(oauth2.GrantTypeDeviceCode)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceGrant, ok := oauthGrant.(oauth2.DeviceAuthorizationGrant)
+	if !ok {
+		return nil, oauth2.NewError(oauth2.ServerErrorErr, "Device code grant not configured correctly")
+	}
+
+	return deviceGrant, nil
+}
+
 // Get grant by name
 func (server *OauthServer) getGrant(grantType oauth2.GrantType) (oauth2.OauthGrant, error) {
 	if grant, ok := server.Config.Grants[grantType]; ok {
@@ -224,17 +806,89 @@ func (server *OauthServer) getClient(r *http.Request, allowPublicClients bool) (
 	return clientId, nil
 }
 
-func (server *OauthServer) writeError(w http.ResponseWriter, err error) {
+// Get the client and the redirect uri of an authorization request
+func (server *OauthServer) getRedirectUri(r *http.Request) (string, string, error) {
+	clientId := r.FormValue("client_id")
+	redirectUri := r.FormValue("redirect_uri")
+
+	if clientId == "" {
+		return "", "", oauth2.NewError(oauth2.InvalidRequestErr, "Client id is missing")
+	}
+
+	if redirectUri == "" {
+		return "", "", oauth2.NewError(oauth2.InvalidRequestErr, "Redirect uri is missing")
+	}
+
+	// The redirect uri MUST be an absolute uri and MUST NOT include a fragment
+	location, err := url.Parse(redirectUri)
+	if err != nil || !location.IsAbs() || location.Fragment != "" {
+		return "", "", oauth2.NewError(oauth2.InvalidRequestErr, "Redirect uri is invalid")
+	}
+
+	// Codes and tokens are sent to the redirect uri, it MUST never be accepted unvalidated
+	if server.Config.ClientRedirectUriHandler == nil {
+		return "", "", oauth2.NewError(oauth2.ServerErrorErr, "Redirect uri validation not configured correctly")
+	}
+
+	allowed, err := server.Config.ClientRedirectUriHandler(clientId, redirectUri)
+	if err != nil {
+		return "", "", err
+	}
+
+	if !allowed {
+		return "", "", oauth2.NewError(oauth2.InvalidRequestErr, "Redirect uri is not registered for the client")
+	}
+
+	return clientId, redirectUri, nil
+}
+
+// Check that the authorization request provides a code challenge if the configuration requires it
+func (server *OauthServer) checkPkceRequirement(r *http.Request, clientId string) error {
+	if r.FormValue("code_challenge") != "" {
+		return nil
+	}
+
+	switch server.Config.PkceRequirement {
+	case oauth2.PkceRequiredForAllClients:
+		return oauth2.NewError(oauth2.InvalidRequestErr, "Code challenge is required")
+
+	case oauth2.PkceRequiredForPublicClients:
+		isPublic := true
+
+		if server.Config.ClientPublicHandler != nil {
+			var err error
+
+			if isPublic, err = server.Config.ClientPublicHandler(clientId); err != nil {
+				return err
+			}
+		}
+
+		if isPublic {
+			return oauth2.NewError(oauth2.InvalidRequestErr, "Code challenge is required for public clients")
+		}
+	}
+
+	return nil
+}
+
+// Map an error using the configured error map
+func (server *OauthServer) mapError(err error) error {
 	if server.Config.ErrorMap == nil {
-		api.WriteErrorResponse(w, err)
-		return
+		return err
 	}
 
 	oauthError, ok := server.Config.ErrorMap[err]
 	if !ok {
-		api.WriteErrorResponse(w, err)
-		return
+		return err
 	}
 
-	api.WriteErrorResponse(w, oauthError)
+	return oauthError
+}
+
+func (server *OauthServer) writeError(w http.ResponseWriter, err error) {
+	api.WriteErrorResponse(w, server.mapError(err))
+}
+
+func (server *OauthServer) writeErrorRedirect(w http.ResponseWriter, r *http.Request, redirectUri, state string, useFragment bool, err error) {
+	api.WriteErrorRedirect(w, r, redirectUri, state, useFragment, server.mapError(err))
 }
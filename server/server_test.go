@@ -0,0 +1,582 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/jwt"
+	"github.com/interactive-solutions/go-oauth2/keys"
+	"github.com/interactive-solutions/go-oauth2/token/memory"
+)
+
+// Reports the first inserts as duplicates, as if the generated tokens already existed
+type collidingRepository struct {
+	*memory.TokenRepository
+
+	collisions int
+	inserts    int
+}
+
+func (repository *collidingRepository) collide() bool {
+	repository.inserts++
+
+	return repository.inserts <= repository.collisions
+}
+
+func (repository *collidingRepository) CreateAccessToken(accessToken *oauth2.AccessToken) error {
+	if repository.collide() {
+		return oauth2.TokenAlreadyExistsErr
+	}
+
+	return repository.TokenRepository.CreateAccessToken(accessToken)
+}
+
+func (repository *collidingRepository) CreateRefreshToken(refreshToken *oauth2.RefreshToken) error {
+	if repository.collide() {
+		return oauth2.TokenAlreadyExistsErr
+	}
+
+	return repository.TokenRepository.CreateRefreshToken(refreshToken)
+}
+
+func (repository *collidingRepository) CreateAuthorizationCode(code *oauth2.AuthorizationCode) error {
+	if repository.collide() {
+		return oauth2.TokenAlreadyExistsErr
+	}
+
+	return repository.TokenRepository.CreateAuthorizationCode(code)
+}
+
+func TestTokenGenerationRetries(t *testing.T) {
+	kinds := []struct {
+		name   string
+		create func(server *OauthServer) (string, error)
+	}{
+		{
+			name: "access token",
+			create: func(server *OauthServer) (string, error) {
+				accessToken, err := server.CreateAccessToken("client", "owner", time.Hour, nil)
+				if err != nil {
+					return "", err
+				}
+
+				return accessToken.Token, nil
+			},
+		},
+		{
+			name: "refresh token",
+			create: func(server *OauthServer) (string, error) {
+				refreshToken, err := server.CreateRefreshToken("client", "owner", time.Hour, nil)
+				if err != nil {
+					return "", err
+				}
+
+				return refreshToken.Token, nil
+			},
+		},
+		{
+			name: "authorization code",
+			create: func(server *OauthServer) (string, error) {
+				code, err := server.CreateAuthorizationCode("client", "owner", time.Minute, nil, "https://example.com/callback", "", "")
+				if err != nil {
+					return "", err
+				}
+
+				return code.Token, nil
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		retries    int
+		collisions int
+		inserts    int
+		err        bool
+	}{
+		{name: "no collision", retries: 3, collisions: 0, inserts: 1},
+		{name: "collisions within the retries", retries: 3, collisions: 3, inserts: 4},
+		{name: "retries exhausted", retries: 3, collisions: 4, inserts: 4, err: true},
+		{name: "no retries", retries: 0, collisions: 1, inserts: 1, err: true},
+	}
+
+	for _, kind := range kinds {
+		for _, test := range tests {
+			t.Run(kind.name+"/"+test.name, func(t *testing.T) {
+				repository := &collidingRepository{
+					TokenRepository: memory.NewTokenRepository(memory.RepositoryDefaultConfig),
+					collisions:      test.collisions,
+				}
+
+				config := oauth2.ServerDefaultConfig
+				config.TokenGenerationRetries = test.retries
+
+				token, err := kind.create(NewOauthServer(config, repository))
+				if repository.inserts != test.inserts {
+					t.Errorf("Insert was attempted %d times, expected %d", repository.inserts, test.inserts)
+				}
+
+				if !test.err {
+					if err != nil {
+						t.Fatalf("Create failed: %v", err)
+					}
+
+					if token == "" {
+						t.Errorf("No token was generated")
+					}
+
+					return
+				}
+
+				oauthErr, ok := err.(oauth2.OauthError)
+				if !ok || oauthErr.Err != oauth2.ServerErrorErr {
+					t.Fatalf("Expected a %q error, got %v", oauth2.ServerErrorErr, err)
+				}
+			})
+		}
+	}
+}
+
+func TestPrePersistCallbackSeesEveryAttempt(t *testing.T) {
+	repository := &collidingRepository{
+		TokenRepository: memory.NewTokenRepository(memory.RepositoryDefaultConfig),
+		collisions:      2,
+	}
+
+	tokens := make(map[string]bool)
+
+	config := oauth2.ServerDefaultConfig
+	config.CallbackPrePersistAccessToken = func(accessToken *oauth2.AccessToken) error {
+		tokens[accessToken.Token] = true
+		return nil
+	}
+
+	accessToken, err := NewOauthServer(config, repository).CreateAccessToken("client", "owner", time.Hour, nil)
+	if err != nil {
+		t.Fatalf("CreateAccessToken failed: %v", err)
+	}
+
+	// A new token is generated for every attempt and the callback sees the one that is persisted
+	if len(tokens) != 3 || !tokens[accessToken.Token] {
+		t.Errorf("Callback saw %d different tokens, expected 3 including the persisted token", len(tokens))
+	}
+}
+
+func TestAuthorizationRequestRedirectUri(t *testing.T) {
+	allow := func(clientId, redirectUri string) (bool, error) {
+		return redirectUri == "https://example.com/callback", nil
+	}
+
+	tests := []struct {
+		name        string
+		handler     func(clientId, redirectUri string) (bool, error)
+		redirectUri string
+		redirected  bool
+	}{
+		{name: "validation not configured", redirectUri: "https://example.com/callback"},
+		{name: "registered", handler: allow, redirectUri: "https://example.com/callback", redirected: true},
+		{name: "not registered", handler: allow, redirectUri: "https://attacker.example.com/callback"},
+		{name: "relative", handler: allow, redirectUri: "/callback"},
+		{name: "fragment", handler: allow, redirectUri: "https://example.com/callback#fragment"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := oauth2.ServerDefaultConfig
+			config.ClientRedirectUriHandler = test.handler
+
+			server := NewOauthServer(config, memory.NewTokenRepository(memory.RepositoryDefaultConfig))
+
+			values := url.Values{
+				"client_id":     {"client"},
+				"redirect_uri":  {test.redirectUri},
+				"response_type": {"code"},
+			}
+
+			w := httptest.NewRecorder()
+			server.HandleAuthorizationRequest(w, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+values.Encode(), nil))
+
+			// Even errors are sent to a validated redirect uri, but never to one that isn't
+			location := w.Header().Get("Location")
+			if test.redirected != (location != "") {
+				t.Fatalf("Redirected to %q, expected redirect to be %v", location, test.redirected)
+			}
+
+			if test.redirected && !strings.HasPrefix(location, test.redirectUri) {
+				t.Errorf("Redirected to %q, expected %q", location, test.redirectUri)
+			}
+		})
+	}
+}
+
+func TestRevocationRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		familyId string
+		revoke   string
+		clientId string
+		status   int
+		// Which of the access and refresh token are revoked
+		accessTokenRevoked  bool
+		refreshTokenRevoked bool
+	}{
+		{
+			name:                "refresh token without family",
+			revoke:              "refresh_token",
+			status:              http.StatusOK,
+			accessTokenRevoked:  true,
+			refreshTokenRevoked: true,
+		},
+		{
+			name:                "refresh token with family",
+			familyId:            oauth2.NewTokenFamilyId(),
+			revoke:              "refresh_token",
+			status:              http.StatusOK,
+			accessTokenRevoked:  true,
+			refreshTokenRevoked: true,
+		},
+		{
+			name:               "access token",
+			familyId:           oauth2.NewTokenFamilyId(),
+			revoke:             "access_token",
+			status:             http.StatusOK,
+			accessTokenRevoked: true,
+		},
+		{name: "unknown token", revoke: "unknown", status: http.StatusOK},
+		{name: "malformed token", revoke: "malformed", status: http.StatusOK},
+		{name: "token of another client", revoke: "refresh_token", clientId: "other", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := memory.NewTokenRepository(memory.RepositoryDefaultConfig)
+			server := NewDefaultOauthServer(repository)
+			options := oauth2.TokenOptions{FamilyId: test.familyId}
+
+			accessToken, err := server.CreateAccessTokenContext(context.Background(), "client", "owner", time.Hour, nil, options)
+			if err != nil {
+				t.Fatalf("CreateAccessToken failed: %v", err)
+			}
+
+			refreshToken, err := server.CreateRefreshTokenContext(context.Background(), "client", "owner", time.Hour, nil, options)
+			if err != nil {
+				t.Fatalf("CreateRefreshToken failed: %v", err)
+			}
+
+			unknownToken, err := server.GenerateToken(oauth2.TokenKindRefreshToken)
+			if err != nil {
+				t.Fatalf("GenerateToken failed: %v", err)
+			}
+
+			tokens := map[string]string{
+				"access_token":  accessToken.Token,
+				"refresh_token": refreshToken.Token,
+				"unknown":       unknownToken,
+				"malformed":     "malformed",
+			}
+
+			clientId := test.clientId
+			if clientId == "" {
+				clientId = "client"
+			}
+
+			values := url.Values{"token": {tokens[test.revoke]}, "client_id": {clientId}}
+			r := httptest.NewRequest(http.MethodPost, "/oauth/revoke", strings.NewReader(values.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			w := httptest.NewRecorder()
+			server.HandleRevocationRequest(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("Status is %d, expected %d: %s", w.Code, test.status, w.Body.String())
+			}
+
+			_, err = repository.GetAccessToken(accessToken.Token)
+			if test.accessTokenRevoked != (err == oauth2.AccessTokenNotFoundErr) {
+				t.Errorf("Access token lookup returned %v, expected revoked to be %v", err, test.accessTokenRevoked)
+			}
+
+			_, err = repository.GetRefreshToken(refreshToken.Token)
+			if test.refreshTokenRevoked != (err == oauth2.RefreshTokenNotFoundErr) {
+				t.Errorf("Refresh token lookup returned %v, expected revoked to be %v", err, test.refreshTokenRevoked)
+			}
+		})
+	}
+}
+
+func TestJwksRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm jwt.Algorithm
+		published int
+	}{
+		{name: "no keys"},
+		{name: "asymmetric keys", algorithm: jwt.ES256, published: 2},
+		{name: "symmetric keys", algorithm: jwt.HS256},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := oauth2.ServerDefaultConfig
+
+			if test.algorithm != "" {
+				managerConfig := keys.ManagerDefaultConfig
+				managerConfig.Algorithm = test.algorithm
+
+				manager, err := keys.NewManager(keys.NewMemoryStore(), managerConfig)
+				if err != nil {
+					t.Fatalf("NewManager failed: %v", err)
+				}
+
+				config.AccessTokenSigner = manager
+				config.AccessTokenKeys = manager
+			}
+
+			server := NewOauthServer(config, memory.NewTokenRepository(memory.RepositoryDefaultConfig))
+
+			w := httptest.NewRecorder()
+			server.HandleJwksRequest(w, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Status is %d, expected %d", w.Code, http.StatusOK)
+			}
+
+			published := jwt.KeySet{}
+			if err := json.Unmarshal(w.Body.Bytes(), &published); err != nil {
+				t.Fatalf("Unmarshal of %s failed: %v", w.Body.String(), err)
+			}
+
+			if len(published) != test.published {
+				t.Fatalf("%d keys were published, expected %d", len(published), test.published)
+			}
+
+			if test.published == 0 {
+				return
+			}
+
+			// Resource servers verify the access tokens with the published keys
+			accessToken, err := server.CreateAccessToken("client", "owner", time.Hour, nil)
+			if err != nil {
+				t.Fatalf("CreateAccessToken failed: %v", err)
+			}
+
+			token, err := jwt.Parse(accessToken.Token)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			if err = token.Verify(published); err != nil {
+				t.Errorf("Verify with the published keys failed: %v", err)
+			}
+		})
+	}
+}
+
+// Records the tokens it is asked to redeem, only checks that the server rejects malformed tokens
+type recordingGrant struct {
+	redeemed []string
+}
+
+func (grant *recordingGrant) CreateAuthorizationCode(r *http.Request, clientId string) (*oauth2.AuthorizationCode, error) {
+	return nil, oauth2.NewError(oauth2.InvalidRequestErr, "Not supported")
+}
+
+func (grant *recordingGrant) CreateTokens(r *http.Request, clientId string) (*oauth2.AccessToken, *oauth2.RefreshToken, oauth2.TokenMeta, error) {
+	grant.redeemed = append(grant.redeemed, r.FormValue("refresh_token"))
+
+	return oauth2.NewAccessToken(clientId, "owner", time.Hour, nil), nil, nil, nil
+}
+
+func (grant *recordingGrant) AllowPublicClients() bool {
+	return true
+}
+
+func TestTokenRequestRejectsMalformedTokens(t *testing.T) {
+	generator := oauth2.NewChecksumTokenGenerator(oauth2.ChecksumTokenGeneratorDefaultConfig)
+
+	refreshToken, err := generator.GenerateToken(oauth2.TokenKindRefreshToken)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	accessToken, err := generator.GenerateToken(oauth2.TokenKindAccessToken)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	// Change the last character of the checksum
+	wrongChecksum := refreshToken[:len(refreshToken)-1] + "0"
+	if wrongChecksum == refreshToken {
+		wrongChecksum = refreshToken[:len(refreshToken)-1] + "1"
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		redeemed bool
+	}{
+		{name: "valid", token: refreshToken, redeemed: true},
+		{name: "wrong checksum", token: wrongChecksum},
+		{name: "truncated", token: refreshToken[:20]},
+		{name: "unknown prefix", token: "xyz_" + refreshToken[4:]},
+		{name: "another kind", token: accessToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			grant := &recordingGrant{}
+
+			config := oauth2.ServerDefaultConfig
+			config.TokenGenerator = generator
+			config.Grants = map[oauth2.GrantType]oauth2.OauthGrant{oauth2.GrantTypeRefreshToken: grant}
+
+			server := NewOauthServer(config, memory.NewTokenRepository(memory.RepositoryDefaultConfig))
+
+			values := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {test.token}, "client_id": {"client"}}
+			r := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(values.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			w := httptest.NewRecorder()
+			server.HandleTokenRequest(w, r)
+
+			if test.redeemed != (len(grant.redeemed) > 0) {
+				t.Fatalf("Grant redeemed %v, expected the token to be redeemed: %v", grant.redeemed, test.redeemed)
+			}
+
+			if !test.redeemed && (w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), oauth2.InvalidGrantErr)) {
+				t.Errorf("Response is %d %s, expected an %q error", w.Code, w.Body.String(), oauth2.InvalidGrantErr)
+			}
+		})
+	}
+}
+
+func TestIntrospectionRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		introspect string
+		hint       string
+		secret     string
+		status     int
+		// Expected introspection response, nil for an error response
+		response map[string]interface{}
+	}{
+		{
+			name:       "access token",
+			introspect: "access_token",
+			secret:     "secret",
+			status:     http.StatusOK,
+			response: map[string]interface{}{
+				"active":     true,
+				"scope":      "read",
+				"client_id":  "client",
+				"sub":        "owner",
+				"token_type": "Bearer",
+				"aud":        "https://api.example.com",
+				"act":        map[string]interface{}{"sub": "actor"},
+			},
+		},
+		{
+			name:       "refresh token",
+			introspect: "refresh_token",
+			secret:     "secret",
+			status:     http.StatusOK,
+			response:   map[string]interface{}{"active": true, "scope": "read", "client_id": "client", "sub": "owner"},
+		},
+		{
+			name:       "refresh token with hint",
+			introspect: "refresh_token",
+			hint:       "refresh_token",
+			secret:     "secret",
+			status:     http.StatusOK,
+			response:   map[string]interface{}{"active": true, "scope": "read", "client_id": "client", "sub": "owner"},
+		},
+		{name: "expired access token", introspect: "expired", secret: "secret", status: http.StatusOK, response: map[string]interface{}{"active": false}},
+		{name: "unknown token", introspect: "unknown", secret: "secret", status: http.StatusOK, response: map[string]interface{}{"active": false}},
+		{name: "no client secret", introspect: "access_token", status: http.StatusBadRequest},
+		{name: "wrong client secret", introspect: "access_token", secret: "wrong", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := oauth2.ServerDefaultConfig
+			config.ClientAuthorizedHandler = func(clientId, clientSecret string) (bool, error) {
+				return clientId == "resource-server" && clientSecret == "secret", nil
+			}
+
+			server := NewOauthServer(config, memory.NewTokenRepository(memory.RepositoryDefaultConfig))
+			options := oauth2.TokenOptions{FamilyId: oauth2.NewTokenFamilyId(), Audience: []string{"https://api.example.com"}, Actor: "actor"}
+
+			accessToken, err := server.CreateAccessTokenContext(context.Background(), "client", "owner", time.Hour, []string{"read"}, options)
+			if err != nil {
+				t.Fatalf("CreateAccessToken failed: %v", err)
+			}
+
+			refreshToken, err := server.CreateRefreshTokenContext(context.Background(), "client", "owner", time.Hour, []string{"read"}, oauth2.TokenOptions{})
+			if err != nil {
+				t.Fatalf("CreateRefreshToken failed: %v", err)
+			}
+
+			expired, err := server.CreateAccessToken("client", "owner", -time.Hour, []string{"read"})
+			if err != nil {
+				t.Fatalf("CreateAccessToken failed: %v", err)
+			}
+
+			tokens := map[string]*oauth2.OauthToken{
+				"access_token":  accessToken.OauthToken,
+				"refresh_token": refreshToken.OauthToken,
+				"expired":       expired.OauthToken,
+				"unknown":       {Token: "unknown"},
+			}
+
+			values := url.Values{"token": {tokens[test.introspect].Token}}
+			if test.hint != "" {
+				values.Set("token_type_hint", test.hint)
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/oauth/introspect", strings.NewReader(values.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.secret != "" {
+				r.SetBasicAuth("resource-server", test.secret)
+			}
+
+			w := httptest.NewRecorder()
+			server.HandleIntrospectionRequest(w, r)
+
+			if w.Code != test.status {
+				t.Fatalf("Status is %d, expected %d: %s", w.Code, test.status, w.Body.String())
+			}
+
+			// Only authenticated clients may introspect tokens
+			if test.response == nil {
+				if !strings.Contains(w.Body.String(), oauth2.InvalidClientErr) {
+					t.Errorf("Response is %s, expected an %q error", w.Body.String(), oauth2.InvalidClientErr)
+				}
+
+				return
+			}
+
+			response := make(map[string]interface{})
+			if err = json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Unmarshal of %s failed: %v", w.Body.String(), err)
+			}
+
+			if response["active"] == true {
+				test.response["exp"] = float64(tokens[test.introspect].ExpiresAt.Unix())
+			}
+
+			expected, _ := json.Marshal(test.response)
+			actual, _ := json.Marshal(response)
+
+			if string(actual) != string(expected) {
+				t.Errorf("Response is %s, expected %s", actual, expected)
+			}
+		})
+	}
+}
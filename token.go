@@ -10,6 +10,22 @@ const (
 	TokenTypeBearer TokenType = "Bearer"
 )
 
+// Token type hints used by introspection and revocation requests
+type TokenTypeHint string
+
+const (
+	TokenTypeHintAccessToken  TokenTypeHint = "access_token"
+	TokenTypeHintRefreshToken TokenTypeHint = "refresh_token"
+)
+
+// Token type identifiers from RFC 8693
+type TokenTypeIdentifier string
+
+const (
+	TokenTypeIdentifierAccessToken  TokenTypeIdentifier = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeIdentifierRefreshToken TokenTypeIdentifier = "urn:ietf:params:oauth:token-type:refresh_token"
+)
+
 type OauthTokenOwnerId string
 
 type OauthToken struct {
@@ -18,18 +34,36 @@ type OauthToken struct {
 	Scopes    []string `pg:",array"`
 	ClientId  string
 	OwnerId   OauthTokenOwnerId
+
+	// Tokens issued by the same grant, including all rotated refresh tokens, share a family
+	FamilyId string
+
+	// When the owner originally authenticated, carried over to refreshed tokens
+	AuthenticatedAt time.Time
+}
+
+// Creates an id for a new token family
+func NewTokenFamilyId() string {
+	return GenerateRandomString(32)
 }
 
-// Creates an abstract oauth token, SHOULD ONLY be called when creating another token
-func newOauthToken(clientId string, ownerId OauthTokenOwnerId, duration time.Duration, scopes []string) *OauthToken {
-	oauthToken := GenerateRandomString(32)
+// Creates an abstract oauth token, SHOULD ONLY be called when creating another token. The token
+// is generated by DefaultTokenGenerator, the server replaces it with one from its own generator
+func newOauthToken(kind TokenKind, clientId string, ownerId OauthTokenOwnerId, duration time.Duration, scopes []string) *OauthToken {
+	token, err := DefaultTokenGenerator.GenerateToken(kind)
+	if err != nil {
+		panic(err)
+	}
+
+	now := time.Now()
 
 	return &OauthToken{
-		Token:     oauthToken,
-		ClientId:  clientId,
-		OwnerId:   ownerId,
-		ExpiresAt: time.Now().Add(duration),
-		Scopes:    scopes,
+		Token:           token,
+		ClientId:        clientId,
+		OwnerId:         ownerId,
+		ExpiresAt:       now.Add(duration),
+		Scopes:          scopes,
+		AuthenticatedAt: now,
 	}
 }
 
@@ -78,6 +112,11 @@ type AccessToken struct {
 	// Postgres
 	TableName struct{} `sql:"oauth_access_tokens"`
 
+	// Audiences the token is restricted to, set by token exchange
+	Audience []string `pg:",array"`
+	// Subject of the party acting on behalf of the owner, set by token exchange
+	Actor string
+
 	Meta TokenMeta `sql:"-"`
 }
 
@@ -87,7 +126,27 @@ func NewAccessToken(
 	duration time.Duration,
 	scopes []string,
 ) *AccessToken {
-	return &AccessToken{OauthToken: newOauthToken(clientId, ownerId, duration, scopes)}
+	return &AccessToken{OauthToken: newOauthToken(TokenKindAccessToken, clientId, ownerId, duration, scopes)}
+}
+
+// Check that the token is allowed to be used for every audience
+func (token *AccessToken) MatchAudience(audience []string) bool {
+	for _, requestedAudience := range audience {
+		audienceExists := false
+
+		for _, tokenAudience := range token.Audience {
+			if requestedAudience == tokenAudience {
+				audienceExists = true
+				break
+			}
+		}
+
+		if !audienceExists {
+			return false
+		}
+	}
+
+	return true
 }
 
 type RefreshToken struct {
@@ -95,6 +154,17 @@ type RefreshToken struct {
 
 	// Postgres
 	TableName struct{} `sql:"oauth_refresh_tokens"`
+
+	// Set when the token has been rotated, using it again is treated as theft
+	RotatedAt time.Time
+	// The refresh token this token was rotated to, following it gives the lineage of a family
+	RotatedTo string
+	// The access token issued together with the successor, returned again during the grace period
+	RotatedToAccessToken string
+}
+
+func (token *RefreshToken) IsRotated() bool {
+	return !token.RotatedAt.IsZero()
 }
 
 func NewRefreshToken(
@@ -103,16 +173,20 @@ func NewRefreshToken(
 	duration time.Duration,
 	scopes []string,
 ) *RefreshToken {
-	return &RefreshToken{OauthToken: newOauthToken(clientId, ownerId, duration, scopes)}
+	return &RefreshToken{OauthToken: newOauthToken(TokenKindRefreshToken, clientId, ownerId, duration, scopes)}
 }
 
 type AuthorizationCode struct {
 	*OauthToken
 
 	// Postgres
-	TableName struct{} `json:"oauth_authorization_codes"`
+	TableName struct{} `sql:"oauth_authorization_codes"`
 
 	RedirectUri string
+
+	// PKCE
+	CodeChallenge       string
+	CodeChallengeMethod CodeChallengeMethod
 }
 
 func NewAuthorizationCode(
@@ -122,20 +196,47 @@ func NewAuthorizationCode(
 	scopes []string,
 	redirectUri string,
 ) *AuthorizationCode {
-	return &AuthorizationCode{OauthToken: newOauthToken(clientId, ownerId, duration, scopes), RedirectUri: redirectUri}
+	return &AuthorizationCode{OauthToken: newOauthToken(TokenKindAuthorizationCode, clientId, ownerId, duration, scopes), RedirectUri: redirectUri}
 }
 
 type TokenRepository interface {
+	// The create methods MUST return TokenAlreadyExistsErr if the token already exists, the server
+	// relies on a single atomic insert to detect collisions and generates a new token on this error
 	CreateAccessToken(token *AccessToken) error
 	CreateRefreshToken(token *RefreshToken) error
 
+	CreateAuthorizationCode(code *AuthorizationCode) error
+
 	GetAccessToken(token string) (*AccessToken, error)
 	GetRefreshToken(token string) (*RefreshToken, error)
+	GetAuthorizationCode(code string) (*AuthorizationCode, error)
 
 	DeleteAccessToken(token string) error
 	DeleteRefreshToken(token string) error
+	// DeleteAuthorizationCode MUST return AuthorizationCodeNotFoundErr if no code was deleted,
+	// this is what guarantees that an authorization code can only be exchanged once
+	DeleteAuthorizationCode(code string) error
 	DeleteExpiredAccessTokens() error
 	DeleteExpiredRefreshTokens() error
+	DeleteExpiredAuthorizationCodes() error
+
+	// RotateRefreshToken persists RotatedAt, RotatedTo and RotatedToAccessToken of the token, and
+	// FamilyId if the stored token has no family yet. It MUST return RefreshTokenAlreadyRotatedErr
+	// if the token has already been rotated
+	RotateRefreshToken(token *RefreshToken) error
+	// UpdateRefreshTokenExpiresAt moves the expiry of the refresh token
+	UpdateRefreshTokenExpiresAt(token string, expiresAt time.Time) error
+	// DeleteTokenFamily deletes all access and refresh tokens of the family
+	DeleteTokenFamily(familyId string) error
+	// DeleteAccessTokensWithoutFamily deletes the access tokens of the client and owner that have no
+	// family, i.e. were issued before token families existed, to revoke them with their refresh token
+	DeleteAccessTokensWithoutFamily(clientId string, ownerId OauthTokenOwnerId) error
 }
 
 type TokenMeta interface{}
+
+// TokenExchangeMeta is returned as meta by the token exchange grant,
+// the issued token type is written at the top level of the token response
+type TokenExchangeMeta struct {
+	IssuedTokenType TokenTypeIdentifier
+}
@@ -0,0 +1,382 @@
+package bolt
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// Buckets of a token type, tokens are indexed by expiry and family
+type buckets struct {
+	tokens []byte
+	expiry []byte
+	family []byte
+}
+
+var (
+	accessTokenBuckets = buckets{
+		tokens: []byte("oauth_access_tokens"),
+		expiry: []byte("oauth_access_tokens_expiry"),
+		family: []byte("oauth_access_tokens_family"),
+	}
+	refreshTokenBuckets = buckets{
+		tokens: []byte("oauth_refresh_tokens"),
+		expiry: []byte("oauth_refresh_tokens_expiry"),
+		family: []byte("oauth_refresh_tokens_family"),
+	}
+	authorizationCodeBuckets = buckets{
+		tokens: []byte("oauth_authorization_codes"),
+		expiry: []byte("oauth_authorization_codes_expiry"),
+		family: []byte("oauth_authorization_codes_family"),
+	}
+)
+
+// TokenRepository stores tokens in an embedded bbolt database
+type TokenRepository struct {
+	db *bbolt.DB
+}
+
+// NewTokenRepository creates the buckets of the repository unless they already exist
+func NewTokenRepository(db *bbolt.DB) (*TokenRepository, error) {
+	if db == nil {
+		panic("No database given to bolt token repository")
+	}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range []buckets{accessTokenBuckets, refreshTokenBuckets, authorizationCodeBuckets} {
+			for _, name := range [][]byte{b.tokens, b.expiry, b.family} {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenRepository{db: db}, nil
+}
+
+// Backup writes a consistent copy of the database, e.g. to an http.ResponseWriter
+func (repository *TokenRepository) Backup(w io.Writer) (int64, error) {
+	var written int64
+
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		written, err = tx.WriteTo(w)
+
+		return err
+	})
+
+	return written, err
+}
+
+// Snapshot writes a consistent copy of the database to the file, which can be opened with bbolt.Open
+func (repository *TokenRepository) Snapshot(path string, mode os.FileMode) error {
+	return repository.db.View(func(tx *bbolt.Tx) error {
+		return tx.CopyFile(path, mode)
+	})
+}
+
+func (repository *TokenRepository) CreateAccessToken(token *oauth2.AccessToken) error {
+	r := newRecord(token.OauthToken)
+	r.Audience = token.Audience
+	r.Actor = token.Actor
+
+	return repository.create(accessTokenBuckets, r)
+}
+
+func (repository *TokenRepository) CreateRefreshToken(token *oauth2.RefreshToken) error {
+	r := newRecord(token.OauthToken)
+	r.RotatedAt = token.RotatedAt
+	r.RotatedTo = token.RotatedTo
+	r.RotatedToAccessToken = token.RotatedToAccessToken
+
+	return repository.create(refreshTokenBuckets, r)
+}
+
+func (repository *TokenRepository) CreateAuthorizationCode(code *oauth2.AuthorizationCode) error {
+	r := newRecord(code.OauthToken)
+	r.RedirectUri = code.RedirectUri
+	r.CodeChallenge = code.CodeChallenge
+	r.CodeChallengeMethod = code.CodeChallengeMethod
+
+	return repository.create(authorizationCodeBuckets, r)
+}
+
+func (repository *TokenRepository) create(b buckets, r *record) error {
+	return repository.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(b.tokens).Get([]byte(r.Token)) != nil {
+			return oauth2.TokenAlreadyExistsErr
+		}
+
+		return put(tx, b, r)
+	})
+}
+
+// Store the record and its index entries
+func put(tx *bbolt.Tx, b buckets, r *record) error {
+	value, err := r.encode()
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Bucket(b.tokens).Put([]byte(r.Token), value); err != nil {
+		return err
+	}
+
+	if err = tx.Bucket(b.expiry).Put(expiryKey(r.ExpiresAt, r.Token), nil); err != nil {
+		return err
+	}
+
+	if r.FamilyId == "" {
+		return nil
+	}
+
+	return tx.Bucket(b.family).Put(familyKey(r.FamilyId, r.Token), nil)
+}
+
+func get(tx *bbolt.Tx, b buckets, token string) (*record, error) {
+	value := tx.Bucket(b.tokens).Get([]byte(token))
+	if value == nil {
+		return nil, nil
+	}
+
+	return decodeRecord(value)
+}
+
+// Delete the record and its index entries, reports whether the token existed
+func remove(tx *bbolt.Tx, b buckets, token string) (bool, error) {
+	r, err := get(tx, b, token)
+	if err != nil || r == nil {
+		return false, err
+	}
+
+	if err = tx.Bucket(b.tokens).Delete([]byte(token)); err != nil {
+		return false, err
+	}
+
+	if err = tx.Bucket(b.expiry).Delete(expiryKey(r.ExpiresAt, token)); err != nil {
+		return false, err
+	}
+
+	if r.FamilyId != "" {
+		if err = tx.Bucket(b.family).Delete(familyKey(r.FamilyId, token)); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (repository *TokenRepository) view(b buckets, token string) (*record, error) {
+	var r *record
+
+	err := repository.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		r, err = get(tx, b, token)
+
+		return err
+	})
+
+	return r, err
+}
+
+func (repository *TokenRepository) GetAccessToken(token string) (*oauth2.AccessToken, error) {
+	r, err := repository.view(accessTokenBuckets, token)
+	if err != nil {
+		return nil, err
+	} else if r == nil {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	return r.accessToken(), nil
+}
+
+func (repository *TokenRepository) GetRefreshToken(token string) (*oauth2.RefreshToken, error) {
+	r, err := repository.view(refreshTokenBuckets, token)
+	if err != nil {
+		return nil, err
+	} else if r == nil {
+		return nil, oauth2.RefreshTokenNotFoundErr
+	}
+
+	return r.refreshToken(), nil
+}
+
+func (repository *TokenRepository) GetAuthorizationCode(code string) (*oauth2.AuthorizationCode, error) {
+	r, err := repository.view(authorizationCodeBuckets, code)
+	if err != nil {
+		return nil, err
+	} else if r == nil {
+		return nil, oauth2.AuthorizationCodeNotFoundErr
+	}
+
+	return r.authorizationCode(), nil
+}
+
+func (repository *TokenRepository) DeleteAccessToken(token string) error {
+	return repository.db.Update(func(tx *bbolt.Tx) error {
+		_, err := remove(tx, accessTokenBuckets, token)
+
+		return err
+	})
+}
+
+func (repository *TokenRepository) DeleteRefreshToken(token string) error {
+	return repository.db.Update(func(tx *bbolt.Tx) error {
+		_, err := remove(tx, refreshTokenBuckets, token)
+
+		return err
+	})
+}
+
+func (repository *TokenRepository) DeleteAuthorizationCode(code string) error {
+	return repository.db.Update(func(tx *bbolt.Tx) error {
+		deleted, err := remove(tx, authorizationCodeBuckets, code)
+		if err != nil {
+			return err
+		}
+
+		// Someone else already consumed the code
+		if !deleted {
+			return oauth2.AuthorizationCodeNotFoundErr
+		}
+
+		return nil
+	})
+}
+
+func (repository *TokenRepository) DeleteExpiredAccessTokens() error {
+	return repository.deleteExpired(accessTokenBuckets)
+}
+
+func (repository *TokenRepository) DeleteExpiredRefreshTokens() error {
+	return repository.deleteExpired(refreshTokenBuckets)
+}
+
+func (repository *TokenRepository) DeleteExpiredAuthorizationCodes() error {
+	return repository.deleteExpired(authorizationCodeBuckets)
+}
+
+// Scan the expiry index from the start until the first unexpired token
+func (repository *TokenRepository) deleteExpired(b buckets) error {
+	return repository.db.Update(func(tx *bbolt.Tx) error {
+		now := expiryKey(time.Now(), "")
+		expired := make([]string, 0)
+
+		cursor := tx.Bucket(b.expiry).Cursor()
+		for key, _ := cursor.First(); key != nil && bytes.Compare(key[:8], now) < 0; key, _ = cursor.Next() {
+			expired = append(expired, string(key[8:]))
+		}
+
+		// Deleting while iterating would skip keys
+		for _, token := range expired {
+			if _, err := remove(tx, b, token); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (repository *TokenRepository) RotateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.db.Update(func(tx *bbolt.Tx) error {
+		r, err := get(tx, refreshTokenBuckets, token.Token)
+		if err != nil {
+			return err
+		}
+
+		if r == nil || !r.RotatedAt.IsZero() {
+			return oauth2.RefreshTokenAlreadyRotatedErr
+		}
+
+		r.RotatedAt = token.RotatedAt
+		r.RotatedTo = token.RotatedTo
+		r.RotatedToAccessToken = token.RotatedToAccessToken
+
+		if r.FamilyId == "" {
+			r.FamilyId = token.FamilyId
+		}
+
+		return put(tx, refreshTokenBuckets, r)
+	})
+}
+
+func (repository *TokenRepository) UpdateRefreshTokenExpiresAt(token string, expiresAt time.Time) error {
+	return repository.db.Update(func(tx *bbolt.Tx) error {
+		r, err := get(tx, refreshTokenBuckets, token)
+		if err != nil || r == nil {
+			return err
+		}
+
+		if err = tx.Bucket(refreshTokenBuckets.expiry).Delete(expiryKey(r.ExpiresAt, token)); err != nil {
+			return err
+		}
+
+		r.ExpiresAt = expiresAt
+
+		return put(tx, refreshTokenBuckets, r)
+	})
+}
+
+func (repository *TokenRepository) DeleteTokenFamily(familyId string) error {
+	return repository.db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range []buckets{accessTokenBuckets, refreshTokenBuckets} {
+			prefix := familyKey(familyId, "")
+			tokens := make([]string, 0)
+
+			cursor := tx.Bucket(b.family).Cursor()
+			for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+				tokens = append(tokens, string(key[len(prefix):]))
+			}
+
+			for _, token := range tokens {
+				if _, err := remove(tx, b, token); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func (repository *TokenRepository) DeleteAccessTokensWithoutFamily(clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	return repository.db.Update(func(tx *bbolt.Tx) error {
+		tokens := make([]string, 0)
+
+		// Tokens without a family are not indexed, they are only left from before families existed
+		err := tx.Bucket(accessTokenBuckets.tokens).ForEach(func(key, value []byte) error {
+			r, err := decodeRecord(value)
+			if err != nil {
+				return err
+			}
+
+			if r.FamilyId == "" && r.ClientId == clientId && r.OwnerId == ownerId {
+				tokens = append(tokens, string(key))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, token := range tokens {
+			if _, err = remove(tx, accessTokenBuckets, token); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,27 @@
+package bolt
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/token/tokentest"
+	bbolt "go.etcd.io/bbolt"
+)
+
+func TestTokenRepository(t *testing.T) {
+	tokentest.TestTokenRepository(t, func(t *testing.T) oauth2.TokenRepository {
+		db, err := bbolt.Open(filepath.Join(t.TempDir(), "tokens.db"), 0600, nil)
+		if err != nil {
+			t.Fatalf("Failed to open database: %v", err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		repository, err := NewTokenRepository(db)
+		if err != nil {
+			t.Fatalf("NewTokenRepository failed: %v", err)
+		}
+
+		return repository
+	})
+}
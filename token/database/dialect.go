@@ -0,0 +1,153 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Dialect contains what differs between databases, no drivers are imported by the dialects
+type Dialect interface {
+	// Rebind replaces ? placeholders with the placeholders of the database
+	Rebind(query string) string
+
+	// Schema returns the statements creating the tables if they do not exist
+	Schema() []string
+
+	// IsDuplicateKeyError reports whether the error is a primary key violation
+	IsDuplicateKeyError(err error) bool
+}
+
+var (
+	Postgres Dialect = &postgresDialect{}
+	MySQL    Dialect = &mysqlDialect{}
+	SQLite   Dialect = &sqliteDialect{}
+)
+
+type postgresDialect struct{}
+
+func (dialect *postgresDialect) Rebind(query string) string {
+	var builder strings.Builder
+
+	n := 0
+	for _, c := range query {
+		if c != '?' {
+			builder.WriteRune(c)
+			continue
+		}
+
+		n++
+		builder.WriteString("$" + strconv.Itoa(n))
+	}
+
+	return builder.String()
+}
+
+func (dialect *postgresDialect) Schema() []string {
+	return schema("TEXT", "TIMESTAMPTZ", "TEXT", "", true)
+}
+
+func (dialect *postgresDialect) IsDuplicateKeyError(err error) bool {
+	// pgx exposes the SQLSTATE, lib/pq includes it in the message
+	if state, ok := err.(interface{ SQLState() string }); ok {
+		return state.SQLState() == "23505"
+	}
+
+	return strings.Contains(err.Error(), "duplicate key value") || strings.Contains(err.Error(), "23505")
+}
+
+type mysqlDialect struct{}
+
+func (dialect *mysqlDialect) Rebind(query string) string {
+	return query
+}
+
+// MySQL has no CREATE INDEX IF NOT EXISTS so the indexes are part of the tables, tokens are ascii
+// so signed JWT access tokens fit within the maximum index length
+func (dialect *mysqlDialect) Schema() []string {
+	return schema("VARCHAR(3072) CHARACTER SET ascii COLLATE ascii_bin", "DATETIME(6)", "TEXT", " ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin", false)
+}
+
+func (dialect *mysqlDialect) IsDuplicateKeyError(err error) bool {
+	return strings.Contains(err.Error(), "Error 1062")
+}
+
+type sqliteDialect struct{}
+
+func (dialect *sqliteDialect) Rebind(query string) string {
+	return query
+}
+
+func (dialect *sqliteDialect) Schema() []string {
+	return schema("TEXT", "DATETIME", "TEXT", "", true)
+}
+
+func (dialect *sqliteDialect) IsDuplicateKeyError(err error) bool {
+	return strings.Contains(err.Error(), "UNIQUE constraint failed") || strings.Contains(err.Error(), "PRIMARY KEY")
+}
+
+// Create statements for all tables, indexes are created separately when the database supports IF NOT EXISTS
+func schema(tokenType, timestampType, textType, tableOptions string, separateIndexes bool) []string {
+	columns := `
+	token ` + tokenType + ` NOT NULL PRIMARY KEY,
+	expires_at ` + timestampType + ` NOT NULL,
+	scopes ` + textType + ` NOT NULL,
+	client_id VARCHAR(255) NOT NULL,
+	owner_id VARCHAR(255) NOT NULL,
+	family_id VARCHAR(255) NOT NULL,
+	authenticated_at ` + timestampType + ` NOT NULL`
+
+	tables := []struct {
+		name    string
+		columns string
+		indexes []string
+	}{
+		{
+			name: "oauth_access_tokens",
+			columns: columns + `,
+	audience ` + textType + ` NOT NULL,
+	actor VARCHAR(255) NOT NULL`,
+			indexes: []string{"expires_at", "family_id"},
+		},
+		{
+			name: "oauth_refresh_tokens",
+			columns: columns + `,
+	rotated_at ` + timestampType + ` NULL,
+	rotated_to ` + tokenType + ` NOT NULL,
+	rotated_to_access_token ` + tokenType + ` NOT NULL`,
+			indexes: []string{"expires_at", "family_id"},
+		},
+		{
+			name: "oauth_authorization_codes",
+			columns: columns + `,
+	redirect_uri ` + textType + ` NOT NULL,
+	code_challenge VARCHAR(255) NOT NULL,
+	code_challenge_method VARCHAR(16) NOT NULL`,
+			indexes: []string{"expires_at"},
+		},
+	}
+
+	statements := make([]string, 0)
+	for _, table := range tables {
+		definition := table.columns
+
+		for _, column := range table.indexes {
+			if separateIndexes {
+				continue
+			}
+
+			definition += ",\n\tINDEX " + table.name + "_" + column + "_idx (" + column + ")"
+		}
+
+		statements = append(statements, "CREATE TABLE IF NOT EXISTS "+table.name+" ("+definition+"\n)"+tableOptions)
+
+		if !separateIndexes {
+			continue
+		}
+
+		for _, column := range table.indexes {
+			statements = append(statements, "CREATE INDEX IF NOT EXISTS "+table.name+"_"+column+"_idx ON "+table.name+" ("+column+")")
+		}
+	}
+
+	return statements
+}
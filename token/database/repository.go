@@ -0,0 +1,410 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+const (
+	oauthTokenColumns = "token, expires_at, scopes, client_id, owner_id, family_id, authenticated_at"
+
+	accessTokenColumns       = oauthTokenColumns + ", audience, actor"
+	refreshTokenColumns      = oauthTokenColumns + ", rotated_at, rotated_to, rotated_to_access_token"
+	authorizationCodeColumns = oauthTokenColumns + ", redirect_uri, code_challenge, code_challenge_method"
+)
+
+// TokenRepository stores tokens with database/sql. Scopes are stored space separated, the same way
+// they are sent in requests, and times in UTC. MySQL connections MUST set parseTime=true.
+type TokenRepository struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func NewTokenRepository(db *sql.DB, dialect Dialect) *TokenRepository {
+	if db == nil {
+		panic("No database given to sql token repository")
+	}
+
+	return &TokenRepository{
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+// CreateSchema creates the token tables and indexes unless they already exist
+func (repository *TokenRepository) CreateSchema() error {
+	for _, statement := range repository.dialect.Schema() {
+		if _, err := repository.db.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (repository *TokenRepository) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return repository.db.ExecContext(ctx, repository.dialect.Rebind(query), args...)
+}
+
+func (repository *TokenRepository) insert(ctx context.Context, query string, args ...interface{}) error {
+	_, err := repository.exec(ctx, query, args...)
+	if err != nil && repository.dialect.IsDuplicateKeyError(err) {
+		return oauth2.TokenAlreadyExistsErr
+	}
+
+	return err
+}
+
+func (repository *TokenRepository) CreateAccessToken(token *oauth2.AccessToken) error {
+	return repository.CreateAccessTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) CreateAccessTokenContext(ctx context.Context, token *oauth2.AccessToken) error {
+	values := append(
+		oauthTokenValues(token.OauthToken),
+		strings.Join(token.Audience, " "),
+		token.Actor,
+	)
+
+	return repository.insert(
+		ctx,
+		"INSERT INTO oauth_access_tokens ("+accessTokenColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		values...,
+	)
+}
+
+func (repository *TokenRepository) CreateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.CreateRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) CreateRefreshTokenContext(ctx context.Context, token *oauth2.RefreshToken) error {
+	values := append(
+		oauthTokenValues(token.OauthToken),
+		nullTime(token.RotatedAt),
+		token.RotatedTo,
+		token.RotatedToAccessToken,
+	)
+
+	return repository.insert(
+		ctx,
+		"INSERT INTO oauth_refresh_tokens ("+refreshTokenColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		values...,
+	)
+}
+
+func (repository *TokenRepository) CreateAuthorizationCode(code *oauth2.AuthorizationCode) error {
+	return repository.CreateAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *TokenRepository) CreateAuthorizationCodeContext(ctx context.Context, code *oauth2.AuthorizationCode) error {
+	values := append(
+		oauthTokenValues(code.OauthToken),
+		code.RedirectUri,
+		code.CodeChallenge,
+		string(code.CodeChallengeMethod),
+	)
+
+	return repository.insert(
+		ctx,
+		"INSERT INTO oauth_authorization_codes ("+authorizationCodeColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		values...,
+	)
+}
+
+func (repository *TokenRepository) GetAccessToken(token string) (*oauth2.AccessToken, error) {
+	return repository.GetAccessTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) GetAccessTokenContext(ctx context.Context, token string) (*oauth2.AccessToken, error) {
+	accessToken := &oauth2.AccessToken{OauthToken: &oauth2.OauthToken{}}
+	s := &scanner{}
+
+	var audience string
+
+	row := repository.db.QueryRowContext(
+		ctx,
+		repository.dialect.Rebind("SELECT "+accessTokenColumns+" FROM oauth_access_tokens WHERE token = ?"),
+		token,
+	)
+
+	dest := append(s.oauthTokenDest(accessToken.OauthToken), &audience, &accessToken.Actor)
+
+	err := row.Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, oauth2.AccessTokenNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	s.finish(accessToken.OauthToken)
+
+	if audience != "" {
+		accessToken.Audience = strings.Split(audience, " ")
+	}
+
+	return accessToken, nil
+}
+
+func (repository *TokenRepository) GetRefreshToken(token string) (*oauth2.RefreshToken, error) {
+	return repository.GetRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) GetRefreshTokenContext(ctx context.Context, token string) (*oauth2.RefreshToken, error) {
+	refreshToken := &oauth2.RefreshToken{OauthToken: &oauth2.OauthToken{}}
+	s := &scanner{}
+
+	var rotatedAt sql.NullTime
+
+	row := repository.db.QueryRowContext(
+		ctx,
+		repository.dialect.Rebind("SELECT "+refreshTokenColumns+" FROM oauth_refresh_tokens WHERE token = ?"),
+		token,
+	)
+
+	dest := append(s.oauthTokenDest(refreshToken.OauthToken), &rotatedAt, &refreshToken.RotatedTo, &refreshToken.RotatedToAccessToken)
+
+	err := row.Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, oauth2.RefreshTokenNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	s.finish(refreshToken.OauthToken)
+	if rotatedAt.Valid {
+		refreshToken.RotatedAt = rotatedAt.Time
+	}
+
+	return refreshToken, nil
+}
+
+func (repository *TokenRepository) GetAuthorizationCode(code string) (*oauth2.AuthorizationCode, error) {
+	return repository.GetAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *TokenRepository) GetAuthorizationCodeContext(ctx context.Context, code string) (*oauth2.AuthorizationCode, error) {
+	authorizationCode := &oauth2.AuthorizationCode{OauthToken: &oauth2.OauthToken{}}
+	s := &scanner{}
+
+	var codeChallengeMethod string
+
+	row := repository.db.QueryRowContext(
+		ctx,
+		repository.dialect.Rebind("SELECT "+authorizationCodeColumns+" FROM oauth_authorization_codes WHERE token = ?"),
+		code,
+	)
+
+	dest := append(s.oauthTokenDest(authorizationCode.OauthToken), &authorizationCode.RedirectUri, &authorizationCode.CodeChallenge, &codeChallengeMethod)
+
+	err := row.Scan(dest...)
+	if err == sql.ErrNoRows {
+		return nil, oauth2.AuthorizationCodeNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	s.finish(authorizationCode.OauthToken)
+	authorizationCode.CodeChallengeMethod = oauth2.CodeChallengeMethod(codeChallengeMethod)
+
+	return authorizationCode, nil
+}
+
+func (repository *TokenRepository) DeleteAccessToken(token string) error {
+	return repository.DeleteAccessTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) DeleteAccessTokenContext(ctx context.Context, token string) error {
+	_, err := repository.exec(ctx, "DELETE FROM oauth_access_tokens WHERE token = ?", token)
+
+	return err
+}
+
+func (repository *TokenRepository) DeleteRefreshToken(token string) error {
+	return repository.DeleteRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) DeleteRefreshTokenContext(ctx context.Context, token string) error {
+	_, err := repository.exec(ctx, "DELETE FROM oauth_refresh_tokens WHERE token = ?", token)
+
+	return err
+}
+
+func (repository *TokenRepository) DeleteAuthorizationCode(code string) error {
+	return repository.DeleteAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *TokenRepository) DeleteAuthorizationCodeContext(ctx context.Context, code string) error {
+	res, err := repository.exec(ctx, "DELETE FROM oauth_authorization_codes WHERE token = ?", code)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// Someone else already consumed the code
+	if affected == 0 {
+		return oauth2.AuthorizationCodeNotFoundErr
+	}
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteExpiredAccessTokens() error {
+	return repository.DeleteExpiredAccessTokensContext(context.Background())
+}
+
+func (repository *TokenRepository) DeleteExpiredAccessTokensContext(ctx context.Context) error {
+	_, err := repository.exec(ctx, "DELETE FROM oauth_access_tokens WHERE expires_at < ?", time.Now().UTC())
+
+	return err
+}
+
+func (repository *TokenRepository) DeleteExpiredRefreshTokens() error {
+	return repository.DeleteExpiredRefreshTokensContext(context.Background())
+}
+
+func (repository *TokenRepository) DeleteExpiredRefreshTokensContext(ctx context.Context) error {
+	_, err := repository.exec(ctx, "DELETE FROM oauth_refresh_tokens WHERE expires_at < ?", time.Now().UTC())
+
+	return err
+}
+
+func (repository *TokenRepository) DeleteExpiredAuthorizationCodes() error {
+	return repository.DeleteExpiredAuthorizationCodesContext(context.Background())
+}
+
+func (repository *TokenRepository) DeleteExpiredAuthorizationCodesContext(ctx context.Context) error {
+	_, err := repository.exec(ctx, "DELETE FROM oauth_authorization_codes WHERE expires_at < ?", time.Now().UTC())
+
+	return err
+}
+
+func (repository *TokenRepository) RotateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.RotateRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *TokenRepository) RotateRefreshTokenContext(ctx context.Context, token *oauth2.RefreshToken) error {
+	// Only update tokens that are not rotated yet so concurrent rotations have a single winner
+	res, err := repository.exec(
+		ctx,
+		"UPDATE oauth_refresh_tokens SET rotated_at = ?, rotated_to = ?, rotated_to_access_token = ?, family_id = COALESCE(NULLIF(family_id, ''), ?) "+
+			"WHERE token = ? AND rotated_at IS NULL",
+		nullTime(token.RotatedAt),
+		token.RotatedTo,
+		token.RotatedToAccessToken,
+		token.FamilyId,
+		token.Token,
+	)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return oauth2.RefreshTokenAlreadyRotatedErr
+	}
+
+	return nil
+}
+
+func (repository *TokenRepository) UpdateRefreshTokenExpiresAt(token string, expiresAt time.Time) error {
+	return repository.UpdateRefreshTokenExpiresAtContext(context.Background(), token, expiresAt)
+}
+
+func (repository *TokenRepository) UpdateRefreshTokenExpiresAtContext(ctx context.Context, token string, expiresAt time.Time) error {
+	_, err := repository.exec(ctx, "UPDATE oauth_refresh_tokens SET expires_at = ? WHERE token = ?", expiresAt.UTC(), token)
+
+	return err
+}
+
+func (repository *TokenRepository) DeleteTokenFamily(familyId string) error {
+	return repository.DeleteTokenFamilyContext(context.Background(), familyId)
+}
+
+func (repository *TokenRepository) DeleteTokenFamilyContext(ctx context.Context, familyId string) error {
+	tx, err := repository.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, repository.dialect.Rebind("DELETE FROM oauth_access_tokens WHERE family_id = ?"), familyId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, repository.dialect.Rebind("DELETE FROM oauth_refresh_tokens WHERE family_id = ?"), familyId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (repository *TokenRepository) DeleteAccessTokensWithoutFamily(clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	return repository.DeleteAccessTokensWithoutFamilyContext(context.Background(), clientId, ownerId)
+}
+
+func (repository *TokenRepository) DeleteAccessTokensWithoutFamilyContext(ctx context.Context, clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	_, err := repository.exec(ctx, "DELETE FROM oauth_access_tokens WHERE client_id = ? AND owner_id = ? AND family_id = ''", clientId, string(ownerId))
+
+	return err
+}
+
+func oauthTokenValues(token *oauth2.OauthToken) []interface{} {
+	return []interface{}{
+		token.Token,
+		token.ExpiresAt.UTC(),
+		strings.Join(token.Scopes, " "),
+		token.ClientId,
+		string(token.OwnerId),
+		token.FamilyId,
+		token.AuthenticatedAt.UTC(),
+	}
+}
+
+// Columns that need converting after scanning
+type scanner struct {
+	scopes  string
+	ownerId string
+}
+
+func (s *scanner) oauthTokenDest(token *oauth2.OauthToken) []interface{} {
+	return []interface{}{
+		&token.Token,
+		&token.ExpiresAt,
+		&s.scopes,
+		&token.ClientId,
+		&s.ownerId,
+		&token.FamilyId,
+		&token.AuthenticatedAt,
+	}
+}
+
+func (s *scanner) finish(token *oauth2.OauthToken) {
+	token.Scopes = []string{}
+	if s.scopes != "" {
+		token.Scopes = strings.Split(s.scopes, " ")
+	}
+
+	token.OwnerId = oauth2.OauthTokenOwnerId(s.ownerId)
+}
+
+// Zero times are stored as NULL
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t.UTC()
+}
@@ -0,0 +1,30 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/token/tokentest"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTokenRepository(t *testing.T) {
+	tokentest.TestTokenRepository(t, func(t *testing.T) oauth2.TokenRepository {
+		db, err := sql.Open("sqlite3", ":memory:")
+		if err != nil {
+			t.Fatalf("Failed to open database: %v", err)
+		}
+
+		// Every connection gets its own in memory database
+		db.SetMaxOpenConns(1)
+		t.Cleanup(func() { db.Close() })
+
+		repository := NewTokenRepository(db, SQLite)
+		if err = repository.CreateSchema(); err != nil {
+			t.Fatalf("CreateSchema failed: %v", err)
+		}
+
+		return repository
+	})
+}
@@ -0,0 +1,103 @@
+package token
+
+import (
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+type deviceCodeRepository struct {
+	db *pg.DB
+}
+
+func NewDeviceCodeRepository(db *pg.DB) oauth2.DeviceCodeRepository {
+	return &deviceCodeRepository{
+		db: db,
+	}
+}
+
+func (repository *deviceCodeRepository) CreateDeviceCode(code *oauth2.DeviceCode) error {
+	return insertError(repository.db.Insert(code))
+}
+
+func (repository *deviceCodeRepository) GetDeviceCode(deviceCode string) (*oauth2.DeviceCode, error) {
+	code := &oauth2.DeviceCode{}
+
+	err := repository.db.Model(code).Where("token = ?", deviceCode).Select()
+	if err == pg.ErrNoRows {
+		return nil, oauth2.DeviceCodeNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	return code, nil
+}
+
+func (repository *deviceCodeRepository) GetDeviceCodeByUserCode(userCode string) (*oauth2.DeviceCode, error) {
+	code := &oauth2.DeviceCode{}
+
+	err := repository.db.Model(code).Where("user_code = ?", userCode).Select()
+	if err == pg.ErrNoRows {
+		return nil, oauth2.DeviceCodeNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	return code, nil
+}
+
+func (repository *deviceCodeRepository) UpdateDeviceCode(code *oauth2.DeviceCode) error {
+	res, err := repository.db.Model(code).
+		Column("status", "owner_id", "authenticated_at").
+		Where("token = ?", code.Token).
+		Where("status = ?", oauth2.DeviceCodeStatusPending).
+		Update()
+	if err != nil {
+		return err
+	}
+
+	// Someone else already approved or denied the code
+	if res.RowsAffected() == 0 {
+		return oauth2.DeviceCodeNotPendingErr
+	}
+
+	return nil
+}
+
+func (repository *deviceCodeRepository) UpdateDeviceCodePolling(deviceCode string, lastPolledAt time.Time, interval time.Duration) error {
+	code := &oauth2.DeviceCode{}
+
+	_, err := repository.db.Model(code).
+		Set("last_polled_at = ?", lastPolledAt).
+		Set("interval = ?", interval).
+		Where("token = ?", deviceCode).
+		Where("status = ?", oauth2.DeviceCodeStatusPending).
+		Update()
+
+	return err
+}
+
+func (repository *deviceCodeRepository) DeleteDeviceCode(deviceCode string) error {
+	code := &oauth2.DeviceCode{}
+
+	res, err := repository.db.Model(code).Where("token = ?", deviceCode).Delete()
+	if err != nil {
+		return err
+	}
+
+	// Someone else already consumed the code
+	if res.RowsAffected() == 0 {
+		return oauth2.DeviceCodeNotFoundErr
+	}
+
+	return nil
+}
+
+func (repository *deviceCodeRepository) DeleteExpiredDeviceCodes() error {
+	code := &oauth2.DeviceCode{}
+
+	_, err := repository.db.Model(code).Where("expires_at < ?", time.Now()).Delete()
+
+	return err
+}
@@ -0,0 +1,35 @@
+package token
+
+import (
+	"time"
+
+	"github.com/go-pg/pg"
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+type jwtIdRepository struct {
+	db *pg.DB
+}
+
+func NewJwtIdRepository(db *pg.DB) oauth2.JwtIdRepository {
+	return &jwtIdRepository{
+		db: db,
+	}
+}
+
+func (repository *jwtIdRepository) CreateJwtId(jwtId *oauth2.JwtId) error {
+	err := repository.db.Insert(jwtId)
+	if isUniqueViolation(err) {
+		return oauth2.JwtIdAlreadyUsedErr
+	}
+
+	return err
+}
+
+func (repository *jwtIdRepository) DeleteExpiredJwtIds() error {
+	jwtId := &oauth2.JwtId{}
+
+	_, err := repository.db.Model(jwtId).Where("expires_at < ?", time.Now()).Delete()
+
+	return err
+}
@@ -0,0 +1,280 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+var RepositoryDefaultConfig = RepositoryConfig{
+	MaxTokens: 0,
+}
+
+type RepositoryConfig struct {
+	// Maximum number of tokens of each type, when full the token closest to expiring is evicted.
+	// Zero means no limit
+	MaxTokens int
+}
+
+// TokenRepository keeps tokens in memory, useful for tests and single node services. Tokens are
+// copied in and out so modifying a returned token does not modify the stored one.
+type TokenRepository struct {
+	config RepositoryConfig
+
+	mutex              sync.RWMutex
+	accessTokens       *store
+	refreshTokens      *store
+	authorizationCodes *store
+}
+
+func NewTokenRepository(config RepositoryConfig) *TokenRepository {
+	return &TokenRepository{
+		config:             config,
+		accessTokens:       newStore(),
+		refreshTokens:      newStore(),
+		authorizationCodes: newStore(),
+	}
+}
+
+// PeriodicallyDeleteExpiredTokens deletes expired tokens until the context is done
+func (repository *TokenRepository) PeriodicallyDeleteExpiredTokens(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			repository.DeleteExpiredAccessTokens()
+			repository.DeleteExpiredRefreshTokens()
+			repository.DeleteExpiredAuthorizationCodes()
+		}
+	}
+}
+
+func (repository *TokenRepository) CreateAccessToken(token *oauth2.AccessToken) error {
+	return repository.insert(repository.accessTokens, token.OauthToken, copyAccessToken(token))
+}
+
+func (repository *TokenRepository) CreateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.insert(repository.refreshTokens, token.OauthToken, copyRefreshToken(token))
+}
+
+func (repository *TokenRepository) CreateAuthorizationCode(code *oauth2.AuthorizationCode) error {
+	return repository.insert(repository.authorizationCodes, code.OauthToken, copyAuthorizationCode(code))
+}
+
+func (repository *TokenRepository) insert(store *store, token *oauth2.OauthToken, value interface{}) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	if _, ok := store.get(token.Token); ok {
+		return oauth2.TokenAlreadyExistsErr
+	}
+
+	if repository.config.MaxTokens > 0 {
+		for store.len() >= repository.config.MaxTokens {
+			store.delete(store.first().token)
+		}
+	}
+
+	store.insert(&entry{
+		token:     token.Token,
+		familyId:  token.FamilyId,
+		expiresAt: token.ExpiresAt,
+		value:     value,
+	})
+
+	return nil
+}
+
+func (repository *TokenRepository) GetAccessToken(token string) (*oauth2.AccessToken, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	e, ok := repository.accessTokens.get(token)
+	if !ok {
+		return nil, oauth2.AccessTokenNotFoundErr
+	}
+
+	return copyAccessToken(e.value.(*oauth2.AccessToken)), nil
+}
+
+func (repository *TokenRepository) GetRefreshToken(token string) (*oauth2.RefreshToken, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	e, ok := repository.refreshTokens.get(token)
+	if !ok {
+		return nil, oauth2.RefreshTokenNotFoundErr
+	}
+
+	return copyRefreshToken(e.value.(*oauth2.RefreshToken)), nil
+}
+
+func (repository *TokenRepository) GetAuthorizationCode(code string) (*oauth2.AuthorizationCode, error) {
+	repository.mutex.RLock()
+	defer repository.mutex.RUnlock()
+
+	e, ok := repository.authorizationCodes.get(code)
+	if !ok {
+		return nil, oauth2.AuthorizationCodeNotFoundErr
+	}
+
+	return copyAuthorizationCode(e.value.(*oauth2.AuthorizationCode)), nil
+}
+
+func (repository *TokenRepository) DeleteAccessToken(token string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	repository.accessTokens.delete(token)
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteRefreshToken(token string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	repository.refreshTokens.delete(token)
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteAuthorizationCode(code string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	// Someone else already consumed the code
+	if !repository.authorizationCodes.delete(code) {
+		return oauth2.AuthorizationCodeNotFoundErr
+	}
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteExpiredAccessTokens() error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	repository.accessTokens.deleteExpired(time.Now())
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteExpiredRefreshTokens() error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	repository.refreshTokens.deleteExpired(time.Now())
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteExpiredAuthorizationCodes() error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	repository.authorizationCodes.deleteExpired(time.Now())
+
+	return nil
+}
+
+func (repository *TokenRepository) RotateRefreshToken(token *oauth2.RefreshToken) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	e, ok := repository.refreshTokens.get(token.Token)
+	if !ok {
+		return oauth2.RefreshTokenAlreadyRotatedErr
+	}
+
+	refreshToken := e.value.(*oauth2.RefreshToken)
+	if refreshToken.IsRotated() {
+		return oauth2.RefreshTokenAlreadyRotatedErr
+	}
+
+	refreshToken.RotatedAt = token.RotatedAt
+	refreshToken.RotatedTo = token.RotatedTo
+	refreshToken.RotatedToAccessToken = token.RotatedToAccessToken
+
+	if refreshToken.FamilyId == "" {
+		refreshToken.FamilyId = token.FamilyId
+		repository.refreshTokens.updateFamilyId(e, token.FamilyId)
+	}
+
+	return nil
+}
+
+func (repository *TokenRepository) UpdateRefreshTokenExpiresAt(token string, expiresAt time.Time) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	e, ok := repository.refreshTokens.get(token)
+	if !ok {
+		return nil
+	}
+
+	e.value.(*oauth2.RefreshToken).ExpiresAt = expiresAt
+	repository.refreshTokens.updateExpiresAt(e, expiresAt)
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteTokenFamily(familyId string) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	repository.accessTokens.deleteFamily(familyId)
+	repository.refreshTokens.deleteFamily(familyId)
+
+	return nil
+}
+
+func (repository *TokenRepository) DeleteAccessTokensWithoutFamily(clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	repository.mutex.Lock()
+	defer repository.mutex.Unlock()
+
+	for token, e := range repository.accessTokens.tokens {
+		accessToken := e.value.(*oauth2.AccessToken)
+
+		if e.familyId == "" && accessToken.ClientId == clientId && accessToken.OwnerId == ownerId {
+			repository.accessTokens.delete(token)
+		}
+	}
+
+	return nil
+}
+
+func copyOauthToken(token *oauth2.OauthToken) *oauth2.OauthToken {
+	tokenCopy := *token
+	tokenCopy.Scopes = append([]string{}, token.Scopes...)
+
+	return &tokenCopy
+}
+
+func copyAccessToken(token *oauth2.AccessToken) *oauth2.AccessToken {
+	tokenCopy := *token
+	tokenCopy.OauthToken = copyOauthToken(token.OauthToken)
+	tokenCopy.Audience = append([]string(nil), token.Audience...)
+
+	return &tokenCopy
+}
+
+func copyRefreshToken(token *oauth2.RefreshToken) *oauth2.RefreshToken {
+	tokenCopy := *token
+	tokenCopy.OauthToken = copyOauthToken(token.OauthToken)
+
+	return &tokenCopy
+}
+
+func copyAuthorizationCode(code *oauth2.AuthorizationCode) *oauth2.AuthorizationCode {
+	codeCopy := *code
+	codeCopy.OauthToken = copyOauthToken(code.OauthToken)
+
+	return &codeCopy
+}
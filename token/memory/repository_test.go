@@ -0,0 +1,42 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/token/tokentest"
+)
+
+func TestTokenRepository(t *testing.T) {
+	tokentest.TestTokenRepository(t, func(t *testing.T) oauth2.TokenRepository {
+		return NewTokenRepository(RepositoryDefaultConfig)
+	})
+}
+
+func TestMaxTokens(t *testing.T) {
+	repository := NewTokenRepository(RepositoryConfig{MaxTokens: 2})
+
+	tokens := make([]*oauth2.AccessToken, 0)
+	for _, duration := range []time.Duration{time.Minute, time.Hour, 2 * time.Hour} {
+		token := oauth2.NewAccessToken("client", "owner", duration, nil)
+		token.Token = oauth2.GenerateRandomString(32)
+
+		if err := repository.CreateAccessToken(token); err != nil {
+			t.Fatalf("CreateAccessToken failed: %v", err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	// The token closest to expiring is evicted
+	if _, err := repository.GetAccessToken(tokens[0].Token); err != oauth2.AccessTokenNotFoundErr {
+		t.Errorf("GetAccessToken of the evicted token returned %v, expected AccessTokenNotFoundErr", err)
+	}
+
+	for _, token := range tokens[1:] {
+		if _, err := repository.GetAccessToken(token.Token); err != nil {
+			t.Errorf("GetAccessToken failed: %v", err)
+		}
+	}
+}
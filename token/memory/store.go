@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"container/heap"
+	"time"
+)
+
+type entry struct {
+	token     string
+	familyId  string
+	expiresAt time.Time
+	value     interface{}
+
+	// Position in the expiry heap
+	index int
+}
+
+// Tokens of a single type indexed by token, family and expiry, NOT safe for concurrent use
+type store struct {
+	tokens   map[string]*entry
+	families map[string]map[string]struct{}
+	expiry   expiryHeap
+}
+
+func newStore() *store {
+	return &store{
+		tokens:   map[string]*entry{},
+		families: map[string]map[string]struct{}{},
+	}
+}
+
+func (store *store) len() int {
+	return len(store.tokens)
+}
+
+func (store *store) get(token string) (*entry, bool) {
+	e, ok := store.tokens[token]
+
+	return e, ok
+}
+
+func (store *store) insert(e *entry) {
+	store.tokens[e.token] = e
+	heap.Push(&store.expiry, e)
+
+	if e.familyId != "" {
+		if store.families[e.familyId] == nil {
+			store.families[e.familyId] = map[string]struct{}{}
+		}
+
+		store.families[e.familyId][e.token] = struct{}{}
+	}
+}
+
+func (store *store) delete(token string) bool {
+	e, ok := store.tokens[token]
+	if !ok {
+		return false
+	}
+
+	delete(store.tokens, token)
+	heap.Remove(&store.expiry, e.index)
+
+	if family := store.families[e.familyId]; family != nil {
+		delete(family, token)
+
+		if len(family) == 0 {
+			delete(store.families, e.familyId)
+		}
+	}
+
+	return true
+}
+
+// Move a token without a family into the family
+func (store *store) updateFamilyId(e *entry, familyId string) {
+	if e.familyId != "" || familyId == "" {
+		return
+	}
+
+	e.familyId = familyId
+
+	if store.families[familyId] == nil {
+		store.families[familyId] = map[string]struct{}{}
+	}
+
+	store.families[familyId][e.token] = struct{}{}
+}
+
+func (store *store) updateExpiresAt(e *entry, expiresAt time.Time) {
+	e.expiresAt = expiresAt
+	heap.Fix(&store.expiry, e.index)
+}
+
+// The token closest to expiring, nil if the store is empty
+func (store *store) first() *entry {
+	if len(store.expiry) == 0 {
+		return nil
+	}
+
+	return store.expiry[0]
+}
+
+func (store *store) deleteExpired(now time.Time) {
+	for e := store.first(); e != nil && e.expiresAt.Before(now); e = store.first() {
+		store.delete(e.token)
+	}
+}
+
+func (store *store) deleteFamily(familyId string) {
+	for token := range store.families[familyId] {
+		store.delete(token)
+	}
+}
+
+// Min heap of entries ordered by expiry
+type expiryHeap []*entry
+
+func (h expiryHeap) Len() int {
+	return len(h)
+}
+
+func (h expiryHeap) Less(i, j int) bool {
+	return h[i].expiresAt.Before(h[j].expiresAt)
+}
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+
+	return e
+}
@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+// The stored representation of all token types
+type record struct {
+	Token           string                   `json:"token"`
+	ExpiresAt       time.Time                `json:"expires_at"`
+	Scopes          []string                 `json:"scopes"`
+	ClientId        string                   `json:"client_id"`
+	OwnerId         oauth2.OauthTokenOwnerId `json:"owner_id"`
+	FamilyId        string                   `json:"family_id,omitempty"`
+	AuthenticatedAt time.Time                `json:"authenticated_at"`
+
+	// Access tokens
+	Audience []string `json:"audience,omitempty"`
+	Actor    string   `json:"actor,omitempty"`
+
+	// Refresh tokens
+	RotatedAt            time.Time `json:"rotated_at,omitempty"`
+	RotatedTo            string    `json:"rotated_to,omitempty"`
+	RotatedToAccessToken string    `json:"rotated_to_access_token,omitempty"`
+
+	// Authorization codes
+	RedirectUri         string                     `json:"redirect_uri,omitempty"`
+	CodeChallenge       string                     `json:"code_challenge,omitempty"`
+	CodeChallengeMethod oauth2.CodeChallengeMethod `json:"code_challenge_method,omitempty"`
+}
+
+func newRecord(token *oauth2.OauthToken) *record {
+	return &record{
+		Token:           token.Token,
+		ExpiresAt:       token.ExpiresAt,
+		Scopes:          token.Scopes,
+		ClientId:        token.ClientId,
+		OwnerId:         token.OwnerId,
+		FamilyId:        token.FamilyId,
+		AuthenticatedAt: token.AuthenticatedAt,
+	}
+}
+
+func decodeRecord(value []byte) (*record, error) {
+	r := &record{}
+	if err := json.Unmarshal(value, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *record) encode() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *record) oauthToken() *oauth2.OauthToken {
+	scopes := r.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	return &oauth2.OauthToken{
+		Token:           r.Token,
+		ExpiresAt:       r.ExpiresAt,
+		Scopes:          scopes,
+		ClientId:        r.ClientId,
+		OwnerId:         r.OwnerId,
+		FamilyId:        r.FamilyId,
+		AuthenticatedAt: r.AuthenticatedAt,
+	}
+}
+
+func (r *record) accessToken() *oauth2.AccessToken {
+	return &oauth2.AccessToken{
+		OauthToken: r.oauthToken(),
+		Audience:   r.Audience,
+		Actor:      r.Actor,
+	}
+}
+
+func (r *record) refreshToken() *oauth2.RefreshToken {
+	return &oauth2.RefreshToken{
+		OauthToken:           r.oauthToken(),
+		RotatedAt:            r.RotatedAt,
+		RotatedTo:            r.RotatedTo,
+		RotatedToAccessToken: r.RotatedToAccessToken,
+	}
+}
+
+func (r *record) authorizationCode() *oauth2.AuthorizationCode {
+	return &oauth2.AuthorizationCode{
+		OauthToken:          r.oauthToken(),
+		RedirectUri:         r.RedirectUri,
+		CodeChallenge:       r.CodeChallenge,
+		CodeChallengeMethod: r.CodeChallengeMethod,
+	}
+}
+
+// Key TTL derived from the expiry, zero or less means the token has already expired
+func (r *record) ttl() time.Duration {
+	return time.Until(r.ExpiresAt)
+}
@@ -0,0 +1,567 @@
+package redis
+
+import (
+	"strconv"
+	"time"
+
+	goredis "github.com/go-redis/redis"
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+const (
+	kindAccessToken       = "access_token"
+	kindRefreshToken      = "refresh_token"
+	kindAuthorizationCode = "authorization_code"
+
+	// Attempts of optimistic transactions before giving up
+	maxTransactionAttempts = 5
+
+	// Tokens that are already expired when they are created are kept this long, or until the
+	// expired tokens are deleted, so they are reported as expired instead of unknown
+	expiredTokenTTL = time.Minute
+)
+
+var RepositoryDefaultConfig = RepositoryConfig{
+	KeyPrefix: "oauth2:",
+}
+
+type RepositoryConfig struct {
+	// Prepended to all keys
+	KeyPrefix string
+}
+
+// TokenRepository stores tokens as keys that expire together with the token. Access and refresh
+// tokens are indexed by owner, client and family in sorted sets scored by expiry. Every command
+// and transaction only touches a single key so the repository works with Redis Cluster.
+type TokenRepository struct {
+	client goredis.UniversalClient
+	config RepositoryConfig
+}
+
+func NewTokenRepository(client goredis.UniversalClient, config RepositoryConfig) *TokenRepository {
+	if client == nil {
+		panic("No redis client given to redis token repository")
+	}
+
+	return &TokenRepository{
+		client: client,
+		config: config,
+	}
+}
+
+func (repository *TokenRepository) tokenKey(kind, token string) string {
+	return repository.config.KeyPrefix + kind + ":" + token
+}
+
+func (repository *TokenRepository) indexKey(index, id, kind string) string {
+	return repository.config.KeyPrefix + index + ":" + id + ":" + kind + "s"
+}
+
+// Tokens stored while already expired, they are deleted by DeleteExpired*
+func (repository *TokenRepository) expiredKey(kind string) string {
+	return repository.config.KeyPrefix + "expired_" + kind + "s"
+}
+
+// Index keys of the token, the owner is empty for client credentials tokens
+func (repository *TokenRepository) indexKeys(kind string, r *record) []string {
+	keys := []string{repository.indexKey("client", r.ClientId, kind)}
+
+	if r.OwnerId != "" {
+		keys = append(keys, repository.indexKey("owner", string(r.OwnerId), kind))
+	}
+
+	if r.FamilyId != "" {
+		keys = append(keys, repository.indexKey("family", r.FamilyId, kind))
+	}
+
+	return keys
+}
+
+func (repository *TokenRepository) CreateAccessToken(token *oauth2.AccessToken) error {
+	r := newRecord(token.OauthToken)
+	r.Audience = token.Audience
+	r.Actor = token.Actor
+
+	return repository.create(kindAccessToken, r, true)
+}
+
+func (repository *TokenRepository) CreateRefreshToken(token *oauth2.RefreshToken) error {
+	r := newRecord(token.OauthToken)
+	r.RotatedAt = token.RotatedAt
+	r.RotatedTo = token.RotatedTo
+	r.RotatedToAccessToken = token.RotatedToAccessToken
+
+	return repository.create(kindRefreshToken, r, true)
+}
+
+func (repository *TokenRepository) CreateAuthorizationCode(code *oauth2.AuthorizationCode) error {
+	r := newRecord(code.OauthToken)
+	r.RedirectUri = code.RedirectUri
+	r.CodeChallenge = code.CodeChallenge
+	r.CodeChallengeMethod = code.CodeChallengeMethod
+
+	return repository.create(kindAuthorizationCode, r, false)
+}
+
+func (repository *TokenRepository) create(kind string, r *record, indexed bool) error {
+	ttl := r.ttl()
+
+	expired := ttl <= 0
+	if expired {
+		ttl = expiredTokenTTL
+	}
+
+	value, err := r.encode()
+	if err != nil {
+		return err
+	}
+
+	created, err := repository.client.SetNX(repository.tokenKey(kind, r.Token), value, ttl).Result()
+	if err != nil {
+		return err
+	} else if !created {
+		return oauth2.TokenAlreadyExistsErr
+	}
+
+	if !indexed && !expired {
+		return nil
+	}
+
+	_, err = repository.client.Pipelined(func(pipe goredis.Pipeliner) error {
+		if indexed {
+			repository.index(pipe, kind, r)
+		}
+
+		if expired {
+			pipe.ZAdd(repository.expiredKey(kind), goredis.Z{Score: expiryScore(r.ExpiresAt), Member: r.Token})
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+// Add the token to its indexes, each index is written separately since they can be in different slots
+func (repository *TokenRepository) index(pipe goredis.Pipeliner, kind string, r *record) {
+	for _, key := range repository.indexKeys(kind, r) {
+		pipe.ZAdd(key, goredis.Z{Score: expiryScore(r.ExpiresAt), Member: r.Token})
+	}
+}
+
+func (repository *TokenRepository) get(kind, token string) (*record, error) {
+	value, err := repository.client.Get(repository.tokenKey(kind, token)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRecord(value)
+}
+
+func (repository *TokenRepository) GetAccessToken(token string) (*oauth2.AccessToken, error) {
+	r, err := repository.get(kindAccessToken, token)
+	if err == goredis.Nil {
+		return nil, oauth2.AccessTokenNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	return r.accessToken(), nil
+}
+
+func (repository *TokenRepository) GetRefreshToken(token string) (*oauth2.RefreshToken, error) {
+	r, err := repository.get(kindRefreshToken, token)
+	if err == goredis.Nil {
+		return nil, oauth2.RefreshTokenNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	return r.refreshToken(), nil
+}
+
+func (repository *TokenRepository) GetAuthorizationCode(code string) (*oauth2.AuthorizationCode, error) {
+	r, err := repository.get(kindAuthorizationCode, code)
+	if err == goredis.Nil {
+		return nil, oauth2.AuthorizationCodeNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	return r.authorizationCode(), nil
+}
+
+// GetAccessTokensByOwner returns the unexpired access tokens of the owner
+func (repository *TokenRepository) GetAccessTokensByOwner(ownerId oauth2.OauthTokenOwnerId) ([]*oauth2.AccessToken, error) {
+	return repository.getAccessTokens(repository.indexKey("owner", string(ownerId), kindAccessToken))
+}
+
+// GetAccessTokensByClient returns the unexpired access tokens of the client
+func (repository *TokenRepository) GetAccessTokensByClient(clientId string) ([]*oauth2.AccessToken, error) {
+	return repository.getAccessTokens(repository.indexKey("client", clientId, kindAccessToken))
+}
+
+// GetRefreshTokensByOwner returns the unexpired refresh tokens of the owner
+func (repository *TokenRepository) GetRefreshTokensByOwner(ownerId oauth2.OauthTokenOwnerId) ([]*oauth2.RefreshToken, error) {
+	return repository.getRefreshTokens(repository.indexKey("owner", string(ownerId), kindRefreshToken))
+}
+
+// GetRefreshTokensByClient returns the unexpired refresh tokens of the client
+func (repository *TokenRepository) GetRefreshTokensByClient(clientId string) ([]*oauth2.RefreshToken, error) {
+	return repository.getRefreshTokens(repository.indexKey("client", clientId, kindRefreshToken))
+}
+
+func (repository *TokenRepository) getAccessTokens(indexKey string) ([]*oauth2.AccessToken, error) {
+	records, err := repository.getIndexed(indexKey, kindAccessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*oauth2.AccessToken, 0, len(records))
+	for _, r := range records {
+		tokens = append(tokens, r.accessToken())
+	}
+
+	return tokens, nil
+}
+
+func (repository *TokenRepository) getRefreshTokens(indexKey string) ([]*oauth2.RefreshToken, error) {
+	records, err := repository.getIndexed(indexKey, kindRefreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]*oauth2.RefreshToken, 0, len(records))
+	for _, r := range records {
+		tokens = append(tokens, r.refreshToken())
+	}
+
+	return tokens, nil
+}
+
+// Load the unexpired tokens of an index, tokens that no longer exist are removed from the index
+func (repository *TokenRepository) getIndexed(indexKey, kind string) ([]*record, error) {
+	tokens, err := repository.client.ZRangeByScore(indexKey, goredis.ZRangeBy{
+		Min: strconv.FormatFloat(expiryScore(time.Now()), 'f', -1, 64),
+		Max: "+inf",
+	}).Result()
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+
+	// The tokens are in different slots, get them one by one instead of with MGET
+	values := make([]*goredis.StringCmd, 0, len(tokens))
+
+	_, err = repository.client.Pipelined(func(pipe goredis.Pipeliner) error {
+		for _, token := range tokens {
+			values = append(values, pipe.Get(repository.tokenKey(kind, token)))
+		}
+
+		return nil
+	})
+	if err != nil && err != goredis.Nil {
+		return nil, err
+	}
+
+	records := make([]*record, 0, len(values))
+	stale := make([]interface{}, 0)
+
+	for i, value := range values {
+		bytes, err := value.Bytes()
+		if err == goredis.Nil {
+			stale = append(stale, tokens[i])
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		r, err := decodeRecord(bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, r)
+	}
+
+	if len(stale) > 0 {
+		if err = repository.client.ZRem(indexKey, stale...).Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+func (repository *TokenRepository) DeleteAccessToken(token string) error {
+	return repository.delete(kindAccessToken, token)
+}
+
+func (repository *TokenRepository) DeleteRefreshToken(token string) error {
+	return repository.delete(kindRefreshToken, token)
+}
+
+func (repository *TokenRepository) delete(kind, token string) error {
+	r, err := repository.get(kind, token)
+	if err == goredis.Nil {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	_, err = repository.client.Pipelined(func(pipe goredis.Pipeliner) error {
+		pipe.Del(repository.tokenKey(kind, token))
+
+		for _, key := range repository.indexKeys(kind, r) {
+			pipe.ZRem(key, token)
+		}
+
+		return nil
+	})
+
+	return err
+}
+
+func (repository *TokenRepository) DeleteAuthorizationCode(code string) error {
+	deleted, err := repository.client.Del(repository.tokenKey(kindAuthorizationCode, code)).Result()
+	if err != nil {
+		return err
+	}
+
+	// Someone else already consumed the code
+	if deleted == 0 {
+		return oauth2.AuthorizationCodeNotFoundErr
+	}
+
+	return nil
+}
+
+// DeleteExpiredAccessTokens removes expired tokens from the indexes, the tokens themselves expire by TTL
+func (repository *TokenRepository) DeleteExpiredAccessTokens() error {
+	if err := repository.deleteExpired(kindAccessToken); err != nil {
+		return err
+	}
+
+	return repository.deleteExpiredIndexed(kindAccessToken)
+}
+
+// DeleteExpiredRefreshTokens removes expired tokens from the indexes, the tokens themselves expire by TTL
+func (repository *TokenRepository) DeleteExpiredRefreshTokens() error {
+	if err := repository.deleteExpired(kindRefreshToken); err != nil {
+		return err
+	}
+
+	return repository.deleteExpiredIndexed(kindRefreshToken)
+}
+
+// DeleteExpiredAuthorizationCodes only deletes codes that were created expired, codes expire by TTL
+func (repository *TokenRepository) DeleteExpiredAuthorizationCodes() error {
+	return repository.deleteExpired(kindAuthorizationCode)
+}
+
+// Delete the tokens that were stored while already expired
+func (repository *TokenRepository) deleteExpired(kind string) error {
+	expiredKey := repository.expiredKey(kind)
+
+	tokens, err := repository.client.ZRange(expiredKey, 0, -1).Result()
+	if err != nil || len(tokens) == 0 {
+		return err
+	}
+
+	members := make([]interface{}, 0, len(tokens))
+
+	_, err = repository.client.Pipelined(func(pipe goredis.Pipeliner) error {
+		for _, token := range tokens {
+			pipe.Del(repository.tokenKey(kind, token))
+			members = append(members, token)
+		}
+
+		pipe.ZRem(expiredKey, members...)
+
+		return nil
+	})
+
+	return err
+}
+
+func (repository *TokenRepository) deleteExpiredIndexed(kind string) error {
+	match := repository.config.KeyPrefix + "*:" + kind + "s"
+	max := strconv.FormatFloat(expiryScore(time.Now()), 'f', -1, 64)
+
+	deleteExpired := func(client goredis.Cmdable) error {
+		iterator := client.Scan(0, match, 100).Iterator()
+
+		for iterator.Next() {
+			if err := client.ZRemRangeByScore(iterator.Val(), "-inf", "("+max).Err(); err != nil {
+				return err
+			}
+		}
+
+		return iterator.Err()
+	}
+
+	// Keys are spread over the masters of a cluster
+	if cluster, ok := repository.client.(*goredis.ClusterClient); ok {
+		return cluster.ForEachMaster(func(client *goredis.Client) error {
+			return deleteExpired(client)
+		})
+	}
+
+	return deleteExpired(repository.client)
+}
+
+func (repository *TokenRepository) RotateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.updateRefreshToken(token.Token, func(r *record) error {
+		if !r.RotatedAt.IsZero() {
+			return oauth2.RefreshTokenAlreadyRotatedErr
+		}
+
+		r.RotatedAt = token.RotatedAt
+		r.RotatedTo = token.RotatedTo
+		r.RotatedToAccessToken = token.RotatedToAccessToken
+
+		if r.FamilyId == "" {
+			r.FamilyId = token.FamilyId
+		}
+
+		return nil
+	}, oauth2.RefreshTokenAlreadyRotatedErr)
+}
+
+func (repository *TokenRepository) UpdateRefreshTokenExpiresAt(token string, expiresAt time.Time) error {
+	return repository.updateRefreshToken(token, func(r *record) error {
+		r.ExpiresAt = expiresAt
+
+		return nil
+	}, nil)
+}
+
+// Update a refresh token with an optimistic transaction, notFoundErr is returned for missing tokens.
+// Only the token key is part of the transaction, the indexes are updated afterwards since they can
+// be in other slots of a cluster
+func (repository *TokenRepository) updateRefreshToken(token string, update func(r *record) error, notFoundErr error) error {
+	key := repository.tokenKey(kindRefreshToken, token)
+
+	var updated *record
+
+	transaction := func(tx *goredis.Tx) error {
+		value, err := tx.Get(key).Bytes()
+		if err == goredis.Nil {
+			return notFoundErr
+		} else if err != nil {
+			return err
+		}
+
+		r, err := decodeRecord(value)
+		if err != nil {
+			return err
+		}
+
+		if err = update(r); err != nil {
+			return err
+		}
+
+		if value, err = r.encode(); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(func(pipe goredis.Pipeliner) error {
+			if ttl := r.ttl(); ttl > 0 {
+				pipe.Set(key, value, ttl)
+			} else {
+				pipe.Del(key)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		updated = r
+
+		return nil
+	}
+
+	for attempt := 0; attempt < maxTransactionAttempts; attempt++ {
+		err := repository.client.Watch(transaction, key)
+		if err == goredis.TxFailedErr {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		_, err = repository.client.Pipelined(func(pipe goredis.Pipeliner) error {
+			repository.index(pipe, kindRefreshToken, updated)
+
+			return nil
+		})
+
+		return err
+	}
+
+	return goredis.TxFailedErr
+}
+
+func (repository *TokenRepository) DeleteTokenFamily(familyId string) error {
+	accessTokensKey := repository.indexKey("family", familyId, kindAccessToken)
+	refreshTokensKey := repository.indexKey("family", familyId, kindRefreshToken)
+
+	var accessTokens, refreshTokens *goredis.StringSliceCmd
+
+	_, err := repository.client.Pipelined(func(pipe goredis.Pipeliner) error {
+		accessTokens = pipe.ZRange(accessTokensKey, 0, -1)
+		refreshTokens = pipe.ZRange(refreshTokensKey, 0, -1)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Owner and client indexes are cleaned up when they are read or expired
+	_, err = repository.client.Pipelined(func(pipe goredis.Pipeliner) error {
+		for _, token := range accessTokens.Val() {
+			pipe.Del(repository.tokenKey(kindAccessToken, token))
+		}
+
+		for _, token := range refreshTokens.Val() {
+			pipe.Del(repository.tokenKey(kindRefreshToken, token))
+		}
+
+		pipe.Del(accessTokensKey)
+		pipe.Del(refreshTokensKey)
+
+		return nil
+	})
+
+	return err
+}
+
+func (repository *TokenRepository) DeleteAccessTokensWithoutFamily(clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	indexKey := repository.indexKey("client", clientId, kindAccessToken)
+	if ownerId != "" {
+		indexKey = repository.indexKey("owner", string(ownerId), kindAccessToken)
+	}
+
+	records, err := repository.getIndexed(indexKey, kindAccessToken)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		if r.FamilyId != "" || r.ClientId != clientId || r.OwnerId != ownerId {
+			continue
+		}
+
+		if err = repository.delete(kindAccessToken, r.Token); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Index score of a token, the expiry in unix milliseconds
+func expiryScore(expiresAt time.Time) float64 {
+	return float64(expiresAt.UnixNano() / int64(time.Millisecond))
+}
@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis"
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/token/tokentest"
+)
+
+func newTestRepository(t *testing.T) *TokenRepository {
+	server := miniredis.RunT(t)
+
+	return NewTokenRepository(goredis.NewClient(&goredis.Options{Addr: server.Addr()}), RepositoryDefaultConfig)
+}
+
+func TestTokenRepository(t *testing.T) {
+	tokentest.TestTokenRepository(t, func(t *testing.T) oauth2.TokenRepository {
+		return newTestRepository(t)
+	})
+}
+
+func TestCreateExpiredToken(t *testing.T) {
+	repository := newTestRepository(t)
+
+	token := oauth2.NewAccessToken("client", "owner", -time.Minute, nil)
+	token.Token = oauth2.GenerateRandomString(32)
+
+	if err := repository.CreateAccessToken(token); err != nil {
+		t.Fatalf("CreateAccessToken failed: %v", err)
+	}
+
+	stored, err := repository.GetAccessToken(token.Token)
+	if err != nil {
+		t.Fatalf("GetAccessToken of an expired token failed: %v", err)
+	}
+
+	if !stored.IsExpired() {
+		t.Errorf("Stored token is not expired")
+	}
+
+	if err = repository.DeleteExpiredAccessTokens(); err != nil {
+		t.Fatalf("DeleteExpiredAccessTokens failed: %v", err)
+	}
+
+	if _, err = repository.GetAccessToken(token.Token); err != oauth2.AccessTokenNotFoundErr {
+		t.Errorf("GetAccessToken returned %v after DeleteExpiredAccessTokens, expected AccessTokenNotFoundErr", err)
+	}
+}
+
+func TestIndexes(t *testing.T) {
+	repository := newTestRepository(t)
+
+	refreshToken := oauth2.NewRefreshToken("client", "owner", time.Minute, nil)
+	refreshToken.Token = oauth2.GenerateRandomString(32)
+
+	if err := repository.CreateRefreshToken(refreshToken); err != nil {
+		t.Fatalf("CreateRefreshToken failed: %v", err)
+	}
+
+	// Moving the expiry must keep the token indexed after it would have expired
+	if err := repository.UpdateRefreshTokenExpiresAt(refreshToken.Token, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("UpdateRefreshTokenExpiresAt failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		get  func() ([]*oauth2.RefreshToken, error)
+	}{
+		{"owner", func() ([]*oauth2.RefreshToken, error) { return repository.GetRefreshTokensByOwner("owner") }},
+		{"client", func() ([]*oauth2.RefreshToken, error) { return repository.GetRefreshTokensByClient("client") }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens, err := test.get()
+			if err != nil {
+				t.Fatalf("Lookup failed: %v", err)
+			}
+
+			if len(tokens) != 1 || tokens[0].Token != refreshToken.Token {
+				t.Fatalf("Lookup returned %d tokens, expected the refresh token", len(tokens))
+			}
+
+			if tokens[0].ExpiresAt.Before(time.Now().Add(time.Minute)) {
+				t.Errorf("Lookup returned the old expiry")
+			}
+		})
+	}
+
+	if err := repository.DeleteRefreshToken(refreshToken.Token); err != nil {
+		t.Fatalf("DeleteRefreshToken failed: %v", err)
+	}
+
+	tokens, err := repository.GetRefreshTokensByOwner("owner")
+	if err != nil {
+		t.Fatalf("GetRefreshTokensByOwner failed: %v", err)
+	}
+
+	if len(tokens) != 0 {
+		t.Errorf("GetRefreshTokensByOwner returned %d tokens after delete", len(tokens))
+	}
+}
@@ -1,6 +1,7 @@
 package token
 
 import (
+	"context"
 	"time"
 
 	"github.com/go-pg/pg"
@@ -18,63 +19,243 @@ func NewTokenRepository(db *pg.DB) oauth2.TokenRepository {
 }
 
 func (repository *tokenRepository) CreateAccessToken(token *oauth2.AccessToken) error {
-	return repository.db.Insert(token)
+	return repository.CreateAccessTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) CreateAccessTokenContext(ctx context.Context, token *oauth2.AccessToken) error {
+	return insertError(repository.db.WithContext(ctx).Insert(token))
 }
 
 func (repository *tokenRepository) CreateRefreshToken(token *oauth2.RefreshToken) error {
-	return repository.db.Insert(token)
+	return repository.CreateRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) CreateRefreshTokenContext(ctx context.Context, token *oauth2.RefreshToken) error {
+	return insertError(repository.db.WithContext(ctx).Insert(token))
+}
+
+func (repository *tokenRepository) CreateAuthorizationCode(code *oauth2.AuthorizationCode) error {
+	return repository.CreateAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *tokenRepository) CreateAuthorizationCodeContext(ctx context.Context, code *oauth2.AuthorizationCode) error {
+	return insertError(repository.db.WithContext(ctx).Insert(code))
 }
 
 func (repository *tokenRepository) GetAccessToken(token string) (*oauth2.AccessToken, error) {
+	return repository.GetAccessTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) GetAccessTokenContext(ctx context.Context, token string) (*oauth2.AccessToken, error) {
 	accessToken := &oauth2.AccessToken{}
 
-	err := repository.db.Model(accessToken).Where("token = ?", token).Select()
+	err := repository.db.WithContext(ctx).Model(accessToken).Where("token = ?", token).Select()
 	if err == pg.ErrNoRows {
 		return nil, oauth2.AccessTokenNotFoundErr
+	} else if err != nil {
+		return nil, err
 	}
 
 	return accessToken, nil
 }
 
 func (repository *tokenRepository) GetRefreshToken(token string) (*oauth2.RefreshToken, error) {
+	return repository.GetRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) GetRefreshTokenContext(ctx context.Context, token string) (*oauth2.RefreshToken, error) {
 	refreshToken := &oauth2.RefreshToken{}
 
-	err := repository.db.Model(refreshToken).Where("token = ?", token).Select()
+	err := repository.db.WithContext(ctx).Model(refreshToken).Where("token = ?", token).Select()
 	if err == pg.ErrNoRows {
 		return nil, oauth2.RefreshTokenNotFoundErr
+	} else if err != nil {
+		return nil, err
 	}
 
 	return refreshToken, nil
 }
 
+func (repository *tokenRepository) GetAuthorizationCode(code string) (*oauth2.AuthorizationCode, error) {
+	return repository.GetAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *tokenRepository) GetAuthorizationCodeContext(ctx context.Context, code string) (*oauth2.AuthorizationCode, error) {
+	authorizationCode := &oauth2.AuthorizationCode{}
+
+	err := repository.db.WithContext(ctx).Model(authorizationCode).Where("token = ?", code).Select()
+	if err == pg.ErrNoRows {
+		return nil, oauth2.AuthorizationCodeNotFoundErr
+	} else if err != nil {
+		return nil, err
+	}
+
+	return authorizationCode, nil
+}
+
 func (repository *tokenRepository) DeleteAccessToken(token string) error {
+	return repository.DeleteAccessTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) DeleteAccessTokenContext(ctx context.Context, token string) error {
 	accessToken := &oauth2.AccessToken{}
 
-	_, err := repository.db.Model(accessToken).Where("token = ?", token).Delete()
+	_, err := repository.db.WithContext(ctx).Model(accessToken).Where("token = ?", token).Delete()
 
 	return err
 }
 
 func (repository *tokenRepository) DeleteRefreshToken(token string) error {
+	return repository.DeleteRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) DeleteRefreshTokenContext(ctx context.Context, token string) error {
 	refreshToken := &oauth2.RefreshToken{}
 
-	_, err := repository.db.Model(refreshToken).Where("token = ?", token).Delete()
+	_, err := repository.db.WithContext(ctx).Model(refreshToken).Where("token = ?", token).Delete()
 
 	return err
 }
 
+func (repository *tokenRepository) DeleteAuthorizationCode(code string) error {
+	return repository.DeleteAuthorizationCodeContext(context.Background(), code)
+}
+
+func (repository *tokenRepository) DeleteAuthorizationCodeContext(ctx context.Context, code string) error {
+	authorizationCode := &oauth2.AuthorizationCode{}
+
+	res, err := repository.db.WithContext(ctx).Model(authorizationCode).Where("token = ?", code).Delete()
+	if err != nil {
+		return err
+	}
+
+	// Someone else already consumed the code
+	if res.RowsAffected() == 0 {
+		return oauth2.AuthorizationCodeNotFoundErr
+	}
+
+	return nil
+}
+
 func (repository *tokenRepository) DeleteExpiredAccessTokens() error {
+	return repository.DeleteExpiredAccessTokensContext(context.Background())
+}
+
+func (repository *tokenRepository) DeleteExpiredAccessTokensContext(ctx context.Context) error {
 	accessToken := &oauth2.AccessToken{}
 
-	_, err := repository.db.Model(accessToken).Where("expires_at < ?", time.Now()).Delete()
+	_, err := repository.db.WithContext(ctx).Model(accessToken).Where("expires_at < ?", time.Now()).Delete()
 
 	return err
 }
 
 func (repository *tokenRepository) DeleteExpiredRefreshTokens() error {
+	return repository.DeleteExpiredRefreshTokensContext(context.Background())
+}
+
+func (repository *tokenRepository) DeleteExpiredRefreshTokensContext(ctx context.Context) error {
 	refreshToken := &oauth2.RefreshToken{}
 
-	_, err := repository.db.Model(refreshToken).Where("expires_at < ?", time.Now()).Delete()
+	_, err := repository.db.WithContext(ctx).Model(refreshToken).Where("expires_at < ?", time.Now()).Delete()
+
+	return err
+}
+
+func (repository *tokenRepository) DeleteExpiredAuthorizationCodes() error {
+	return repository.DeleteExpiredAuthorizationCodesContext(context.Background())
+}
+
+func (repository *tokenRepository) DeleteExpiredAuthorizationCodesContext(ctx context.Context) error {
+	authorizationCode := &oauth2.AuthorizationCode{}
+
+	_, err := repository.db.WithContext(ctx).Model(authorizationCode).Where("expires_at < ?", time.Now()).Delete()
+
+	return err
+}
+
+func (repository *tokenRepository) RotateRefreshToken(token *oauth2.RefreshToken) error {
+	return repository.RotateRefreshTokenContext(context.Background(), token)
+}
+
+func (repository *tokenRepository) RotateRefreshTokenContext(ctx context.Context, token *oauth2.RefreshToken) error {
+	refreshToken := &oauth2.RefreshToken{}
+
+	// Only update tokens that are not rotated yet so concurrent rotations have a single winner
+	res, err := repository.db.WithContext(ctx).Model(refreshToken).
+		Set("rotated_at = ?", token.RotatedAt).
+		Set("rotated_to = ?", token.RotatedTo).
+		Set("rotated_to_access_token = ?", token.RotatedToAccessToken).
+		Set("family_id = COALESCE(NULLIF(family_id, ''), ?)", token.FamilyId).
+		Where("token = ?", token.Token).
+		Where("rotated_at IS NULL").
+		Update()
+	if err != nil {
+		return err
+	}
+
+	if res.RowsAffected() == 0 {
+		return oauth2.RefreshTokenAlreadyRotatedErr
+	}
+
+	return nil
+}
+
+func (repository *tokenRepository) UpdateRefreshTokenExpiresAt(token string, expiresAt time.Time) error {
+	return repository.UpdateRefreshTokenExpiresAtContext(context.Background(), token, expiresAt)
+}
+
+func (repository *tokenRepository) UpdateRefreshTokenExpiresAtContext(ctx context.Context, token string, expiresAt time.Time) error {
+	refreshToken := &oauth2.RefreshToken{}
+
+	_, err := repository.db.WithContext(ctx).Model(refreshToken).Set("expires_at = ?", expiresAt).Where("token = ?", token).Update()
+
+	return err
+}
+
+func (repository *tokenRepository) DeleteTokenFamily(familyId string) error {
+	return repository.DeleteTokenFamilyContext(context.Background(), familyId)
+}
+
+func (repository *tokenRepository) DeleteTokenFamilyContext(ctx context.Context, familyId string) error {
+	return repository.db.WithContext(ctx).RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Model(&oauth2.AccessToken{}).Where("family_id = ?", familyId).Delete(); err != nil {
+			return err
+		}
+
+		_, err := tx.Model(&oauth2.RefreshToken{}).Where("family_id = ?", familyId).Delete()
+
+		return err
+	})
+}
+
+func (repository *tokenRepository) DeleteAccessTokensWithoutFamily(clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	return repository.DeleteAccessTokensWithoutFamilyContext(context.Background(), clientId, ownerId)
+}
+
+func (repository *tokenRepository) DeleteAccessTokensWithoutFamilyContext(ctx context.Context, clientId string, ownerId oauth2.OauthTokenOwnerId) error {
+	_, err := repository.db.WithContext(ctx).Model(&oauth2.AccessToken{}).
+		Where("client_id = ?", clientId).
+		Where("owner_id = ?", ownerId).
+		Where("(family_id IS NULL OR family_id = '')").
+		Delete()
+
+	return err
+}
+
+// SQLSTATE of unique violations, the other integrity violations are real errors
+const uniqueViolation = "23505"
+
+func isUniqueViolation(err error) bool {
+	pgErr, ok := err.(pg.Error)
+
+	return ok && pgErr.Field('C') == uniqueViolation
+}
+
+// Report primary key violations as TokenAlreadyExistsErr so the server can generate a new token
+func insertError(err error) error {
+	if isUniqueViolation(err) {
+		return oauth2.TokenAlreadyExistsErr
+	}
 
 	return err
 }
@@ -0,0 +1,88 @@
+package token
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/interactive-solutions/go-oauth2"
+	"github.com/interactive-solutions/go-oauth2/token/tokentest"
+)
+
+// Set to a Postgres url, e.g. postgres://postgres@localhost:5432/oauth2_test?sslmode=disable, to
+// run the tests against a database. The tables are created if they don't exist.
+const postgresDsnEnv = "OAUTH2_TEST_POSTGRES_DSN"
+
+func newTestDb(t *testing.T) *pg.DB {
+	dsn := os.Getenv(postgresDsnEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", postgresDsnEnv)
+	}
+
+	options, err := pg.ParseURL(dsn)
+	if err != nil {
+		t.Fatalf("Invalid %s: %v", postgresDsnEnv, err)
+	}
+
+	db := pg.Connect(options)
+	t.Cleanup(func() { db.Close() })
+
+	models := []interface{}{
+		&oauth2.AccessToken{},
+		&oauth2.RefreshToken{},
+		&oauth2.AuthorizationCode{},
+	}
+
+	for _, model := range models {
+		if err = db.CreateTable(model, &orm.CreateTableOptions{IfNotExists: true}); err != nil {
+			t.Fatalf("Failed to create table: %v", err)
+		}
+	}
+
+	return db
+}
+
+func TestTokenRepository(t *testing.T) {
+	db := newTestDb(t)
+
+	tokentest.TestTokenRepository(t, func(t *testing.T) oauth2.TokenRepository {
+		return NewTokenRepository(db)
+	})
+}
+
+func TestInsertError(t *testing.T) {
+	db := newTestDb(t)
+
+	token := oauth2.GenerateRandomString(32)
+	if _, err := db.Exec("INSERT INTO oauth_access_tokens (token) VALUES (?)", token); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		duplicate bool
+	}{
+		{name: "duplicate key", query: "INSERT INTO oauth_access_tokens (token) VALUES (?)", duplicate: true},
+		{name: "not null violation", query: "INSERT INTO oauth_access_tokens (token) VALUES (NULL)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := db.Exec(test.query, token)
+			if err == nil {
+				t.Fatalf("Insert succeeded, expected a constraint violation")
+			}
+
+			// Only duplicates may make the server generate a new token, everything else is an error
+			if test.duplicate != (insertError(err) == oauth2.TokenAlreadyExistsErr) {
+				t.Errorf("insertError(%v) returned %v", err, insertError(err))
+			}
+
+			if !test.duplicate && insertError(err) != err {
+				t.Errorf("insertError changed the error to %v", insertError(err))
+			}
+		})
+	}
+}
@@ -0,0 +1,617 @@
+// Package tokentest checks that a TokenRepository implements the full contract expected by the server
+// and the grants, call TestTokenRepository from a test of the repository:
+//
+//	func TestTokenRepository(t *testing.T) {
+//		tokentest.TestTokenRepository(t, func(t *testing.T) oauth2.TokenRepository {
+//			return memory.NewTokenRepository(memory.RepositoryDefaultConfig)
+//		})
+//	}
+package tokentest
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/interactive-solutions/go-oauth2"
+)
+
+// Factory returns the repository under test, it may return the same repository for all tests
+type Factory func(t *testing.T) oauth2.TokenRepository
+
+// Times are compared with this precision since databases truncate them
+const timePrecision = time.Millisecond
+
+// Number of goroutines used by the concurrency tests
+const concurrency = 16
+
+// TestTokenRepository runs all tests of the TokenRepository contract as subtests
+func TestTokenRepository(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, repository oauth2.TokenRepository)
+	}{
+		{"AccessTokenRoundTrip", testAccessTokenRoundTrip},
+		{"RefreshTokenRoundTrip", testRefreshTokenRoundTrip},
+		{"AuthorizationCodeRoundTrip", testAuthorizationCodeRoundTrip},
+		{"ScopesRoundTrip", testScopesRoundTrip},
+		{"NotFound", testNotFound},
+		{"DuplicateToken", testDuplicateToken},
+		{"DeleteTokens", testDeleteTokens},
+		{"DeleteAuthorizationCodeOnce", testDeleteAuthorizationCodeOnce},
+		{"ExpiredTokensUntilDeleted", testExpiredTokensUntilDeleted},
+		{"DeleteExpiredTokens", testDeleteExpiredTokens},
+		{"RotateRefreshToken", testRotateRefreshToken},
+		{"RotateRefreshTokenWithoutFamily", testRotateRefreshTokenWithoutFamily},
+		{"UpdateRefreshTokenExpiresAt", testUpdateRefreshTokenExpiresAt},
+		{"DeleteTokenFamily", testDeleteTokenFamily},
+		{"DeleteAccessTokensWithoutFamily", testDeleteAccessTokensWithoutFamily},
+		{"ConcurrentAccess", testConcurrentAccess},
+		{"ConcurrentAuthorizationCodeDelete", testConcurrentAuthorizationCodeDelete},
+		{"ConcurrentRotation", testConcurrentRotation},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			test.test(t, factory(t))
+		})
+	}
+}
+
+func newAccessToken(duration time.Duration, scopes []string) *oauth2.AccessToken {
+	token := oauth2.NewAccessToken("tokentest-client", "tokentest-owner", duration, scopes)
+	token.Token = oauth2.GenerateRandomString(32)
+	token.FamilyId = oauth2.NewTokenFamilyId()
+
+	return token
+}
+
+func newRefreshToken(duration time.Duration, scopes []string) *oauth2.RefreshToken {
+	token := oauth2.NewRefreshToken("tokentest-client", "tokentest-owner", duration, scopes)
+	token.Token = oauth2.GenerateRandomString(32)
+	token.FamilyId = oauth2.NewTokenFamilyId()
+
+	return token
+}
+
+func newAuthorizationCode(duration time.Duration) *oauth2.AuthorizationCode {
+	code := oauth2.NewAuthorizationCode("tokentest-client", "tokentest-owner", duration, []string{"read"}, "https://client.example.com/callback")
+	code.Token = oauth2.GenerateRandomString(32)
+	code.CodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	code.CodeChallengeMethod = oauth2.CodeChallengeMethodS256
+
+	return code
+}
+
+func testAccessTokenRoundTrip(t *testing.T, repository oauth2.TokenRepository) {
+	token := newAccessToken(time.Hour, []string{"read", "write"})
+	token.AuthenticatedAt = time.Now().Add(-time.Minute)
+	token.Audience = []string{"https://api.example.com", "https://other.example.com"}
+	token.Actor = "tokentest-actor"
+
+	mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(token))
+
+	stored, err := repository.GetAccessToken(token.Token)
+	mustNotFail(t, "GetAccessToken", err)
+	assertOauthToken(t, token.OauthToken, stored.OauthToken)
+
+	if strings.Join(stored.Audience, " ") != strings.Join(token.Audience, " ") {
+		t.Errorf("Audience is %q, expected %q", stored.Audience, token.Audience)
+	}
+
+	if stored.Actor != token.Actor {
+		t.Errorf("Actor is %q, expected %q", stored.Actor, token.Actor)
+	}
+}
+
+func testRefreshTokenRoundTrip(t *testing.T, repository oauth2.TokenRepository) {
+	token := newRefreshToken(time.Hour, []string{"read", "write"})
+	token.AuthenticatedAt = time.Now().Add(-time.Minute)
+
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(token))
+
+	stored, err := repository.GetRefreshToken(token.Token)
+	mustNotFail(t, "GetRefreshToken", err)
+	assertOauthToken(t, token.OauthToken, stored.OauthToken)
+
+	if stored.IsRotated() {
+		t.Errorf("new refresh token is rotated, RotatedAt %s", stored.RotatedAt)
+	}
+}
+
+func testAuthorizationCodeRoundTrip(t *testing.T, repository oauth2.TokenRepository) {
+	code := newAuthorizationCode(time.Minute)
+
+	mustNotFail(t, "CreateAuthorizationCode", repository.CreateAuthorizationCode(code))
+
+	stored, err := repository.GetAuthorizationCode(code.Token)
+	mustNotFail(t, "GetAuthorizationCode", err)
+	assertOauthToken(t, code.OauthToken, stored.OauthToken)
+
+	if stored.RedirectUri != code.RedirectUri {
+		t.Errorf("RedirectUri is %q, expected %q", stored.RedirectUri, code.RedirectUri)
+	}
+
+	if stored.CodeChallenge != code.CodeChallenge || stored.CodeChallengeMethod != code.CodeChallengeMethod {
+		t.Errorf(
+			"code challenge is %q (%s), expected %q (%s)",
+			stored.CodeChallenge, stored.CodeChallengeMethod, code.CodeChallenge, code.CodeChallengeMethod,
+		)
+	}
+}
+
+func testScopesRoundTrip(t *testing.T, repository oauth2.TokenRepository) {
+	for _, scopes := range [][]string{
+		nil,
+		{},
+		{"read"},
+		{"read:user", "https://api.example.com/write", "offline_access"},
+	} {
+		token := newAccessToken(time.Hour, scopes)
+
+		mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(token))
+
+		stored, err := repository.GetAccessToken(token.Token)
+		mustNotFail(t, "GetAccessToken", err)
+		assertScopes(t, scopes, stored.Scopes)
+	}
+}
+
+func testNotFound(t *testing.T, repository oauth2.TokenRepository) {
+	unknown := oauth2.GenerateRandomString(32)
+
+	if _, err := repository.GetAccessToken(unknown); err != oauth2.AccessTokenNotFoundErr {
+		t.Errorf("GetAccessToken of an unknown token returned %v, expected AccessTokenNotFoundErr", err)
+	}
+
+	if _, err := repository.GetRefreshToken(unknown); err != oauth2.RefreshTokenNotFoundErr {
+		t.Errorf("GetRefreshToken of an unknown token returned %v, expected RefreshTokenNotFoundErr", err)
+	}
+
+	if _, err := repository.GetAuthorizationCode(unknown); err != oauth2.AuthorizationCodeNotFoundErr {
+		t.Errorf("GetAuthorizationCode of an unknown code returned %v, expected AuthorizationCodeNotFoundErr", err)
+	}
+
+	if err := repository.DeleteAuthorizationCode(unknown); err != oauth2.AuthorizationCodeNotFoundErr {
+		t.Errorf("DeleteAuthorizationCode of an unknown code returned %v, expected AuthorizationCodeNotFoundErr", err)
+	}
+
+	// Rotating a missing token must never succeed
+	token := newRefreshToken(time.Hour, nil)
+	token.RotatedAt = time.Now()
+
+	if err := repository.RotateRefreshToken(token); err == nil {
+		t.Error("RotateRefreshToken of an unknown token succeeded")
+	}
+}
+
+func testDuplicateToken(t *testing.T, repository oauth2.TokenRepository) {
+	accessToken := newAccessToken(time.Hour, nil)
+	mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(accessToken))
+
+	if err := repository.CreateAccessToken(accessToken); err != oauth2.TokenAlreadyExistsErr {
+		t.Errorf("CreateAccessToken of an existing token returned %v, expected TokenAlreadyExistsErr", err)
+	}
+
+	refreshToken := newRefreshToken(time.Hour, nil)
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(refreshToken))
+
+	if err := repository.CreateRefreshToken(refreshToken); err != oauth2.TokenAlreadyExistsErr {
+		t.Errorf("CreateRefreshToken of an existing token returned %v, expected TokenAlreadyExistsErr", err)
+	}
+
+	code := newAuthorizationCode(time.Minute)
+	mustNotFail(t, "CreateAuthorizationCode", repository.CreateAuthorizationCode(code))
+
+	if err := repository.CreateAuthorizationCode(code); err != oauth2.TokenAlreadyExistsErr {
+		t.Errorf("CreateAuthorizationCode of an existing code returned %v, expected TokenAlreadyExistsErr", err)
+	}
+}
+
+func testDeleteTokens(t *testing.T, repository oauth2.TokenRepository) {
+	accessToken := newAccessToken(time.Hour, nil)
+	refreshToken := newRefreshToken(time.Hour, nil)
+
+	mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(accessToken))
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(refreshToken))
+
+	mustNotFail(t, "DeleteAccessToken", repository.DeleteAccessToken(accessToken.Token))
+	mustNotFail(t, "DeleteRefreshToken", repository.DeleteRefreshToken(refreshToken.Token))
+
+	if _, err := repository.GetAccessToken(accessToken.Token); err != oauth2.AccessTokenNotFoundErr {
+		t.Errorf("GetAccessToken of a deleted token returned %v, expected AccessTokenNotFoundErr", err)
+	}
+
+	if _, err := repository.GetRefreshToken(refreshToken.Token); err != oauth2.RefreshTokenNotFoundErr {
+		t.Errorf("GetRefreshToken of a deleted token returned %v, expected RefreshTokenNotFoundErr", err)
+	}
+
+	// Revocation deletes tokens that may already be gone
+	mustNotFail(t, "DeleteAccessToken of a deleted token", repository.DeleteAccessToken(accessToken.Token))
+	mustNotFail(t, "DeleteRefreshToken of a deleted token", repository.DeleteRefreshToken(refreshToken.Token))
+}
+
+func testDeleteAuthorizationCodeOnce(t *testing.T, repository oauth2.TokenRepository) {
+	code := newAuthorizationCode(time.Minute)
+	mustNotFail(t, "CreateAuthorizationCode", repository.CreateAuthorizationCode(code))
+
+	mustNotFail(t, "DeleteAuthorizationCode", repository.DeleteAuthorizationCode(code.Token))
+
+	if err := repository.DeleteAuthorizationCode(code.Token); err != oauth2.AuthorizationCodeNotFoundErr {
+		t.Errorf("second DeleteAuthorizationCode returned %v, expected AuthorizationCodeNotFoundErr", err)
+	}
+
+	if _, err := repository.GetAuthorizationCode(code.Token); err != oauth2.AuthorizationCodeNotFoundErr {
+		t.Errorf("GetAuthorizationCode of a deleted code returned %v, expected AuthorizationCodeNotFoundErr", err)
+	}
+}
+
+// Expired tokens are returned until they are deleted, the grants check the expiry themselves
+func testExpiredTokensUntilDeleted(t *testing.T, repository oauth2.TokenRepository) {
+	accessToken := newAccessToken(-time.Minute, nil)
+	refreshToken := newRefreshToken(-time.Minute, nil)
+	code := newAuthorizationCode(-time.Minute)
+
+	mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(accessToken))
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(refreshToken))
+	mustNotFail(t, "CreateAuthorizationCode", repository.CreateAuthorizationCode(code))
+
+	storedAccessToken, err := repository.GetAccessToken(accessToken.Token)
+	mustNotFail(t, "GetAccessToken of an expired token", err)
+	assertOauthToken(t, accessToken.OauthToken, storedAccessToken.OauthToken)
+
+	storedRefreshToken, err := repository.GetRefreshToken(refreshToken.Token)
+	mustNotFail(t, "GetRefreshToken of an expired token", err)
+	assertOauthToken(t, refreshToken.OauthToken, storedRefreshToken.OauthToken)
+
+	storedCode, err := repository.GetAuthorizationCode(code.Token)
+	mustNotFail(t, "GetAuthorizationCode of an expired code", err)
+	assertOauthToken(t, code.OauthToken, storedCode.OauthToken)
+
+	if !storedAccessToken.IsExpired() || !storedRefreshToken.IsExpired() || !storedCode.IsExpired() {
+		t.Errorf("Expired tokens are returned as unexpired")
+	}
+}
+
+func testDeleteExpiredTokens(t *testing.T, repository oauth2.TokenRepository) {
+	expiredAccessToken := newAccessToken(-time.Minute, nil)
+	accessToken := newAccessToken(time.Hour, nil)
+	expiredRefreshToken := newRefreshToken(-time.Minute, nil)
+	refreshToken := newRefreshToken(time.Hour, nil)
+	expiredCode := newAuthorizationCode(-time.Minute)
+	code := newAuthorizationCode(time.Hour)
+
+	mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(expiredAccessToken))
+	mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(accessToken))
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(expiredRefreshToken))
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(refreshToken))
+	mustNotFail(t, "CreateAuthorizationCode", repository.CreateAuthorizationCode(expiredCode))
+	mustNotFail(t, "CreateAuthorizationCode", repository.CreateAuthorizationCode(code))
+
+	mustNotFail(t, "DeleteExpiredAccessTokens", repository.DeleteExpiredAccessTokens())
+	mustNotFail(t, "DeleteExpiredRefreshTokens", repository.DeleteExpiredRefreshTokens())
+	mustNotFail(t, "DeleteExpiredAuthorizationCodes", repository.DeleteExpiredAuthorizationCodes())
+
+	if _, err := repository.GetAccessToken(expiredAccessToken.Token); err != oauth2.AccessTokenNotFoundErr {
+		t.Errorf("GetAccessToken of an expired token returned %v after DeleteExpiredAccessTokens", err)
+	}
+
+	if _, err := repository.GetRefreshToken(expiredRefreshToken.Token); err != oauth2.RefreshTokenNotFoundErr {
+		t.Errorf("GetRefreshToken of an expired token returned %v after DeleteExpiredRefreshTokens", err)
+	}
+
+	if _, err := repository.GetAuthorizationCode(expiredCode.Token); err != oauth2.AuthorizationCodeNotFoundErr {
+		t.Errorf("GetAuthorizationCode of an expired code returned %v after DeleteExpiredAuthorizationCodes", err)
+	}
+
+	if _, err := repository.GetAccessToken(accessToken.Token); err != nil {
+		t.Errorf("DeleteExpiredAccessTokens deleted an unexpired token: %v", err)
+	}
+
+	if _, err := repository.GetRefreshToken(refreshToken.Token); err != nil {
+		t.Errorf("DeleteExpiredRefreshTokens deleted an unexpired token: %v", err)
+	}
+
+	if _, err := repository.GetAuthorizationCode(code.Token); err != nil {
+		t.Errorf("DeleteExpiredAuthorizationCodes deleted an unexpired code: %v", err)
+	}
+}
+
+func testRotateRefreshToken(t *testing.T, repository oauth2.TokenRepository) {
+	token := newRefreshToken(time.Hour, []string{"read"})
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(token))
+
+	token.RotatedAt = time.Now()
+	token.RotatedTo = oauth2.GenerateRandomString(32)
+	token.RotatedToAccessToken = oauth2.GenerateRandomString(32)
+
+	mustNotFail(t, "RotateRefreshToken", repository.RotateRefreshToken(token))
+
+	if err := repository.RotateRefreshToken(token); err != oauth2.RefreshTokenAlreadyRotatedErr {
+		t.Errorf("second RotateRefreshToken returned %v, expected RefreshTokenAlreadyRotatedErr", err)
+	}
+
+	stored, err := repository.GetRefreshToken(token.Token)
+	mustNotFail(t, "GetRefreshToken", err)
+
+	assertTime(t, "RotatedAt", token.RotatedAt, stored.RotatedAt)
+
+	if stored.RotatedTo != token.RotatedTo || stored.RotatedToAccessToken != token.RotatedToAccessToken {
+		t.Errorf(
+			"rotated to %q and %q, expected %q and %q",
+			stored.RotatedTo, stored.RotatedToAccessToken, token.RotatedTo, token.RotatedToAccessToken,
+		)
+	}
+}
+
+// Tokens issued before families existed join the family of their successor when rotated
+func testRotateRefreshTokenWithoutFamily(t *testing.T, repository oauth2.TokenRepository) {
+	token := newRefreshToken(time.Hour, nil)
+	token.FamilyId = ""
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(token))
+
+	successor := newRefreshToken(time.Hour, nil)
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(successor))
+
+	token.FamilyId = successor.FamilyId
+	token.RotatedAt = time.Now()
+	token.RotatedTo = successor.Token
+	mustNotFail(t, "RotateRefreshToken", repository.RotateRefreshToken(token))
+
+	stored, err := repository.GetRefreshToken(token.Token)
+	mustNotFail(t, "GetRefreshToken", err)
+
+	if stored.FamilyId != successor.FamilyId {
+		t.Fatalf("family of the rotated token is %q, expected %q", stored.FamilyId, successor.FamilyId)
+	}
+
+	mustNotFail(t, "DeleteTokenFamily", repository.DeleteTokenFamily(successor.FamilyId))
+
+	if _, err = repository.GetRefreshToken(token.Token); err != oauth2.RefreshTokenNotFoundErr {
+		t.Errorf("GetRefreshToken of the rotated token returned %v after deleting its family, expected RefreshTokenNotFoundErr", err)
+	}
+}
+
+func testUpdateRefreshTokenExpiresAt(t *testing.T, repository oauth2.TokenRepository) {
+	token := newRefreshToken(time.Hour, nil)
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(token))
+
+	expiresAt := time.Now().Add(2 * time.Hour)
+	mustNotFail(t, "UpdateRefreshTokenExpiresAt", repository.UpdateRefreshTokenExpiresAt(token.Token, expiresAt))
+
+	stored, err := repository.GetRefreshToken(token.Token)
+	mustNotFail(t, "GetRefreshToken", err)
+	assertTime(t, "ExpiresAt", expiresAt, stored.ExpiresAt)
+}
+
+func testDeleteTokenFamily(t *testing.T, repository oauth2.TokenRepository) {
+	accessToken := newAccessToken(time.Hour, nil)
+	refreshToken := newRefreshToken(time.Hour, nil)
+	refreshToken.FamilyId = accessToken.FamilyId
+
+	otherAccessToken := newAccessToken(time.Hour, nil)
+	otherRefreshToken := newRefreshToken(time.Hour, nil)
+
+	mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(accessToken))
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(refreshToken))
+	mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(otherAccessToken))
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(otherRefreshToken))
+
+	mustNotFail(t, "DeleteTokenFamily", repository.DeleteTokenFamily(accessToken.FamilyId))
+
+	if _, err := repository.GetAccessToken(accessToken.Token); err != oauth2.AccessTokenNotFoundErr {
+		t.Errorf("GetAccessToken of a token in a deleted family returned %v, expected AccessTokenNotFoundErr", err)
+	}
+
+	if _, err := repository.GetRefreshToken(refreshToken.Token); err != oauth2.RefreshTokenNotFoundErr {
+		t.Errorf("GetRefreshToken of a token in a deleted family returned %v, expected RefreshTokenNotFoundErr", err)
+	}
+
+	if _, err := repository.GetAccessToken(otherAccessToken.Token); err != nil {
+		t.Errorf("DeleteTokenFamily deleted an access token of another family: %v", err)
+	}
+
+	if _, err := repository.GetRefreshToken(otherRefreshToken.Token); err != nil {
+		t.Errorf("DeleteTokenFamily deleted a refresh token of another family: %v", err)
+	}
+}
+
+func testDeleteAccessTokensWithoutFamily(t *testing.T, repository oauth2.TokenRepository) {
+	// Unique client and owner since the repository may be shared with the other tests
+	clientId := "tokentest-client-" + oauth2.GenerateRandomString(8)
+	ownerId := oauth2.OauthTokenOwnerId("tokentest-owner-" + oauth2.GenerateRandomString(8))
+
+	newToken := func(clientId string, ownerId oauth2.OauthTokenOwnerId, familyId string) *oauth2.AccessToken {
+		token := oauth2.NewAccessToken(clientId, ownerId, time.Hour, nil)
+		token.Token = oauth2.GenerateRandomString(32)
+		token.FamilyId = familyId
+
+		mustNotFail(t, "CreateAccessToken", repository.CreateAccessToken(token))
+
+		return token
+	}
+
+	tests := []struct {
+		name    string
+		token   *oauth2.AccessToken
+		deleted bool
+	}{
+		{"without family", newToken(clientId, ownerId, ""), true},
+		{"with family", newToken(clientId, ownerId, oauth2.NewTokenFamilyId()), false},
+		{"of another owner", newToken(clientId, ownerId+"-other", ""), false},
+		{"of another client", newToken(clientId+"-other", ownerId, ""), false},
+	}
+
+	mustNotFail(t, "DeleteAccessTokensWithoutFamily", repository.DeleteAccessTokensWithoutFamily(clientId, ownerId))
+
+	for _, test := range tests {
+		_, err := repository.GetAccessToken(test.token.Token)
+		if test.deleted && err != oauth2.AccessTokenNotFoundErr {
+			t.Errorf("GetAccessToken of a token %s returned %v, expected AccessTokenNotFoundErr", test.name, err)
+		} else if !test.deleted && err != nil {
+			t.Errorf("DeleteAccessTokensWithoutFamily deleted a token %s: %v", test.name, err)
+		}
+	}
+}
+
+func testConcurrentAccess(t *testing.T, repository oauth2.TokenRepository) {
+	errs := make(chan error, concurrency*3)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			token := newAccessToken(time.Hour, []string{"read"})
+			if err := repository.CreateAccessToken(token); err != nil {
+				errs <- err
+				return
+			}
+
+			if _, err := repository.GetAccessToken(token.Token); err != nil {
+				errs <- err
+				return
+			}
+
+			if err := repository.DeleteAccessToken(token.Token); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("concurrent create, get and delete failed: %v", err)
+	}
+}
+
+func testConcurrentAuthorizationCodeDelete(t *testing.T, repository oauth2.TokenRepository) {
+	code := newAuthorizationCode(time.Minute)
+	mustNotFail(t, "CreateAuthorizationCode", repository.CreateAuthorizationCode(code))
+
+	succeeded := countSucceeded(t, oauth2.AuthorizationCodeNotFoundErr, func() error {
+		return repository.DeleteAuthorizationCode(code.Token)
+	})
+
+	if succeeded != 1 {
+		t.Errorf("%d concurrent DeleteAuthorizationCode calls succeeded, expected exactly 1", succeeded)
+	}
+}
+
+func testConcurrentRotation(t *testing.T, repository oauth2.TokenRepository) {
+	token := newRefreshToken(time.Hour, nil)
+	mustNotFail(t, "CreateRefreshToken", repository.CreateRefreshToken(token))
+
+	succeeded := countSucceeded(t, oauth2.RefreshTokenAlreadyRotatedErr, func() error {
+		rotation := *token
+		rotation.RotatedAt = time.Now()
+		rotation.RotatedTo = oauth2.GenerateRandomString(32)
+
+		return repository.RotateRefreshToken(&rotation)
+	})
+
+	if succeeded != 1 {
+		t.Errorf("%d concurrent RotateRefreshToken calls succeeded, expected exactly 1", succeeded)
+	}
+}
+
+// Run the function concurrently, it may only fail with the expected error
+func countSucceeded(t *testing.T, expectedErr error, fn func() error) int {
+	t.Helper()
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	succeeded := 0
+	start := make(chan struct{})
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			<-start
+
+			err := fn()
+
+			mutex.Lock()
+			defer mutex.Unlock()
+
+			if err == nil {
+				succeeded++
+			} else if err != expectedErr {
+				t.Errorf("unexpected error %v, expected %v", err, expectedErr)
+			}
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	return succeeded
+}
+
+func mustNotFail(t *testing.T, operation string, err error) {
+	t.Helper()
+
+	if err != nil {
+		t.Fatalf("%s failed: %v", operation, err)
+	}
+}
+
+func assertOauthToken(t *testing.T, expected, actual *oauth2.OauthToken) {
+	t.Helper()
+
+	if actual.Token != expected.Token {
+		t.Errorf("Token is %q, expected %q", actual.Token, expected.Token)
+	}
+
+	if actual.ClientId != expected.ClientId {
+		t.Errorf("ClientId is %q, expected %q", actual.ClientId, expected.ClientId)
+	}
+
+	if actual.OwnerId != expected.OwnerId {
+		t.Errorf("OwnerId is %q, expected %q", actual.OwnerId, expected.OwnerId)
+	}
+
+	if actual.FamilyId != expected.FamilyId {
+		t.Errorf("FamilyId is %q, expected %q", actual.FamilyId, expected.FamilyId)
+	}
+
+	assertTime(t, "ExpiresAt", expected.ExpiresAt, actual.ExpiresAt)
+	assertTime(t, "AuthenticatedAt", expected.AuthenticatedAt, actual.AuthenticatedAt)
+	assertScopes(t, expected.Scopes, actual.Scopes)
+}
+
+// Nil and empty scopes are the same
+func assertScopes(t *testing.T, expected, actual []string) {
+	t.Helper()
+
+	if len(actual) != len(expected) {
+		t.Errorf("Scopes are %q, expected %q", actual, expected)
+		return
+	}
+
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("Scopes are %q, expected %q", actual, expected)
+			return
+		}
+	}
+}
+
+func assertTime(t *testing.T, field string, expected, actual time.Time) {
+	t.Helper()
+
+	difference := actual.Sub(expected)
+	if difference < -timePrecision || difference > timePrecision {
+		t.Errorf("%s is %s, expected %s", field, actual, expected)
+	}
+}
@@ -0,0 +1,156 @@
+package oauth2
+
+import (
+	"errors"
+	"hash/crc32"
+	"math"
+	"strings"
+)
+
+const (
+	base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+	// 62^6 exceeds 2^32 so any CRC32 fits
+	checksumLength = 6
+)
+
+var ChecksumTokenGeneratorDefaultConfig = ChecksumTokenGeneratorConfig{
+	Length:              32,
+	Prefixes:            ChecksumTokenDefaultPrefixes,
+	AllowUnknownFormats: false,
+}
+
+// Prefixes used by ParseToken, recognizable by secret scanners like GitHub's ghp_ tokens
+var ChecksumTokenDefaultPrefixes = map[TokenKind]string{
+	TokenKindAccessToken:       "oat_",
+	TokenKindRefreshToken:      "ort_",
+	TokenKindAuthorizationCode: "oac_",
+	TokenKindDeviceCode:        "odc_",
+}
+
+type ChecksumTokenGeneratorConfig struct {
+	// Bytes of entropy in each token
+	Length int
+
+	// Prefix for each kind of token, prefixes MUST NOT be prefixes of each other
+	Prefixes map[TokenKind]string
+
+	// Accept tokens without a known prefix, e.g. tokens issued before the format was enabled
+	AllowUnknownFormats bool
+}
+
+// TokenVerifier is implemented by token generators whose tokens can be checked without a lookup
+type TokenVerifier interface {
+	VerifyToken(kind TokenKind, token string) error
+}
+
+type ParsedToken struct {
+	Kind     TokenKind
+	Prefix   string
+	Body     string
+	Checksum string
+}
+
+type checksumTokenGenerator struct {
+	config ChecksumTokenGeneratorConfig
+}
+
+// NewChecksumTokenGenerator generates tokens formatted as prefix, base62 body and a base62 CRC32
+// checksum of the prefix and body, e.g. oat_1fZ0...Qx3aK9p
+func NewChecksumTokenGenerator(config ChecksumTokenGeneratorConfig) TokenGenerator {
+	if config.Length <= 0 {
+		panic("Token length must be positive")
+	}
+
+	return &checksumTokenGenerator{config: config}
+}
+
+func (generator *checksumTokenGenerator) GenerateToken(kind TokenKind) (string, error) {
+	prefix, ok := generator.config.Prefixes[kind]
+	if !ok {
+		return "", errors.New("No token prefix configured for " + string(kind))
+	}
+
+	length := int(math.Ceil(float64(generator.config.Length*8) / math.Log2(float64(len(base62Alphabet)))))
+
+	body, err := randomAlphabetString(base62Alphabet, length)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + body + tokenChecksum(prefix+body), nil
+}
+
+func (generator *checksumTokenGenerator) VerifyToken(kind TokenKind, token string) error {
+	parsed, err := ParseTokenWithPrefixes(token, generator.config.Prefixes)
+	if err == InvalidTokenFormatErr && generator.config.AllowUnknownFormats {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if parsed.Kind != kind {
+		return InvalidTokenFormatErr
+	}
+
+	return nil
+}
+
+// ParseToken checks the format and checksum of a token using the default prefixes, it does
+// not tell whether the token has been issued but can be used offline, e.g. by secret scanners
+func ParseToken(token string) (*ParsedToken, error) {
+	return ParseTokenWithPrefixes(token, ChecksumTokenDefaultPrefixes)
+}
+
+// ParseTokenWithPrefixes is ParseToken for tokens generated with other prefixes
+func ParseTokenWithPrefixes(token string, prefixes map[TokenKind]string) (*ParsedToken, error) {
+	for kind, prefix := range prefixes {
+		if !strings.HasPrefix(token, prefix) {
+			continue
+		}
+
+		rest := token[len(prefix):]
+		if len(rest) <= checksumLength || !isBase62(rest) {
+			return nil, InvalidTokenFormatErr
+		}
+
+		body := rest[:len(rest)-checksumLength]
+		checksum := rest[len(rest)-checksumLength:]
+
+		if tokenChecksum(prefix+body) != checksum {
+			return nil, InvalidTokenChecksumErr
+		}
+
+		return &ParsedToken{
+			Kind:     kind,
+			Prefix:   prefix,
+			Body:     body,
+			Checksum: checksum,
+		}, nil
+	}
+
+	return nil, InvalidTokenFormatErr
+}
+
+// Base62 encoded CRC32, left padded to a fixed length
+func tokenChecksum(value string) string {
+	checksum := crc32.ChecksumIEEE([]byte(value))
+	encoded := make([]byte, checksumLength)
+
+	for i := checksumLength - 1; i >= 0; i-- {
+		encoded[i] = base62Alphabet[checksum%62]
+		checksum /= 62
+	}
+
+	return string(encoded)
+}
+
+func isBase62(value string) bool {
+	for _, c := range value {
+		if !strings.ContainsRune(base62Alphabet, c) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,136 @@
+package oauth2
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseToken(t *testing.T) {
+	generator := NewChecksumTokenGenerator(ChecksumTokenGeneratorDefaultConfig)
+
+	for kind, prefix := range ChecksumTokenDefaultPrefixes {
+		t.Run(string(kind), func(t *testing.T) {
+			token, err := generator.GenerateToken(kind)
+			if err != nil {
+				t.Fatalf("GenerateToken failed: %v", err)
+			}
+
+			parsed, err := ParseToken(token)
+			if err != nil {
+				t.Fatalf("ParseToken of %q failed: %v", token, err)
+			}
+
+			if parsed.Kind != kind || parsed.Prefix != prefix || parsed.Prefix+parsed.Body+parsed.Checksum != token {
+				t.Errorf("Parsed %q as %+v", token, parsed)
+			}
+
+			// 32 bytes of entropy need 43 base62 characters
+			if len(parsed.Body) != 43 || len(parsed.Checksum) != checksumLength {
+				t.Errorf("Body has length %d and checksum %d", len(parsed.Body), len(parsed.Checksum))
+			}
+		})
+	}
+}
+
+func TestParseTokenRejection(t *testing.T) {
+	token, err := NewChecksumTokenGenerator(ChecksumTokenGeneratorDefaultConfig).GenerateToken(TokenKindAccessToken)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	// Change a character of the body without leaving the alphabet
+	typo := []byte(token)
+	if typo[10] == 'a' {
+		typo[10] = 'b'
+	} else {
+		typo[10] = 'a'
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{name: "empty", token: "", err: InvalidTokenFormatErr},
+		{name: "unknown prefix", token: "xyz_" + token[4:], err: InvalidTokenFormatErr},
+		{name: "prefix only", token: "oat_", err: InvalidTokenFormatErr},
+		{name: "checksum only", token: "oat_" + token[len(token)-checksumLength:], err: InvalidTokenFormatErr},
+		{name: "not base62", token: token[:10] + "-" + token[11:], err: InvalidTokenFormatErr},
+		{name: "wrong checksum", token: string(typo), err: InvalidTokenChecksumErr},
+		{name: "truncated", token: token[:len(token)-1], err: InvalidTokenChecksumErr},
+		{name: "over-long", token: token + "a", err: InvalidTokenChecksumErr},
+		{name: "repeated", token: token + token[4:], err: InvalidTokenChecksumErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseToken(test.token); err != test.err {
+				t.Errorf("ParseToken of %q returned %v, expected %v", test.token, err, test.err)
+			}
+		})
+	}
+}
+
+func TestParseTokenWithPrefixes(t *testing.T) {
+	prefixes := map[TokenKind]string{TokenKindAccessToken: "acme_at_", TokenKindRefreshToken: "acme_rt_"}
+
+	config := ChecksumTokenGeneratorDefaultConfig
+	config.Prefixes = prefixes
+
+	token, err := NewChecksumTokenGenerator(config).GenerateToken(TokenKindRefreshToken)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	parsed, err := ParseTokenWithPrefixes(token, prefixes)
+	if err != nil || parsed.Kind != TokenKindRefreshToken {
+		t.Errorf("ParseTokenWithPrefixes of %q returned %+v %v", token, parsed, err)
+	}
+
+	// The default prefixes don't know the token
+	if _, err = ParseToken(token); err != InvalidTokenFormatErr {
+		t.Errorf("ParseToken returned %v, expected %v", err, InvalidTokenFormatErr)
+	}
+}
+
+func TestChecksumTokenVerification(t *testing.T) {
+	generator := NewChecksumTokenGenerator(ChecksumTokenGeneratorDefaultConfig)
+
+	config := ChecksumTokenGeneratorDefaultConfig
+	config.AllowUnknownFormats = true
+	lenient := NewChecksumTokenGenerator(config)
+
+	accessToken, err := generator.GenerateToken(TokenKindAccessToken)
+	if err != nil {
+		t.Fatalf("GenerateToken failed: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		generator TokenGenerator
+		kind      TokenKind
+		token     string
+		err       error
+	}{
+		{name: "valid", generator: generator, kind: TokenKindAccessToken, token: accessToken},
+		{name: "another kind", generator: generator, kind: TokenKindRefreshToken, token: accessToken, err: InvalidTokenFormatErr},
+		{name: "unknown format", generator: generator, kind: TokenKindAccessToken, token: strings.Repeat("a", 32), err: InvalidTokenFormatErr},
+		{name: "unknown format allowed", generator: lenient, kind: TokenKindAccessToken, token: strings.Repeat("a", 32)},
+		{
+			// A known prefix is always checked, even when unknown formats are allowed
+			name:      "wrong checksum with unknown formats allowed",
+			generator: lenient,
+			kind:      TokenKindAccessToken,
+			token:     accessToken + "a",
+			err:       InvalidTokenChecksumErr,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.generator.(TokenVerifier).VerifyToken(test.kind, test.token); err != test.err {
+				t.Errorf("VerifyToken returned %v, expected %v", err, test.err)
+			}
+		})
+	}
+}
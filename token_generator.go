@@ -0,0 +1,112 @@
+package oauth2
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"math"
+)
+
+// What a generated token is used for, allows generators to format them differently
+type TokenKind string
+
+const (
+	TokenKindAccessToken       TokenKind = "access_token"
+	TokenKindRefreshToken      TokenKind = "refresh_token"
+	TokenKindAuthorizationCode TokenKind = "authorization_code"
+	TokenKindDeviceCode        TokenKind = "device_code"
+)
+
+type TokenGenerator interface {
+	GenerateToken(kind TokenKind) (string, error)
+}
+
+type TokenEncoding string
+
+const (
+	TokenEncodingBase64Url TokenEncoding = "base64url"
+	TokenEncodingHex       TokenEncoding = "hex"
+	TokenEncodingAlphabet  TokenEncoding = "alphabet"
+)
+
+var (
+	RandomTokenGeneratorDefaultConfig = RandomTokenGeneratorConfig{
+		Length:   32,
+		Encoding: TokenEncodingBase64Url,
+		Alphabet: letters,
+		Prefixes: map[TokenKind]string{},
+	}
+
+	// Generates the tokens of NewAccessToken and the other constructors, the server
+	// uses it unless another generator is configured
+	DefaultTokenGenerator = NewRandomTokenGenerator(RandomTokenGeneratorDefaultConfig)
+)
+
+type RandomTokenGeneratorConfig struct {
+	// Bytes of entropy in each token
+	Length int
+
+	// How the random bytes are encoded, the alphabet encoding uses enough characters for Length bytes
+	Encoding TokenEncoding
+	Alphabet string
+
+	// Prepended to tokens of the kind, e.g. "at_" for access tokens
+	Prefixes map[TokenKind]string
+}
+
+type randomTokenGenerator struct {
+	config RandomTokenGeneratorConfig
+}
+
+// NewRandomTokenGenerator generates tokens from crypto/rand
+func NewRandomTokenGenerator(config RandomTokenGeneratorConfig) TokenGenerator {
+	if config.Length <= 0 {
+		panic("Token length must be positive")
+	}
+
+	if config.Encoding == TokenEncodingAlphabet && (len(config.Alphabet) < 2 || len(config.Alphabet) > 256) {
+		panic("Token alphabet must contain between 2 and 256 characters")
+	}
+
+	return &randomTokenGenerator{config: config}
+}
+
+func (generator *randomTokenGenerator) GenerateToken(kind TokenKind) (string, error) {
+	body, err := generator.generateBody()
+	if err != nil {
+		return "", err
+	}
+
+	return generator.config.Prefixes[kind] + body, nil
+}
+
+func (generator *randomTokenGenerator) generateBody() (string, error) {
+	if generator.config.Encoding == TokenEncodingAlphabet {
+		bitsPerCharacter := math.Log2(float64(len(generator.config.Alphabet)))
+		length := int(math.Ceil(float64(generator.config.Length*8) / bitsPerCharacter))
+
+		return randomAlphabetString(generator.config.Alphabet, length)
+	}
+
+	bytes := make([]byte, generator.config.Length)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	if generator.config.Encoding == TokenEncodingHex {
+		return hex.EncodeToString(bytes), nil
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+// InsertUniqueToken calls insert until it succeeds or fails with another error than
+// TokenAlreadyExistsErr, at most retries + 1 times. Insert MUST generate a new token on each call.
+func InsertUniqueToken(retries int, insert func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := insert()
+		if err != TokenAlreadyExistsErr || attempt >= retries {
+			return err
+		}
+	}
+}
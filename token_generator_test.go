@@ -0,0 +1,182 @@
+package oauth2
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRandomTokenGenerator(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RandomTokenGeneratorConfig
+		// Decodes the token body into the random bytes, nil if the encoding can't be decoded
+		decode func(body string) ([]byte, error)
+		length int
+	}{
+		{
+			name:   "base64url",
+			config: RandomTokenGeneratorDefaultConfig,
+			decode: base64.RawURLEncoding.DecodeString,
+			length: 43,
+		},
+		{
+			name:   "hex",
+			config: RandomTokenGeneratorConfig{Length: 16, Encoding: TokenEncodingHex},
+			decode: hex.DecodeString,
+			length: 32,
+		},
+		{
+			name:   "alphabet",
+			config: RandomTokenGeneratorConfig{Length: 16, Encoding: TokenEncodingAlphabet, Alphabet: "0123456789abcdef"},
+			length: 32,
+		},
+		{
+			// 128 bits of entropy need 26 characters of 5 bits each
+			name:   "alphabet with a partial bit",
+			config: RandomTokenGeneratorConfig{Length: 16, Encoding: TokenEncodingAlphabet, Alphabet: "abcdefghijklmnopqrstuvwxyz234567"},
+			length: 26,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			generator := NewRandomTokenGenerator(test.config)
+			tokens := make(map[string]bool)
+
+			for i := 0; i < 1000; i++ {
+				token, err := generator.GenerateToken(TokenKindAccessToken)
+				if err != nil {
+					t.Fatalf("GenerateToken failed: %v", err)
+				}
+
+				if len(token) != test.length {
+					t.Fatalf("Token %q has length %d, expected %d", token, len(token), test.length)
+				}
+
+				if test.decode != nil {
+					decoded, err := test.decode(token)
+					if err != nil || len(decoded) != test.config.Length {
+						t.Fatalf("Token %q decodes to %d bytes, expected %d: %v", token, len(decoded), test.config.Length, err)
+					}
+				}
+
+				if test.config.Encoding == TokenEncodingAlphabet && strings.Trim(token, test.config.Alphabet) != "" {
+					t.Fatalf("Token %q contains characters outside the alphabet", token)
+				}
+
+				tokens[token] = true
+			}
+
+			if len(tokens) != 1000 {
+				t.Errorf("Generated %d unique tokens, expected 1000", len(tokens))
+			}
+		})
+	}
+}
+
+func TestRandomTokenGeneratorPrefixes(t *testing.T) {
+	config := RandomTokenGeneratorDefaultConfig
+	config.Prefixes = map[TokenKind]string{
+		TokenKindAccessToken:  "at_",
+		TokenKindRefreshToken: "rt_",
+	}
+
+	generator := NewRandomTokenGenerator(config)
+
+	expected := map[TokenKind]string{
+		TokenKindAccessToken:       "at_",
+		TokenKindRefreshToken:      "rt_",
+		TokenKindAuthorizationCode: "",
+	}
+
+	for kind, prefix := range expected {
+		token, err := generator.GenerateToken(kind)
+		if err != nil {
+			t.Fatalf("GenerateToken failed: %v", err)
+		}
+
+		// The prefix is prepended to a body of the full length
+		if !strings.HasPrefix(token, prefix) || len(token) != len(prefix)+43 {
+			t.Errorf("Token %q of kind %q does not have the prefix %q", token, kind, prefix)
+		}
+	}
+}
+
+func TestRandomTokenGeneratorConfig(t *testing.T) {
+	configs := map[string]RandomTokenGeneratorConfig{
+		"no length":       {Encoding: TokenEncodingBase64Url},
+		"empty alphabet":  {Length: 32, Encoding: TokenEncodingAlphabet},
+		"single alphabet": {Length: 32, Encoding: TokenEncodingAlphabet, Alphabet: "a"},
+	}
+
+	for name, config := range configs {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewRandomTokenGenerator did not panic")
+				}
+			}()
+
+			NewRandomTokenGenerator(config)
+		})
+	}
+}
+
+func TestTokenConstructorsGenerateTokens(t *testing.T) {
+	tokens := map[string]string{
+		"access token":       NewAccessToken("client", "owner", time.Hour, nil).Token,
+		"refresh token":      NewRefreshToken("client", "owner", time.Hour, nil).Token,
+		"authorization code": NewAuthorizationCode("client", "owner", time.Hour, nil, "https://example.com/callback").Token,
+		"device code":        NewDeviceCode("client", time.Hour, nil, 5*time.Second).Token,
+	}
+
+	for name, token := range tokens {
+		if len(token) != 43 {
+			t.Errorf("The %s constructor generated %q, expected a token from DefaultTokenGenerator", name, token)
+		}
+	}
+}
+
+func TestInsertUniqueToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures []error
+		retries  int
+		attempts int
+		err      error
+	}{
+		{name: "first attempt", retries: 3, attempts: 1},
+		{name: "after collisions", failures: []error{TokenAlreadyExistsErr, TokenAlreadyExistsErr}, retries: 3, attempts: 3},
+		{
+			name:     "retries exhausted",
+			failures: []error{TokenAlreadyExistsErr, TokenAlreadyExistsErr},
+			retries:  1,
+			attempts: 2,
+			err:      TokenAlreadyExistsErr,
+		},
+		{name: "other error", failures: []error{AccessTokenNotFoundErr}, retries: 3, attempts: 1, err: AccessTokenNotFoundErr},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+
+			err := InsertUniqueToken(test.retries, func() error {
+				attempts++
+
+				if attempts <= len(test.failures) {
+					return test.failures[attempts-1]
+				}
+
+				return nil
+			})
+
+			if err != test.err || attempts != test.attempts {
+				t.Errorf("Returned %v after %d attempts, expected %v after %d", err, attempts, test.err, test.attempts)
+			}
+		})
+	}
+}
@@ -1,16 +1,44 @@
 package oauth2
 
 import (
-	"math/rand"
+	"crypto/rand"
 )
 
 const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 
+// GenerateRandomString returns a string of letters and digits read from crypto/rand
 func GenerateRandomString(length uint) string {
-	bytes := make([]byte, length)
-	for i := range bytes {
-		bytes[i] = letters[rand.Intn(len(letters))]
+	str, err := randomAlphabetString(letters, int(length))
+	if err != nil {
+		panic(err)
 	}
 
-	return string(bytes)
+	return str
+}
+
+// Pick characters from the alphabet without modulo bias by rejecting bytes above the largest multiple
+func randomAlphabetString(alphabet string, length int) (string, error) {
+	max := 256 - 256%len(alphabet)
+	result := make([]byte, 0, length)
+	buffer := make([]byte, length)
+
+	for len(result) < length {
+		if _, err := rand.Read(buffer); err != nil {
+			return "", err
+		}
+
+		for _, b := range buffer {
+			if int(b) >= max {
+				continue
+			}
+
+			result = append(result, alphabet[int(b)%len(alphabet)])
+
+			if len(result) == length {
+				break
+			}
+		}
+	}
+
+	return string(result), nil
 }